@@ -0,0 +1,194 @@
+// Package admin provides an authenticated handler exposing profiling,
+// runtime and router information. Mount it on a separate listener:
+//
+//	level := new(slog.LevelVar)
+//	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+//	r.Use(middleware.Audit(middleware.WithLogger(logger)))
+//
+//	go http.ListenAndServe("127.0.0.1:6060", admin.New(
+//		admin.BearerToken(os.Getenv("ADMIN_TOKEN")),
+//		admin.WithRouter(r),
+//		admin.WithLevel(level),
+//	))
+//
+// or under a path with http.StripPrefix("/admin", admin.New(...)).
+package admin
+
+import (
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	router "github.com/vhellman/lw-router"
+)
+
+type options struct {
+	router *router.Router
+	level  *slog.LevelVar
+}
+
+// Option configures the admin handler
+type Option func(*options)
+
+// WithRouter exposes the middleware chain and route table of r
+func WithRouter(r *router.Router) Option {
+	return func(o *options) {
+		o.router = r
+	}
+}
+
+// WithLevel enables switching the level of the loggers using level at runtime
+func WithLevel(level *slog.LevelVar) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// New creates the admin handler. Every request must pass auth; New panics
+// if auth is nil so the handler can never be exposed unauthenticated.
+func New(auth Authenticator, opts ...Option) http.Handler {
+	if auth == nil {
+		panic("admin: authenticator is required")
+	}
+
+	options := &options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	mux := http.NewServeMux()
+	endpoints := []string{"/debug/pprof/", "/debug/vars", "/runtime", "/buildinfo"}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /runtime", runtimeStats)
+	mux.HandleFunc("GET /buildinfo", buildInfo)
+	if options.router != nil {
+		endpoints = append(endpoints, "/middleware", "/routes")
+		mux.HandleFunc("GET /middleware", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, options.router.Middlewares())
+		})
+		mux.HandleFunc("GET /routes", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, options.router.Routes())
+		})
+	}
+	if options.level != nil {
+		endpoints = append(endpoints, "/loglevel")
+		mux.HandleFunc("GET /loglevel", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]string{"level": options.level.Level().String()})
+		})
+		mux.HandleFunc("PUT /loglevel", func(w http.ResponseWriter, r *http.Request) {
+			setLevel(w, r, options.level)
+		})
+	}
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, endpoints)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.Authenticate(r) {
+			if challenge := auth.Challenge(); challenge != "" {
+				w.Header().Set("WWW-Authenticate", challenge)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+type runtimeResponse struct {
+	GoVersion  string `json:"goVersion"`
+	Goroutines int    `json:"goroutines"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	NumCPU     int    `json:"numCPU"`
+	HeapAlloc  uint64 `json:"heapAlloc"`
+	HeapInuse  uint64 `json:"heapInuse"`
+	NumGC      uint32 `json:"numGC"`
+}
+
+func runtimeStats(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeJSON(w, http.StatusOK, runtimeResponse{
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		HeapAlloc:  mem.HeapAlloc,
+		HeapInuse:  mem.HeapInuse,
+		NumGC:      mem.NumGC,
+	})
+}
+
+type buildInfoResponse struct {
+	GoVersion string            `json:"goVersion"`
+	Path      string            `json:"path"`
+	Main      string            `json:"main"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+	Deps      map[string]string `json:"deps"`
+}
+
+func buildInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "Build info not available", http.StatusNotFound)
+		return
+	}
+
+	resp := buildInfoResponse{
+		GoVersion: info.GoVersion,
+		Path:      info.Path,
+		Main:      info.Main.Path,
+		Version:   info.Main.Version,
+		Settings:  map[string]string{},
+		Deps:      map[string]string{},
+	}
+	for _, s := range info.Settings {
+		resp.Settings[s.Key] = s.Value
+	}
+	for _, dep := range info.Deps {
+		resp.Deps[dep.Path] = dep.Version
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// setLevel accepts the level as a "level" query parameter or a JSON body
+// of the form {"level": "debug"}
+func setLevel(w http.ResponseWriter, r *http.Request, level *slog.LevelVar) {
+	name := r.URL.Query().Get("level")
+	if name == "" {
+		var body struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		name = body.Level
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(name))); err != nil {
+		http.Error(w, "Invalid level", http.StatusBadRequest)
+		return
+	}
+	level.Set(l)
+	writeJSON(w, http.StatusOK, map[string]string{"level": l.String()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	router "github.com/vhellman/lw-router"
+	"github.com/vhellman/lw-router/middleware"
+	"github.com/vhellman/lw-router/routertest"
+)
+
+const testToken = "secret-token"
+
+func newTestAdmin() (http.Handler, *slog.LevelVar) {
+	r := router.New()
+	r.Use(middleware.RequestID())
+	r.Use(middleware.Logger)
+	r.RouteFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+
+	level := new(slog.LevelVar)
+	return New(BearerToken(testToken), WithRouter(r), WithLevel(level)), level
+}
+
+func TestAdmin_RequiresAuth(t *testing.T) {
+	handler, _ := newTestAdmin()
+
+	routertest.Get("/runtime").Do(t, handler).AssertStatus(http.StatusUnauthorized)
+	routertest.Get("/runtime").
+		Header("Authorization", "Bearer wrong").
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+}
+
+func TestAdmin_NilAuthPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected New to panic without an authenticator")
+		}
+	}()
+	New(nil)
+}
+
+func TestAdmin_Endpoints(t *testing.T) {
+	handler, _ := newTestAdmin()
+	get := func(path string) *routertest.Response {
+		return routertest.Get(path).Header("Authorization", "Bearer "+testToken).Do(t, handler)
+	}
+
+	get("/runtime").AssertStatus(http.StatusOK).AssertBodyContains(`"goroutines"`)
+	get("/buildinfo").AssertStatus(http.StatusOK).AssertBodyContains(`"goVersion"`)
+	get("/debug/vars").AssertStatus(http.StatusOK).AssertBodyContains(`"memstats"`)
+	get("/debug/pprof/").AssertStatus(http.StatusOK).AssertBodyContains("goroutine")
+	get("/middleware").AssertJSONPath("0", "RequestID").AssertJSONPath("1", "Logger")
+	get("/routes").AssertJSONPath("0", "GET /health")
+}
+
+func TestAdmin_LogLevel(t *testing.T) {
+	handler, level := newTestAdmin()
+
+	routertest.NewRequest(http.MethodPut, "/loglevel").
+		Header("Authorization", "Bearer "+testToken).
+		JSON(map[string]string{"level": "debug"}).
+		Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("level", "DEBUG")
+
+	if level.Level() != slog.LevelDebug {
+		t.Fatalf("Expected level to be debug, got %v", level.Level())
+	}
+
+	routertest.NewRequest(http.MethodPut, "/loglevel?level=loud").
+		Header("Authorization", "Bearer "+testToken).
+		Do(t, handler).
+		AssertStatus(http.StatusBadRequest)
+}
+
+func TestBasicAuth(t *testing.T) {
+	handler := New(BasicAuth("admin", "pw"))
+
+	req := routertest.Get("/runtime").Build(t)
+	req.SetBasicAuth("admin", "pw")
+	if !BasicAuth("admin", "pw").Authenticate(req) {
+		t.Fatal("Expected valid credentials to be accepted")
+	}
+
+	routertest.Get("/runtime").Do(t, handler).
+		AssertStatus(http.StatusUnauthorized).
+		AssertHeader("WWW-Authenticate", `Basic realm="admin"`)
+
+	// Empty credentials never match, even an empty login
+	req = routertest.Get("/runtime").Build(t)
+	req.SetBasicAuth("", "")
+	if BasicAuth("", "").Authenticate(req) || BasicAuth("admin", "").Authenticate(req) {
+		t.Fatal("Expected empty credentials to be refused")
+	}
+}
+
+func TestAdmin_Challenge(t *testing.T) {
+	routertest.Get("/").Do(t, New(BearerToken(testToken))).
+		AssertStatus(http.StatusUnauthorized).
+		AssertHeader("WWW-Authenticate", `Bearer realm="admin"`)
+
+	custom := AuthenticatorFunc(func(r *http.Request) bool { return false })
+	routertest.Get("/").Do(t, New(custom)).
+		AssertStatus(http.StatusUnauthorized).
+		AssertHeaderAbsent("WWW-Authenticate")
+}
+
+func TestAdmin_IndexListsRegistered(t *testing.T) {
+	handler := New(BearerToken(testToken))
+	body := routertest.Get("/").Header("Authorization", "Bearer "+testToken).Do(t, handler).
+		AssertStatus(http.StatusOK).
+		Body()
+	if strings.Contains(body, "/routes") || strings.Contains(body, "/loglevel") {
+		t.Fatalf("Expected only registered endpoints, got %s", body)
+	}
+	if !strings.Contains(body, "/runtime") {
+		t.Fatalf("Expected /runtime in index, got %s", body)
+	}
+}
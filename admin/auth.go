@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Authenticator decides whether a request may access the admin handler
+type Authenticator interface {
+	Authenticate(r *http.Request) bool
+	// Challenge is the WWW-Authenticate value sent with a 401, or empty
+	Challenge() string
+}
+
+// AuthenticatorFunc adapts a function to Authenticator. It sends no
+// challenge.
+type AuthenticatorFunc func(r *http.Request) bool
+
+// Authenticate implements Authenticator
+func (f AuthenticatorFunc) Authenticate(r *http.Request) bool {
+	return f(r)
+}
+
+// Challenge implements Authenticator
+func (f AuthenticatorFunc) Challenge() string {
+	return ""
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+// BasicAuth accepts requests with the given HTTP Basic credentials. An
+// empty username or password rejects every request.
+func BasicAuth(username, password string) Authenticator {
+	return basicAuth{username, password}
+}
+
+// Authenticate implements Authenticator
+func (a basicAuth) Authenticate(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok || a.username == "" || a.password == "" {
+		return false
+	}
+	userMatch := equal(user, a.username)
+	passMatch := equal(pass, a.password)
+	return userMatch && passMatch
+}
+
+// Challenge implements Authenticator
+func (a basicAuth) Challenge() string {
+	return `Basic realm="admin"`
+}
+
+type bearerToken string
+
+// BearerToken accepts requests with the given bearer token. An empty token
+// rejects every request.
+func BearerToken(token string) Authenticator {
+	return bearerToken(token)
+}
+
+// Authenticate implements Authenticator
+func (t bearerToken) Authenticate(r *http.Request) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && t != "" && equal(got, string(t))
+}
+
+// Challenge implements Authenticator
+func (t bearerToken) Challenge() string {
+	return `Bearer realm="admin"`
+}
+
+// equal compares in constant time, hashing first so the length of the
+// secret is not revealed
+func equal(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
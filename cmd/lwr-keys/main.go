@@ -0,0 +1,152 @@
+// Command lwr-keys manages the API keys in a file-backed key store.
+//
+// Usage:
+//
+//	lwr-keys -file keys.json generate -name billing -scopes reports:read -ttl 720h
+//	lwr-keys -file keys.json list
+//	lwr-keys -file keys.json rotate -grace 24h lwr_0a1b2c3d4e5f
+//	lwr-keys -file keys.json revoke lwr_0a1b2c3d4e5f
+//
+// Generated secrets are printed once and never stored in plain text.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vhellman/lw-router/middleware"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "lwr-keys:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("lwr-keys", flag.ContinueOnError)
+	file := fs.String("file", "keys.json", "key store file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("expected a command: generate, list, rotate or revoke")
+	}
+
+	store, err := middleware.OpenFileKeyStore(*file)
+	if err != nil {
+		return err
+	}
+
+	command, args := fs.Arg(0), fs.Args()[1:]
+	switch command {
+	case "generate":
+		return generate(store, args, out)
+	case "list":
+		return list(store, out)
+	case "rotate":
+		return rotate(store, args, out)
+	case "revoke":
+		return revoke(store, args, out)
+	}
+	return fmt.Errorf("unknown command %q", command)
+}
+
+func generate(store *middleware.FileKeyStore, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	name := fs.String("name", "", "owner of the key")
+	scopes := fs.String("scopes", "", "comma separated scopes")
+	ttl := fs.Duration("ttl", 0, "lifetime of the key, 0 never expires")
+	prefix := fs.String("prefix", "lwr", "key prefix")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("generate: -name is required")
+	}
+
+	var scopeList []string
+	if *scopes != "" {
+		scopeList = strings.Split(*scopes, ",")
+	}
+	key, record, err := middleware.NewAPIKey(*prefix, *name, scopeList, *ttl)
+	if err != nil {
+		return err
+	}
+	if err := store.Add(record); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "id:  %s\nkey: %s\n", record.ID, key)
+	return nil
+}
+
+func list(store *middleware.FileKeyStore, out io.Writer) error {
+	records, err := store.Records()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES\tSTATUS")
+	for _, r := range records {
+		status := "active"
+		switch {
+		case !r.RevokedAt.IsZero() && now.Before(r.RevokedAt):
+			status = "revoking " + r.RevokedAt.Format(time.RFC3339)
+		case !r.RevokedAt.IsZero():
+			status = "revoked"
+		case r.Expired(now):
+			status = "expired"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.ID, r.Name, strings.Join(r.Scopes, ","),
+			r.CreatedAt.Format(time.RFC3339), formatExpiry(r.ExpiresAt), status,
+		)
+	}
+	return tw.Flush()
+}
+
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func rotate(store *middleware.FileKeyStore, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	grace := fs.Duration("grace", 0, "how long the old key keeps working")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("rotate: expected a key ID")
+	}
+
+	key, record, err := store.Rotate(fs.Arg(0), *grace)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "id:  %s\nkey: %s\n", record.ID, key)
+	return nil
+}
+
+func revoke(store *middleware.FileKeyStore, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("revoke: expected a key ID")
+	}
+	if err := store.Revoke(args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "revoked %s\n", args[0])
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	var out bytes.Buffer
+
+	if err := run([]string{"-file", file, "generate", "-name", "billing", "-scopes", "a,b"}, &out); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	id := strings.TrimSpace(strings.TrimPrefix(strings.Split(out.String(), "\n")[0], "id:"))
+	if !strings.HasPrefix(id, "lwr_") || !strings.Contains(out.String(), "key: "+id+"_") {
+		t.Fatalf("Expected a generated key, got %s", out.String())
+	}
+
+	out.Reset()
+	if err := run([]string{"-file", file, "rotate", "-grace", "1h", id}, &out); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	out.Reset()
+	if err := run([]string{"-file", file, "list"}, &out); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "revoking") || !strings.Contains(out.String(), "a,b") {
+		t.Fatalf("Expected the rotated key to be listed, got %s", out.String())
+	}
+
+	out.Reset()
+	if err := run([]string{"-file", file, "revoke", id}, &out); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := run([]string{"-file", file, "revoke", "lwr_missing"}, &out); err == nil {
+		t.Fatal("Expected an error revoking an unknown key")
+	}
+	if err := run([]string{"-file", file, "bogus"}, &out); err == nil {
+		t.Fatal("Expected an error for an unknown command")
+	}
+}
@@ -0,0 +1,196 @@
+package router
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// span measures the time spent inside one layer of the chain. Time spent in
+// downstream layers is tracked separately so it can be excluded.
+type span struct {
+	name            string
+	start           time.Time
+	end             time.Time
+	downstream      time.Duration
+	downstreamStart time.Time
+}
+
+// self returns the time spent in the layer itself up to now
+func (s *span) self(now time.Time) time.Duration {
+	end := now
+	switch {
+	case !s.end.IsZero():
+		end = s.end
+	case !s.downstreamStart.IsZero():
+		end = s.downstreamStart
+	}
+	return end.Sub(s.start) - s.downstream
+}
+
+// chainTrace records the layers of a single request in the order they ran
+type chainTrace struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+func (t *chainTrace) enter(name string) *span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &span{name: name, start: time.Now()}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (t *chainTrace) leave(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.end = time.Now()
+}
+
+func (t *chainTrace) startDownstream(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.downstreamStart = time.Now()
+}
+
+func (t *chainTrace) endDownstream(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.downstream += time.Since(s.downstreamStart)
+	s.downstreamStart = time.Time{}
+}
+
+// serverTiming formats the self time of every layer seen so far
+func (t *chainTrace) serverTiming() string {
+	names, self := t.snapshot(time.Now())
+	metrics := make([]string, 0, len(names))
+	for i, name := range names {
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%.3f", name, float64(self[i])/float64(time.Millisecond)))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// snapshot returns the name and self time of every layer seen so far.
+// Handlers abandoned by a timeout may still be adding spans, so the
+// spans are only read under the lock.
+func (t *chainTrace) snapshot(now time.Time) ([]string, []time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.spans))
+	self := make([]time.Duration, 0, len(t.spans))
+	for _, s := range t.spans {
+		names = append(names, s.name)
+		self = append(self, s.self(now))
+	}
+	return names, self
+}
+
+// serveDebug runs the chain with every layer wrapped in a timing span
+func (r *Router) serveDebug(w http.ResponseWriter, req *http.Request, handler http.Handler) {
+	trace := &chainTrace{}
+	start := time.Now()
+
+	handler = r.traceLayer(trace, "handler", handler, nil)
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.traceLayer(trace, middlewareName(r.middlewares[i]), handler, r.middlewares[i])
+	}
+
+	dw := &debugWriter{ResponseWriter: w, trace: trace}
+	handler.ServeHTTP(dw, req)
+
+	now := time.Now()
+	order, self := trace.snapshot(now)
+	timings := make([]any, 0, len(order))
+	for i, name := range order {
+		timings = append(timings, slog.Duration(name, self[i]))
+	}
+	r.debugLogger.DebugContext(req.Context(), "Middleware chain",
+		"method", req.Method,
+		"path", req.URL.Path,
+		"order", order,
+		slog.Group("self", timings...),
+		"total", now.Sub(start),
+	)
+}
+
+// traceLayer wraps one layer so its own time is measured. For middleware the
+// handler it receives is wrapped as well, so downstream time is excluded.
+func (r *Router) traceLayer(trace *chainTrace, name string, next http.Handler, middleware func(http.Handler) http.Handler) http.Handler {
+	var s *span
+	layer := next
+	if middleware != nil {
+		layer = middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			trace.startDownstream(s)
+			defer trace.endDownstream(s)
+			next.ServeHTTP(w, req)
+		}))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s = trace.enter(name)
+		defer trace.leave(s)
+		layer.ServeHTTP(w, req)
+	})
+}
+
+// debugWriter adds the Server-Timing header before the first write. Only the
+// time each layer spent before the response started is included; the full
+// breakdown is in the debug log record.
+type debugWriter struct {
+	http.ResponseWriter
+	trace       *chainTrace
+	wroteHeader bool
+}
+
+func (w *debugWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Add("Server-Timing", w.trace.serverTiming())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *debugWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *debugWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *debugWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+var (
+	closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+	invalidToken  = regexp.MustCompile(`[^A-Za-z0-9_\-.]`)
+)
+
+// middlewareName derives a readable name from the middleware function,
+// e.g. "github.com/vhellman/lw-router/middleware.RequestID.func1" becomes
+// "RequestID"
+func middlewareName(middleware func(http.Handler) http.Handler) string {
+	name := runtime.FuncForPC(reflect.ValueOf(middleware).Pointer()).Name()
+	name = closureSuffix.ReplaceAllString(name, "")
+	name = name[strings.LastIndex(name, "/")+1:]
+	if i := strings.Index(name, "."); i >= 0 && i < len(name)-1 {
+		name = name[i+1:]
+	}
+	return invalidToken.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,109 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/middleware"
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func sleepMiddleware(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(d)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TestDebug_ServerTiming tests that debug mode reports every layer
+func TestDebug_ServerTiming(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	router := New(WithDebug(recorder.Logger()))
+	router.Use(middleware.RequestID())
+	router.Use(sleepMiddleware(20 * time.Millisecond))
+	router.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	resp := routertest.Get("/").Do(t, router).AssertStatus(http.StatusOK)
+
+	timing := resp.Result.Header.Get("Server-Timing")
+	for _, name := range []string{"RequestID;dur=", "sleepMiddleware;dur=", "handler;dur="} {
+		if !strings.Contains(timing, name) {
+			t.Fatalf("Expected Server-Timing to contain %q, got %q", name, timing)
+		}
+	}
+
+	record, ok := recorder.Find("Middleware chain")
+	if !ok {
+		t.Fatal("Expected debug record")
+	}
+	order := record.Attrs["order"].([]string)
+	if strings.Join(order, ",") != "RequestID,sleepMiddleware,handler" {
+		t.Fatalf("Expected chain order, got %v", order)
+	}
+
+	// Self time excludes the time spent downstream
+	self := record.Attrs["self.sleepMiddleware"].(time.Duration)
+	if self < 20*time.Millisecond || self >= 40*time.Millisecond {
+		t.Fatalf("Expected sleepMiddleware self time around 20ms, got %v", self)
+	}
+	if requestID := record.Attrs["self.RequestID"].(time.Duration); requestID >= 20*time.Millisecond {
+		t.Fatalf("Expected RequestID self time to exclude downstream, got %v", requestID)
+	}
+}
+
+// TestDebug_ShortCircuit tests that layers that never ran are not reported
+func TestDebug_ShortCircuit(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	router := New(WithDebug(recorder.Logger()))
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	})
+	router.Use(middleware.RequestID())
+	router.HandleFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	routertest.Get("/").Do(t, router).AssertStatus(http.StatusForbidden)
+
+	record, _ := recorder.Find("Middleware chain")
+	if order := record.Attrs["order"].([]string); len(order) != 1 {
+		t.Fatalf("Expected only the short-circuiting middleware, got %v", order)
+	}
+}
+
+// TestMiddlewareName tests name derivation for middleware functions
+func TestMiddlewareName(t *testing.T) {
+	if name := middlewareName(middleware.Logger); name != "Logger" {
+		t.Fatalf("Expected Logger, got %s", name)
+	}
+	if name := middlewareName(middleware.Audit()); name != "Audit" {
+		t.Fatalf("Expected Audit, got %s", name)
+	}
+}
+
+// TestDebug_Timeout tests that spans added by a handler abandoned by
+// Timeout do not race with the debug record. Run with -race.
+func TestDebug_Timeout(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	router := New(WithDebug(recorder.Logger()))
+	router.Use(middleware.Timeout(10 * time.Millisecond))
+	router.Use(sleepMiddleware(30 * time.Millisecond))
+	done := make(chan struct{})
+	router.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(done)
+	})
+
+	routertest.Get("/").Do(t, router).AssertStatus(http.StatusServiceUnavailable)
+	<-done
+
+	if _, ok := recorder.Find("Middleware chain"); !ok {
+		t.Fatal("Expected debug record")
+	}
+}
@@ -2,3 +2,6 @@ module github.com/vhellman/lw-router
 
 go 1.23.1
 
+require golang.org/x/crypto v0.41.0
+
+require golang.org/x/sys v0.35.0 // indirect
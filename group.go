@@ -0,0 +1,37 @@
+package router
+
+import "net/http"
+
+// Group is a set of routes sharing middleware that runs after the
+// router's own middleware
+type Group struct {
+	router      *Router
+	middlewares []func(http.Handler) http.Handler
+}
+
+// Group creates a route group with the given middleware
+func (r *Router) Group(middlewares ...func(http.Handler) http.Handler) *Group {
+	return &Group{router: r, middlewares: middlewares}
+}
+
+// Use adds middleware to the group's chain
+func (g *Group) Use(middleware func(http.Handler) http.Handler) {
+	g.middlewares = append(g.middlewares, middleware)
+}
+
+// Route registers a handler for a pattern behind the group's middleware
+func (g *Group) Route(pattern string, handler http.Handler) {
+	g.router.Route(pattern, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h := handler
+		// Chain middleware in reverse order
+		for i := len(g.middlewares) - 1; i >= 0; i-- {
+			h = g.middlewares[i](h)
+		}
+		h.ServeHTTP(w, req)
+	}))
+}
+
+// RouteFunc registers a handler function for a pattern
+func (g *Group) RouteFunc(pattern string, fn http.HandlerFunc) {
+	g.Route(pattern, fn)
+}
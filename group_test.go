@@ -0,0 +1,39 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestGroup tests that group middleware only applies to group routes
+func TestGroup(t *testing.T) {
+	router := New()
+	tag := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Group", "api")
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	api := router.Group()
+	api.Use(tag)
+	api.RouteFunc("GET /api/items", func(w http.ResponseWriter, r *http.Request) {})
+	router.RouteFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+	if w.Header().Get("X-Group") != "api" {
+		t.Fatal("Expected group middleware to run for group route")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Header().Get("X-Group") != "" {
+		t.Fatal("Expected group middleware not to run for other routes")
+	}
+
+	if routes := router.Routes(); len(routes) != 2 {
+		t.Fatalf("Expected group routes in route table, got %v", routes)
+	}
+}
@@ -0,0 +1,210 @@
+// pkg/middleware/adaptive.go
+package middleware
+
+/**
+ex usage:
+// Start at 20 in flight, adapt between 5 and 500, publish the current
+// limit as "concurrency_limit" in the expvar map "api"
+metrics := expvar.NewMap("api")
+router.Use(middleware.AdaptiveConcurrencyLimit(100,
+	middleware.WithAdaptiveLimits(20, 5, 500),
+	middleware.WithAdaptiveAlgorithm(middleware.Gradient),
+	middleware.WithLimitMetric(metrics, "concurrency_limit"),
+))
+*/
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdaptiveAlgorithm selects how the adaptive limit reacts to latency
+type AdaptiveAlgorithm int
+
+const (
+	// AIMD grows the limit by one per window while latency stays within
+	// tolerance of the baseline and cuts it by 10% when it does not
+	AIMD AdaptiveAlgorithm = iota
+	// Gradient scales the limit by the ratio of baseline to current latency
+	// and adds headroom of sqrt(limit) for growth
+	Gradient
+)
+
+type adaptiveOptions struct {
+	algorithm AdaptiveAlgorithm
+	initial   int
+	min       int
+	max       int
+	tolerance float64
+	window    time.Duration
+	metrics   *expvar.Map
+	metric    string
+}
+
+// WithAdaptiveAlgorithm sets the adaptive algorithm, AIMD by default
+func WithAdaptiveAlgorithm(algorithm AdaptiveAlgorithm) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.adaptive.algorithm = algorithm
+	}
+}
+
+// WithAdaptiveLimits sets the initial limit and the bounds it adapts within
+func WithAdaptiveLimits(initial, minLimit, maxLimit int) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.adaptive.initial = initial
+		o.adaptive.min = minLimit
+		o.adaptive.max = maxLimit
+	}
+}
+
+// WithLatencyTolerance sets how many times the baseline latency is
+// accepted before the limit is reduced, 2 by default
+func WithLatencyTolerance(tolerance float64) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.adaptive.tolerance = tolerance
+	}
+}
+
+// WithAdaptiveWindow sets how often the limit is recalculated
+func WithAdaptiveWindow(d time.Duration) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.adaptive.window = d
+	}
+}
+
+// WithLimitMetric publishes the current limit as the integer key in
+// metrics. The map is owned by the caller, so several limiters and
+// repeated setups never register the same global expvar name.
+func WithLimitMetric(metrics *expvar.Map, key string) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.adaptive.metrics = metrics
+		o.adaptive.metric = key
+	}
+}
+
+// AdaptiveConcurrencyLimit creates a middleware like ConcurrencyLimit whose
+// limit adapts to the latency of completed requests, measured from
+// admission to the end of the handler.
+func AdaptiveConcurrencyLimit(maxQueue int, opts ...ConcurrencyOption) func(http.Handler) http.Handler {
+	options := newConcurrencyOptions(opts)
+	limiter := newAdaptiveLimiter(options.adaptive)
+	a := &admission{
+		limit:    limiter.current(),
+		maxQueue: maxQueue,
+		queue:    &dequeQueue{mode: options.mode, capacity: maxQueue},
+	}
+
+	var gauge *expvar.Int
+	if options.adaptive.metrics != nil {
+		gauge = new(expvar.Int)
+		gauge.Set(int64(a.limit))
+		options.adaptive.metrics.Set(options.adaptive.metric, gauge)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := a.acquire(r.Context(), newWaiter(), options.maxWait); err != nil {
+				shed(w, r, err, options.retryAfter)
+				return
+			}
+
+			start := time.Now()
+			defer func() {
+				now := time.Now()
+				if limit, changed := limiter.observe(now.Sub(start), a.inFlight(), now); changed {
+					a.setLimit(limit)
+					if gauge != nil {
+						gauge.Set(int64(limit))
+					}
+				}
+				a.release()
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// inFlight returns the number of admitted requests
+func (a *admission) inFlight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.inflight
+}
+
+// adaptiveLimiter aggregates latency samples per window and recalculates
+// the limit at the end of each window
+type adaptiveLimiter struct {
+	mu        sync.Mutex
+	options   adaptiveOptions
+	limit     float64
+	baseline  time.Duration
+	start     time.Time
+	samples   int
+	total     time.Duration
+	maxActive int
+}
+
+func newAdaptiveLimiter(options adaptiveOptions) *adaptiveLimiter {
+	return &adaptiveLimiter{options: options, limit: float64(options.initial)}
+}
+
+func (l *adaptiveLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// observe records a completed request and returns the new limit when it
+// changed
+func (l *adaptiveLimiter) observe(rtt time.Duration, inflight int, now time.Time) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.start.IsZero() {
+		l.start = now
+	}
+	l.samples++
+	l.total += rtt
+	l.maxActive = max(l.maxActive, inflight)
+	if now.Sub(l.start) < l.options.window {
+		return int(l.limit), false
+	}
+
+	avg := l.total / time.Duration(l.samples)
+	utilized := l.maxActive*2 >= int(l.limit)
+	l.start, l.samples, l.total, l.maxActive = now, 0, 0, 0
+
+	// The baseline follows the lowest latency seen and drifts slowly
+	// upwards so it can recover when the service gets slower for good
+	if l.baseline == 0 || avg < l.baseline {
+		l.baseline = avg
+	} else {
+		l.baseline += (avg - l.baseline) / 100
+	}
+
+	previous := int(l.limit)
+	limit := l.limit
+	threshold := time.Duration(float64(l.baseline) * l.options.tolerance)
+
+	switch l.options.algorithm {
+	case Gradient:
+		gradient := math.Max(0.5, math.Min(1, float64(threshold)/float64(avg)))
+		if gradient < 1 || utilized {
+			target := limit*gradient + math.Sqrt(limit)
+			limit = limit*0.8 + target*0.2
+		}
+	default:
+		if avg > threshold {
+			limit *= 0.9
+		} else if utilized {
+			limit++
+		}
+	}
+
+	l.limit = math.Max(float64(l.options.min), math.Min(float64(l.options.max), limit))
+	current := int(l.limit)
+	return current, current != previous
+}
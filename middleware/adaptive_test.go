@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func testAdaptiveOptions(algorithm AdaptiveAlgorithm) adaptiveOptions {
+	return adaptiveOptions{
+		algorithm: algorithm,
+		initial:   10,
+		min:       2,
+		max:       12,
+		tolerance: 2,
+	}
+}
+
+func TestAdaptiveLimiter_AIMD(t *testing.T) {
+	l := newAdaptiveLimiter(testAdaptiveOptions(AIMD))
+	now := time.Unix(0, 0)
+
+	// Latency at the baseline with the limit in use grows the limit by one
+	if limit, changed := l.observe(10*time.Millisecond, 10, now); !changed || limit != 11 {
+		t.Fatalf("Expected limit to grow to 11, got %d", limit)
+	}
+	// An idle service does not grow the limit
+	if limit, changed := l.observe(10*time.Millisecond, 1, now); changed || limit != 11 {
+		t.Fatalf("Expected limit to stay at 11, got %d", limit)
+	}
+	// The maximum is respected
+	l.observe(10*time.Millisecond, 11, now)
+	if limit, _ := l.observe(10*time.Millisecond, 12, now); limit != 12 {
+		t.Fatalf("Expected limit to be capped at 12, got %d", limit)
+	}
+
+	// Latency beyond the tolerance cuts the limit
+	if limit, changed := l.observe(50*time.Millisecond, 12, now); !changed || limit != 10 {
+		t.Fatalf("Expected limit to drop to 10, got %d", limit)
+	}
+	for i := 0; i < 20; i++ {
+		l.observe(50*time.Millisecond, 12, now)
+	}
+	if limit := l.current(); limit != 2 {
+		t.Fatalf("Expected limit to bottom out at 2, got %d", limit)
+	}
+}
+
+func TestAdaptiveLimiter_Gradient(t *testing.T) {
+	l := newAdaptiveLimiter(testAdaptiveOptions(Gradient))
+	now := time.Unix(0, 0)
+
+	for i := 0; i < 5; i++ {
+		l.observe(10*time.Millisecond, 10, now)
+	}
+	grown := l.current()
+	if grown <= 10 {
+		t.Fatalf("Expected limit to grow at baseline latency, got %d", grown)
+	}
+
+	l.observe(100*time.Millisecond, 10, now)
+	if limit := l.current(); limit >= grown {
+		t.Fatalf("Expected limit to shrink under high latency, got %d", limit)
+	}
+}
+
+func TestAdaptiveLimiter_Window(t *testing.T) {
+	options := testAdaptiveOptions(AIMD)
+	options.window = time.Second
+	l := newAdaptiveLimiter(options)
+	start := time.Unix(0, 0)
+
+	l.observe(10*time.Millisecond, 10, start)
+	if _, changed := l.observe(10*time.Millisecond, 10, start.Add(500*time.Millisecond)); changed {
+		t.Fatal("Expected no change before the window ends")
+	}
+	if _, changed := l.observe(10*time.Millisecond, 10, start.Add(time.Second)); !changed {
+		t.Fatal("Expected change at the end of the window")
+	}
+}
+
+func TestAdaptiveConcurrencyLimit(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	metrics := new(expvar.Map)
+	handler := AdaptiveConcurrencyLimit(0,
+		WithAdaptiveLimits(1, 1, 4),
+		WithAdaptiveWindow(0),
+		WithLimitMetric(metrics, "limit"),
+	)(blockingHandler(started, release))
+
+	if v := metrics.Get("limit").String(); v != "1" {
+		t.Fatalf("Expected published limit 1, got %s", v)
+	}
+
+	// Over the limit, requests take the same shedding path as the fixed limiter
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	<-started
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertHeaderPresent("Retry-After")
+
+	close(release)
+	<-done
+	if v := metrics.Get("limit").String(); v != "2" {
+		t.Fatalf("Expected published limit to grow to 2, got %s", v)
+	}
+}
+
+func TestAdaptiveConcurrencyLimit_SharedMetrics(t *testing.T) {
+	metrics := new(expvar.Map)
+	for range 2 {
+		AdaptiveConcurrencyLimit(0, WithAdaptiveLimits(3, 1, 4), WithLimitMetric(metrics, "limit"))
+	}
+	AdaptiveConcurrencyLimit(0, WithAdaptiveLimits(2, 1, 4), WithLimitMetric(metrics, "other"))
+
+	if v := metrics.Get("limit").String(); v != "3" {
+		t.Fatalf("Expected published limit 3, got %s", v)
+	}
+	if v := metrics.Get("other").String(); v != "2" {
+		t.Fatalf("Expected published limit 2, got %s", v)
+	}
+}
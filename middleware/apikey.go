@@ -0,0 +1,259 @@
+// pkg/middleware/apikey.go
+package middleware
+
+/**
+ex usage:
+store, err := middleware.OpenFileKeyStore("/etc/api/keys.json")
+router.Use(middleware.APIKey(store))
+
+// Keys in a query parameter, requiring a scope
+router.Use(middleware.APIKey(store,
+	middleware.WithAPIKeyQuery("api_key"),
+	middleware.WithAPIKeyScopes("reports:read"),
+))
+
+// Keys are created with the lwr-keys command or NewAPIKey
+key, record, err := middleware.NewAPIKey("lwr", "billing-service", []string{"reports:read"}, 0)
+*/
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultAPIKeyHeader is the header API keys are read from by default
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// ErrKeyNotFound is returned by a KeyStore for unknown key IDs
+var ErrKeyNotFound = errors.New("api key not found")
+
+// APIKeyRecord describes an issued API key. Only a salted hash of the
+// secret part is stored.
+type APIKeyRecord struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Salt      []byte    `json:"salt"`
+	Hash      []byte    `json:"hash"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// Expired reports whether the key has expired or was revoked at now
+func (k *APIKeyRecord) Expired(now time.Time) bool {
+	if !k.RevokedAt.IsZero() && !now.Before(k.RevokedAt) {
+		return true
+	}
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// Verify compares secret against the stored hash in constant time
+func (k *APIKeyRecord) Verify(secret string) bool {
+	return subtle.ConstantTimeCompare(hashAPIKey(k.Salt, secret), k.Hash) == 1
+}
+
+func hashAPIKey(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+// NewAPIKey generates a key of the form <prefix>_<id>_<secret>. The
+// public ID is used to look the record up; the secret is only returned
+// here. A ttl of zero never expires.
+func NewAPIKey(prefix, name string, scopes []string, ttl time.Duration) (string, *APIKeyRecord, error) {
+	if prefix == "" || strings.Contains(prefix, "_") {
+		return "", nil, errors.New("api key prefix must be non-empty and contain no underscore")
+	}
+
+	id, err := randomBytes(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomBytes(32)
+	if err != nil {
+		return "", nil, err
+	}
+	salt, err := randomBytes(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	record := &APIKeyRecord{
+		ID:        prefix + "_" + hex.EncodeToString(id),
+		Name:      name,
+		Salt:      salt,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		record.ExpiresAt = record.CreatedAt.Add(ttl)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	record.Hash = hashAPIKey(salt, encoded)
+	return record.ID + "_" + encoded, record, nil
+}
+
+// ParseAPIKey splits a key into its record ID and secret
+func ParseAPIKey(key string) (id, secret string, ok bool) {
+	prefix, rest, ok := strings.Cut(key, "_")
+	if !ok {
+		return "", "", false
+	}
+	publicID, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || publicID == "" || secret == "" {
+		return "", "", false
+	}
+	return prefix + "_" + publicID, secret, true
+}
+
+// KeyStore looks API keys up by their ID
+type KeyStore interface {
+	Lookup(ctx context.Context, id string) (*APIKeyRecord, error)
+}
+
+type apiKeyOptions struct {
+	header string
+	query  string
+	scopes []string
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+type APIKeyOption func(*apiKeyOptions)
+
+// WithAPIKeyHeader sets the header holding the key, X-API-Key by default
+func WithAPIKeyHeader(name string) APIKeyOption {
+	return func(o *apiKeyOptions) {
+		o.header = name
+	}
+}
+
+// WithAPIKeyQuery also accepts the key in a query parameter
+func WithAPIKeyQuery(param string) APIKeyOption {
+	return func(o *apiKeyOptions) {
+		o.query = param
+	}
+}
+
+// WithAPIKeyScopes requires the key to carry all of scopes
+func WithAPIKeyScopes(scopes ...string) APIKeyOption {
+	return func(o *apiKeyOptions) {
+		o.scopes = scopes
+	}
+}
+
+// WithAPIKeyLogger sets the logger used to report key store errors
+func WithAPIKeyLogger(logger *slog.Logger) APIKeyOption {
+	return func(o *apiKeyOptions) {
+		o.logger = logger
+	}
+}
+
+// APIKey creates a middleware that authenticates requests by API key.
+// The key record becomes the request principal. Missing, unknown,
+// expired or revoked keys get 401, keys lacking a required scope 403.
+// If the store fails the request is refused with 503.
+func APIKey(store KeyStore, opts ...APIKeyOption) func(http.Handler) http.Handler {
+	options := &apiKeyOptions{
+		header: DefaultAPIKeyHeader,
+		logger: slog.Default(),
+		now:    time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(options.header)
+			if key == "" && options.query != "" {
+				key = r.URL.Query().Get(options.query)
+			}
+			if key == "" {
+				RenderProblem(w, r, Problem{
+					Status: http.StatusUnauthorized,
+					Detail: "Missing API key",
+				})
+				return
+			}
+
+			record, reason, err := lookupAPIKey(r.Context(), store, key, options.now())
+			if err != nil {
+				// An outage is not a bad credential
+				options.logger.ErrorContext(r.Context(), "Key store failed", "error", err)
+				RenderProblem(w, r, Problem{
+					Status: http.StatusServiceUnavailable,
+					Detail: "API key verification unavailable",
+				})
+				return
+			}
+			if reason != "" {
+				AuditEvent(r, "API key rejected", "reason", reason)
+				RenderProblem(w, r, Problem{
+					Status: http.StatusUnauthorized,
+					Detail: "Invalid API key",
+				})
+				return
+			}
+
+			for _, scope := range options.scopes {
+				if !slices.Contains(record.Scopes, scope) {
+					AuditEvent(r, "API key rejected", "reason", "missing scope", "key", record.ID, "scope", scope)
+					RenderProblem(w, r, Problem{
+						Status: http.StatusForbidden,
+						Detail: "API key lacks scope " + scope,
+					})
+					return
+				}
+			}
+
+			ctx := WithPrincipal(r.Context(), &Principal{
+				ID:     record.ID,
+				Name:   record.Name,
+				Scopes: record.Scopes,
+				Method: "apikey",
+			})
+			r = r.WithContext(ctx)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// lookupAPIKey returns the record for key, or why it was rejected. An
+// error means the store failed.
+func lookupAPIKey(ctx context.Context, store KeyStore, key string, now time.Time) (*APIKeyRecord, string, error) {
+	id, secret, ok := ParseAPIKey(key)
+	if !ok {
+		return nil, "malformed key", nil
+	}
+
+	record, err := store.Lookup(ctx, id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, "unknown key", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !record.Verify(secret) {
+		return nil, "wrong secret", nil
+	}
+	if record.Expired(now) {
+		return nil, "expired or revoked", nil
+	}
+	return record, "", nil
+}
@@ -0,0 +1,204 @@
+// pkg/middleware/apikey_store.go
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vhellman/lw-router/internal/fsutil"
+)
+
+// MemoryKeyStore keeps API key records in memory
+type MemoryKeyStore struct {
+	mu      sync.RWMutex
+	records map[string]APIKeyRecord
+}
+
+// NewMemoryKeyStore creates a store holding records
+func NewMemoryKeyStore(records ...*APIKeyRecord) *MemoryKeyStore {
+	s := &MemoryKeyStore{records: make(map[string]APIKeyRecord)}
+	for _, record := range records {
+		s.records[record.ID] = *record
+	}
+	return s
+}
+
+// Lookup implements KeyStore
+func (s *MemoryKeyStore) Lookup(_ context.Context, id string) (*APIKeyRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &record, nil
+}
+
+// FileKeyStore keeps API key records in a JSON file. Changes made by
+// another process, such as the lwr-keys command, are picked up within the
+// reload interval.
+type FileKeyStore struct {
+	mu      sync.Mutex
+	file    watchedFile
+	records map[string]*APIKeyRecord
+}
+
+// OpenFileKeyStore loads the store at path, creating it on first write,
+// and checks the file for changes at most once per second
+func OpenFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{file: watchedFile{path: path, interval: time.Second}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the file, replacing the records only if it parses
+func (s *FileKeyStore) load() error {
+	records := make(map[string]*APIKeyRecord)
+	data, err := os.ReadFile(s.file.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err == nil {
+		var list []*APIKeyRecord
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("%s: %w", s.file.path, err)
+		}
+		for _, record := range list {
+			records[record.ID] = record
+		}
+	}
+	s.records = records
+	s.file.update()
+	return nil
+}
+
+// reloadLocked rereads the file if another process changed it
+func (s *FileKeyStore) reloadLocked() {
+	if s.file.stale() {
+		// Keep the previous keys if the new file is broken
+		_ = s.load()
+	}
+}
+
+// Lookup implements KeyStore
+func (s *FileKeyStore) Lookup(_ context.Context, id string) (*APIKeyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reloadLocked()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	result := *record
+	return &result, nil
+}
+
+// Records returns all records, oldest first
+func (s *FileKeyStore) Records() ([]APIKeyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reloadLocked()
+	return s.sortedLocked(), nil
+}
+
+// Add stores a new record
+func (s *FileKeyStore) Add(record *APIKeyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	if _, ok := s.records[record.ID]; ok {
+		return errors.New("api key " + record.ID + " already exists")
+	}
+	s.records[record.ID] = record
+	return s.saveLocked()
+}
+
+// Revoke marks the key as revoked from now on
+func (s *FileKeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	record, ok := s.records[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	record.RevokedAt = time.Now().UTC()
+	return s.saveLocked()
+}
+
+// Rotate issues a new key with the name and scopes of id, and revokes id
+// after grace so clients have time to switch. The new key keeps the
+// remaining lifetime of the old one.
+func (s *FileKeyStore) Rotate(id string, grace time.Duration) (string, *APIKeyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return "", nil, err
+	}
+	old, ok := s.records[id]
+	if !ok {
+		return "", nil, ErrKeyNotFound
+	}
+
+	prefix, _, _ := strings.Cut(old.ID, "_")
+	key, record, err := NewAPIKey(prefix, old.Name, old.Scopes, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	record.ExpiresAt = old.ExpiresAt
+	s.records[record.ID] = record
+
+	revokeAt := time.Now().UTC().Add(grace)
+	if old.RevokedAt.IsZero() || revokeAt.Before(old.RevokedAt) {
+		old.RevokedAt = revokeAt
+	}
+	if err := s.saveLocked(); err != nil {
+		return "", nil, err
+	}
+	return key, record, nil
+}
+
+func (s *FileKeyStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.sortedLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(s.file.path, data); err != nil {
+		return err
+	}
+	s.file.update()
+	return nil
+}
+
+func (s *FileKeyStore) sortedLocked() []APIKeyRecord {
+	result := make([]APIKeyRecord, 0, len(s.records))
+	for _, record := range s.records {
+		result = append(result, *record)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, record, err := NewAPIKey("lwr", "billing", []string{"reports:read"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	id, secret, ok := ParseAPIKey(key)
+	if !ok || id != record.ID {
+		t.Fatalf("Expected key to parse to ID %s, got %s", record.ID, id)
+	}
+	if !record.Verify(secret) || record.Verify(secret+"x") {
+		t.Fatal("Expected only the issued secret to verify")
+	}
+	if record.Expired(time.Now()) || !record.Expired(time.Now().Add(2*time.Hour)) {
+		t.Fatalf("Expected key to expire after an hour, got %v", record.ExpiresAt)
+	}
+
+	if _, _, err := NewAPIKey("bad_prefix", "x", nil, 0); err == nil {
+		t.Fatal("Expected an error for a prefix with an underscore")
+	}
+	for _, bad := range []string{"", "lwr", "lwr_abc", "lwr__secret"} {
+		if _, _, ok := ParseAPIKey(bad); ok {
+			t.Fatalf("Expected %q not to parse", bad)
+		}
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	key, record, _ := NewAPIKey("lwr", "billing", []string{"reports:read"}, 0)
+	expiredKey, expired, _ := NewAPIKey("lwr", "old", nil, 0)
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+
+	recorder := routertest.NewSlogRecorder()
+	store := NewMemoryKeyStore(record, expired)
+	handler := Audit(WithLogger(recorder.Logger()))(APIKey(store, WithAPIKeyQuery("api_key"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, _ := PrincipalFrom(r.Context())
+			w.Write([]byte(p.Name + " " + p.Method))
+		}),
+	))
+
+	routertest.Get("/").Header("X-API-Key", key).Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertBody("billing apikey")
+	routertest.Get("/").Query("api_key", key).Do(t, handler).
+		AssertStatus(http.StatusOK)
+
+	id, _, _ := ParseAPIKey(key)
+	for name, bad := range map[string]string{
+		"missing":      "",
+		"wrong secret": id + "_wrong",
+		"unknown":      "lwr_000000000000_secret",
+		"expired":      expiredKey,
+	} {
+		resp := routertest.Get("/").Header("X-API-Key", bad).Do(t, handler)
+		resp.AssertStatus(http.StatusUnauthorized)
+		if name == "missing" {
+			continue
+		}
+		if _, ok := recorder.Find("API key rejected"); !ok {
+			t.Fatalf("%s: Expected an audit event", name)
+		}
+	}
+
+	scoped := APIKey(store, WithAPIKeyScopes("reports:write"))(http.NotFoundHandler())
+	routertest.Get("/").Header("X-API-Key", key).Do(t, scoped).
+		AssertStatus(http.StatusForbidden)
+}
+
+type failingKeyStore struct{}
+
+func (failingKeyStore) Lookup(context.Context, string) (*APIKeyRecord, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestAPIKey_StoreError(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(APIKey(failingKeyStore{}, WithAPIKeyLogger(recorder.Logger()))(http.NotFoundHandler()))
+
+	routertest.Get("/").Header("X-API-Key", "lwr_000000000000_secret").Do(t, handler).
+		AssertStatus(http.StatusServiceUnavailable)
+
+	if _, ok := recorder.Find("API key rejected"); ok {
+		t.Fatal("Expected no audit event for a store outage")
+	}
+	if record, ok := recorder.Find("Key store failed"); !ok || fmt.Sprint(record.Attrs["error"]) != "unavailable" {
+		t.Fatalf("Expected the store error to be logged, got %v", recorder.Records())
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, err := OpenFileKeyStore(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	key, record, _ := NewAPIKey("lwr", "billing", []string{"a"}, 0)
+	if err := store.Add(record); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("Expected key file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// A second store, as used by the server, sees the key
+	server, _ := OpenFileKeyStore(path)
+	server.file.interval = 0
+	id, secret, _ := ParseAPIKey(key)
+	got, err := server.Lookup(context.Background(), id)
+	if err != nil || !got.Verify(secret) {
+		t.Fatalf("Expected stored key to verify, got %v", err)
+	}
+
+	// Rotation keeps the old key working for the grace period
+	newKey, rotated, err := store.Rotate(id, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rotated.Name != "billing" || rotated.ID == id || newKey == key {
+		t.Fatalf("Expected a new key for billing, got %+v", rotated)
+	}
+	got, _ = server.Lookup(context.Background(), id)
+	if got.Expired(time.Now()) || !got.Expired(time.Now().Add(2*time.Hour)) {
+		t.Fatalf("Expected the old key to be revoked after the grace period, got %v", got.RevokedAt)
+	}
+
+	if err := store.Revoke(rotated.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got, _ = server.Lookup(context.Background(), rotated.ID)
+	if !got.Expired(time.Now()) {
+		t.Fatal("Expected the revoked key to be rejected")
+	}
+
+	if err := store.Revoke("lwr_missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	records, _ := server.Records()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+}
+
+func TestFileKeyStore_BrokenFileKeepsKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, _ := OpenFileKeyStore(path)
+	key, record, _ := NewAPIKey("lwr", "billing", nil, 0)
+	if err := store.Add(record); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	server, _ := OpenFileKeyStore(path)
+	server.file.interval = 0
+	if err := os.WriteFile(path, []byte(`[{"id": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	id, secret, _ := ParseAPIKey(key)
+	got, err := server.Lookup(context.Background(), id)
+	if err != nil || !got.Verify(secret) {
+		t.Fatalf("Expected the previous keys to keep working, got %v", err)
+	}
+	if err := server.Revoke(id); err == nil {
+		t.Fatal("Expected changes to a broken file to fail")
+	}
+	if _, err := OpenFileKeyStore(path); err == nil {
+		t.Fatal("Expected opening a broken file to fail")
+	}
+}
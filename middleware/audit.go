@@ -18,10 +18,25 @@ package middleware
 */
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 )
 
+// auditKey is the context key for the auditor handling a request
+const auditKey ContextKey = "auditor"
+
+// auditAttrsKey holds attributes added with AddAuditAttrs
+const auditAttrsKey ContextKey = "auditAttrs"
+
+// auditor lets later middleware record events in the audit log
+type auditor struct {
+	logger *slog.Logger
+	attrs  []any
+	method string
+	path   string
+}
+
 type auditOptions struct {
 	headerNames []string
 	logger      *slog.Logger
@@ -73,13 +88,57 @@ func Audit(opts ...AuditOption) func(http.Handler) http.Handler {
 				}
 			}
 
-			// Log headers with slog
+			// Log headers with slog, and the principal if already known
+			record := attrs
+			if p, ok := PrincipalFrom(r.Context()); ok {
+				record = append(record[:len(record):len(record)], "principal", p)
+			}
 			options.logger.InfoContext(r.Context(),
 				options.message,
-				attrs...,
+				record...,
 			)
 
-			next.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), auditKey, &auditor{options.logger, attrs, r.Method, r.URL.Path})
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// AuditEvent records a security relevant event, such as a rejected
+// request, with the logger of the Audit middleware handling r. The record
+// includes the audited headers, method, path and principal. It does
+// nothing when the request is not audited.
+func AuditEvent(r *http.Request, event string, attrs ...any) {
+	if a, ok := r.Context().Value(auditKey).(*auditor); ok {
+		a.record(r.Context(), r.Method, r.URL.Path, slog.LevelWarn, event, attrs)
+	}
+}
+
+func (a *auditor) record(ctx context.Context, method, path string, level slog.Level, event string, attrs []any) {
+	extra := contextAuditAttrs(ctx)
+	all := make([]any, 0, len(a.attrs)+len(extra)+len(attrs)+6)
+	all = append(all, a.attrs...)
+	all = append(all, extra...)
+	all = append(all, "method", method, "path", path)
+	if p, ok := PrincipalFrom(ctx); ok {
+		all = append(all, "principal", p)
+	}
+	all = append(all, attrs...)
+	a.logger.Log(ctx, level, event, all...)
+}
+
+// AddAuditAttrs returns a context whose audit events include attrs, for
+// request state such as a session that middleware outside this package
+// wants in the audit trail
+func AddAuditAttrs(ctx context.Context, attrs ...any) context.Context {
+	existing := contextAuditAttrs(ctx)
+	all := make([]any, 0, len(existing)+len(attrs))
+	all = append(all, existing...)
+	all = append(all, attrs...)
+	return context.WithValue(ctx, auditAttrsKey, all)
+}
+
+func contextAuditAttrs(ctx context.Context) []any {
+	attrs, _ := ctx.Value(auditAttrsKey).([]any)
+	return attrs
+}
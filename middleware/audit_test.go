@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"log/slog"
+
+	"github.com/vhellman/lw-router/routertest"
 )
 
 func TestWithHeaders(t *testing.T) {
@@ -170,3 +172,43 @@ func TestAuditWithMultipleHeaders(t *testing.T) {
 		t.Fatalf("Expected log to contain 'X-Test-Header-2: value2', got %s", buf.String())
 	}
 }
+
+func TestAuditEvent(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(
+		WithHeaders([]string{"Consumer"}),
+		WithLogger(recorder.Logger()),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AuditEvent(r, "Access denied", "reason", "test")
+	}))
+
+	routertest.Post("/orders").Header("Consumer", "billing").Do(t, handler)
+
+	record, ok := recorder.Find("Access denied")
+	if !ok {
+		t.Fatalf("Expected audit event, got %v", recorder.Records())
+	}
+	if record.Level != slog.LevelWarn || record.Attrs["reason"] != "test" ||
+		record.Attrs["Consumer"] != "billing" || record.Attrs["path"] != "/orders" {
+		t.Fatalf("Unexpected audit event: %+v", record)
+	}
+
+	// Without Audit there is nowhere to record the event
+	AuditEvent(routertest.Get("/").Build(t), "ignored")
+}
+
+func TestAddAuditAttrs(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := AddAuditAttrs(r.Context(), "session", "abc")
+		ctx = AddAuditAttrs(ctx, "tenant", "acme")
+		AuditEvent(r.WithContext(ctx), "Access denied")
+	}))
+
+	routertest.Get("/").Do(t, handler)
+
+	record, _ := recorder.Find("Access denied")
+	if record.Attrs["session"] != "abc" || record.Attrs["tenant"] != "acme" {
+		t.Fatalf("Expected added attributes, got %v", record.Attrs)
+	}
+}
@@ -0,0 +1,184 @@
+// pkg/middleware/authorize.go
+package middleware
+
+/**
+ex usage:
+// Per group, after an authentication middleware
+admin := router.Group(middleware.JWT(opts...), middleware.Require("admin"))
+admin.RouteFunc("DELETE /users/{id}", deleteUser)
+
+reports := router.Group(middleware.APIKey(store), middleware.RequireScopes("reports:read"))
+
+// Owner-only access, or admins
+router.Group(auth, middleware.Authorize(middleware.AnyOf(
+	middleware.OwnerOf("id"),
+	middleware.HasRole("admin"),
+))).RouteFunc("GET /users/{id}", getUser)
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrAccessDenied is wrapped by policy errors that deny access
+var ErrAccessDenied = errors.New("access denied")
+
+// Deny returns an error denying access for reason
+func Deny(reason string) error {
+	return fmt.Errorf("%w: %s", ErrAccessDenied, reason)
+}
+
+// AccessRequest is what a Policy decides on. Pattern and path values are
+// only set when the middleware runs in a route or group, after the
+// router matched the request.
+type AccessRequest struct {
+	Principal *Principal
+	Method    string
+	Pattern   string
+	Request   *http.Request
+}
+
+// PathValue returns a path parameter of the matched route
+func (a *AccessRequest) PathValue(name string) string {
+	return a.Request.PathValue(name)
+}
+
+// Policy decides whether a request may proceed. It returns nil to allow,
+// an error wrapping ErrAccessDenied to deny, or any other error if the
+// decision could not be made.
+type Policy interface {
+	Authorize(req *AccessRequest) error
+}
+
+// PolicyFunc adapts a function to Policy
+type PolicyFunc func(req *AccessRequest) error
+
+// Authorize implements Policy
+func (f PolicyFunc) Authorize(req *AccessRequest) error {
+	return f(req)
+}
+
+// HasRole allows principals with any of roles
+func HasRole(roles ...string) Policy {
+	return PolicyFunc(func(req *AccessRequest) error {
+		for _, role := range roles {
+			if req.Principal.HasRole(role) {
+				return nil
+			}
+		}
+		return Deny("requires role " + strings.Join(roles, " or "))
+	})
+}
+
+// HasScopes allows principals granted all of scopes
+func HasScopes(scopes ...string) Policy {
+	return PolicyFunc(func(req *AccessRequest) error {
+		for _, scope := range scopes {
+			if !req.Principal.HasScope(scope) {
+				return Deny("requires scope " + scope)
+			}
+		}
+		return nil
+	})
+}
+
+// OwnerOf allows principals whose ID equals the path parameter param,
+// e.g. OwnerOf("id") for /users/{id}
+func OwnerOf(param string) Policy {
+	return PolicyFunc(func(req *AccessRequest) error {
+		if value := req.PathValue(param); value != "" && value == req.Principal.ID {
+			return nil
+		}
+		return Deny("not the owner of " + param)
+	})
+}
+
+// AllOf allows requests every policy allows
+func AllOf(policies ...Policy) Policy {
+	return PolicyFunc(func(req *AccessRequest) error {
+		for _, policy := range policies {
+			if err := policy.Authorize(req); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AnyOf allows requests any policy allows, even when other policies
+// fail. Otherwise it fails with the errors that are not denials, or
+// denies with the reasons of all policies.
+func AnyOf(policies ...Policy) Policy {
+	return PolicyFunc(func(req *AccessRequest) error {
+		var denials, failures []error
+		for _, policy := range policies {
+			err := policy.Authorize(req)
+			if err == nil {
+				return nil
+			}
+			if errors.Is(err, ErrAccessDenied) {
+				denials = append(denials, err)
+			} else {
+				failures = append(failures, err)
+			}
+		}
+		if len(failures) > 0 {
+			return errors.Join(failures...)
+		}
+		return errors.Join(denials...)
+	})
+}
+
+// Require allows principals with any of roles
+func Require(roles ...string) func(http.Handler) http.Handler {
+	return Authorize(HasRole(roles...))
+}
+
+// RequireScopes allows principals granted all of scopes
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return Authorize(HasScopes(scopes...))
+}
+
+// Authorize creates a middleware that evaluates policy for the request
+// principal. Requests without a principal get 401, denied requests 403
+// and an "Access denied" audit event, and policy errors 500.
+func Authorize(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFrom(r.Context())
+			if !ok {
+				RenderProblem(w, r, Problem{
+					Status: http.StatusUnauthorized,
+					Detail: "Authentication required",
+				})
+				return
+			}
+
+			err := policy.Authorize(&AccessRequest{
+				Principal: principal,
+				Method:    r.Method,
+				Pattern:   r.Pattern,
+				Request:   r,
+			})
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, r)
+			case errors.Is(err, ErrAccessDenied):
+				AuditEvent(r, "Access denied", "pattern", r.Pattern, "reason", err.Error())
+				RenderProblem(w, r, Problem{
+					Status: http.StatusForbidden,
+					Detail: "Access denied",
+				})
+			default:
+				AuditEvent(r, "Authorization failed", "pattern", r.Pattern, "error", err.Error())
+				RenderProblem(w, r, Problem{
+					Status: http.StatusInternalServerError,
+					Detail: "Authorization could not be decided",
+				})
+			}
+		})
+	}
+}
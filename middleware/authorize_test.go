@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+// asPrincipal authenticates every request as p
+func asPrincipal(p *Principal) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p != nil {
+				r = r.WithContext(WithPrincipal(r.Context(), p))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authorizeMux registers pattern behind auth and the middleware, so the
+// pattern and path values are set as in a router group
+func authorizeMux(pattern string, p *Principal, mw func(http.Handler) http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(pattern, asPrincipal(p)(mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))))
+	return mux
+}
+
+func TestRequire(t *testing.T) {
+	admin := &Principal{ID: "ada", Roles: []string{"admin"}, Scopes: []string{"reports:read"}}
+	user := &Principal{ID: "bob", Roles: []string{"user"}}
+
+	cases := []struct {
+		name      string
+		principal *Principal
+		mw        func(http.Handler) http.Handler
+		status    int
+	}{
+		{"role", admin, Require("admin", "owner"), http.StatusOK},
+		{"missing role", user, Require("admin"), http.StatusForbidden},
+		{"scope", admin, RequireScopes("reports:read"), http.StatusOK},
+		{"missing scope", admin, RequireScopes("reports:read", "reports:write"), http.StatusForbidden},
+		{"anonymous", nil, Require("admin"), http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		resp := routertest.Get("/reports").Do(t, authorizeMux("GET /reports", tc.principal, tc.mw))
+		if resp.Result.StatusCode != tc.status {
+			t.Fatalf("%s: Expected status %d, got %d", tc.name, tc.status, resp.Result.StatusCode)
+		}
+	}
+}
+
+func TestAuthorize_Owner(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	policy := AnyOf(OwnerOf("id"), HasRole("admin"))
+	handler := func(p *Principal) http.Handler {
+		return Audit(WithLogger(recorder.Logger()))(authorizeMux("GET /users/{id}", p, Authorize(policy)))
+	}
+
+	routertest.Get("/users/bob").Do(t, handler(&Principal{ID: "bob"})).
+		AssertStatus(http.StatusOK)
+	routertest.Get("/users/bob").Do(t, handler(&Principal{ID: "ada", Roles: []string{"admin"}})).
+		AssertStatus(http.StatusOK)
+	routertest.Get("/users/bob").Do(t, handler(&Principal{ID: "eve"})).
+		AssertStatus(http.StatusForbidden).
+		AssertHeader("Content-Type", "application/problem+json")
+
+	record, ok := recorder.Find("Access denied")
+	if !ok {
+		t.Fatal("Expected an Access denied audit event")
+	}
+	if record.Attrs["pattern"] != "GET /users/{id}" || record.Attrs["principal.id"] != "eve" {
+		t.Fatalf("Expected pattern and principal in the event, got %v", record.Attrs)
+	}
+}
+
+func TestAuthorize_PolicyError(t *testing.T) {
+	failing := PolicyFunc(func(req *AccessRequest) error {
+		if req.Method != http.MethodDelete {
+			return nil
+		}
+		return errors.New("policy store unavailable")
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/items/{id}", asPrincipal(&Principal{ID: "ada"})(Authorize(AllOf(HasRole(), failing))(http.NotFoundHandler())))
+
+	// HasRole without roles denies everyone
+	routertest.Get("/items/1").Do(t, mux).AssertStatus(http.StatusForbidden)
+
+	mux = http.NewServeMux()
+	mux.Handle("/items/{id}", asPrincipal(&Principal{ID: "ada"})(Authorize(AnyOf(HasRole("admin"), failing))(http.NotFoundHandler())))
+	routertest.NewRequest(http.MethodDelete, "/items/1").Do(t, mux).
+		AssertStatus(http.StatusInternalServerError)
+
+	// A failing policy does not block a later one that allows
+	mux = http.NewServeMux()
+	mux.Handle("/items/{id}", asPrincipal(&Principal{ID: "ada"})(Authorize(AnyOf(failing, OwnerOf("id")))(http.NotFoundHandler())))
+	routertest.NewRequest(http.MethodDelete, "/items/ada").Do(t, mux).
+		AssertStatus(http.StatusNotFound)
+	routertest.NewRequest(http.MethodDelete, "/items/bob").Do(t, mux).
+		AssertStatus(http.StatusInternalServerError)
+}
@@ -0,0 +1,240 @@
+// pkg/middleware/basicauth.go
+package middleware
+
+/**
+ex usage:
+users, err := middleware.OpenHtpasswd("/etc/tools/htpasswd")
+router.Use(middleware.BasicAuth(users, "Internal tools"))
+
+// Lock a user or IP out for 15 minutes after 5 failures
+router.Use(middleware.BasicAuth(users, "Internal tools",
+	middleware.WithLockout(5, 15*time.Minute),
+))
+
+// Also offer Digest authentication for legacy clients
+digest, err := middleware.OpenHtdigest("/etc/tools/htdigest")
+router.Use(middleware.BasicAuth(users, "Internal tools", middleware.WithDigest(digest)))
+*/
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type basicAuthOptions struct {
+	maxFailures int
+	lockout     time.Duration
+	digest      DigestSecrets
+}
+
+type BasicAuthOption func(*basicAuthOptions)
+
+// WithLockout rejects a username or client IP for d after maxFailures
+// failed attempts within d. Locked out requests get 429.
+func WithLockout(maxFailures int, d time.Duration) BasicAuthOption {
+	return func(o *basicAuthOptions) {
+		o.maxFailures = maxFailures
+		o.lockout = d
+	}
+}
+
+// WithDigest also accepts RFC 7616 Digest credentials, challenging with
+// SHA-256 and MD5
+func WithDigest(secrets DigestSecrets) BasicAuthOption {
+	return func(o *basicAuthOptions) {
+		o.digest = secrets
+	}
+}
+
+// BasicAuth creates a middleware that requires HTTP Basic credentials
+// checked by verifier. The user becomes the request principal. Failures
+// are recorded with AuditEvent.
+func BasicAuth(verifier PasswordVerifier, realm string, opts ...BasicAuthOption) func(http.Handler) http.Handler {
+	options := &basicAuthOptions{}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	var digest *digestAuth
+	if options.digest != nil {
+		digest = newDigestAuth(options.digest, realm)
+	}
+	var lockouts *lockoutTracker
+	if options.maxFailures > 0 {
+		lockouts = newLockoutTracker(options.maxFailures, options.lockout)
+	}
+
+	challenge := func(w http.ResponseWriter, r *http.Request, stale bool) {
+		if digest != nil {
+			for _, c := range digest.challenges(stale) {
+				w.Header().Add("WWW-Authenticate", c)
+			}
+		}
+		w.Header().Add("WWW-Authenticate", `Basic realm="`+strings.ReplaceAll(realm, `"`, `\"`)+`", charset="UTF-8"`)
+		RenderProblem(w, r, Problem{
+			Status: http.StatusUnauthorized,
+			Detail: "Authentication required",
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+			var user, method string
+			var err error
+			var verified bool
+			switch {
+			case strings.EqualFold(scheme, "Basic"):
+				var password string
+				var ok bool
+				user, password, ok = r.BasicAuth()
+				if !ok {
+					challenge(w, r, false)
+					return
+				}
+				method = "basic"
+				if wait, locked := lockouts.locked(r, user); locked {
+					lockedOut(w, r, user, wait)
+					return
+				}
+				verified = verifier.VerifyPassword(user, password)
+			case digest != nil && strings.EqualFold(scheme, "Digest"):
+				method = "digest"
+				params := parseAuthParams(credentials)
+				if wait, locked := lockouts.locked(r, params["username"]); locked {
+					lockedOut(w, r, params["username"], wait)
+					return
+				}
+				user, err = digest.verify(r, params)
+				if err == errDigestStale {
+					// Not a failed attempt, the client retries with a new nonce
+					challenge(w, r, true)
+					return
+				}
+				verified = err == nil
+			default:
+				challenge(w, r, false)
+				return
+			}
+
+			if !verified {
+				lockouts.fail(r, user)
+				attrs := []any{"user", user, "scheme", method}
+				if err != nil {
+					attrs = append(attrs, "reason", err.Error())
+				}
+				AuditEvent(r, "Authentication failed", attrs...)
+				challenge(w, r, false)
+				return
+			}
+
+			lockouts.reset(user)
+			r = r.WithContext(WithPrincipal(r.Context(), &Principal{ID: user, Method: method}))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func lockedOut(w http.ResponseWriter, r *http.Request, user string, wait time.Duration) {
+	AuditEvent(r, "Authentication locked out", "user", user)
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+	RenderProblem(w, r, Problem{
+		Status: http.StatusTooManyRequests,
+		Detail: "Too many failed attempts",
+	})
+}
+
+// lockoutTracker counts failed attempts per username and client IP. A
+// nil tracker never locks anyone out.
+type lockoutTracker struct {
+	mu          sync.Mutex
+	maxFailures int
+	duration    time.Duration
+	entries     map[string]*lockoutEntry
+	now         func() time.Time
+}
+
+type lockoutEntry struct {
+	failures int
+	// expires ends the counting window, or the lockout once locked
+	expires time.Time
+}
+
+func newLockoutTracker(maxFailures int, d time.Duration) *lockoutTracker {
+	return &lockoutTracker{
+		maxFailures: maxFailures,
+		duration:    d,
+		entries:     make(map[string]*lockoutEntry),
+		now:         time.Now,
+	}
+}
+
+func lockoutKeys(r *http.Request, user string) []string {
+	keys := []string{"ip:" + KeyByIP(r)}
+	if user != "" {
+		keys = append(keys, "user:"+user)
+	}
+	return keys
+}
+
+// locked reports whether the user or client is locked out, and for how long
+func (t *lockoutTracker) locked(r *http.Request, user string) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for _, key := range lockoutKeys(r, user) {
+		entry, ok := t.entries[key]
+		if ok && entry.failures >= t.maxFailures && now.Before(entry.expires) {
+			return entry.expires.Sub(now), true
+		}
+	}
+	return 0, false
+}
+
+func (t *lockoutTracker) fail(r *http.Request, user string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for _, key := range lockoutKeys(r, user) {
+		entry, ok := t.entries[key]
+		if !ok || !now.Before(entry.expires) {
+			entry = &lockoutEntry{expires: now.Add(t.duration)}
+			t.entries[key] = entry
+		}
+		entry.failures++
+		if entry.failures == t.maxFailures {
+			entry.expires = now.Add(t.duration)
+		}
+	}
+
+	// Drop expired entries so failed attempts cannot grow the map forever
+	if len(t.entries) > 1024 {
+		for key, entry := range t.entries {
+			if !now.Before(entry.expires) {
+				delete(t.entries, key)
+			}
+		}
+	}
+}
+
+func (t *lockoutTracker) reset(user string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, "user:"+user)
+}
@@ -0,0 +1,249 @@
+package middleware
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+	"golang.org/x/crypto/argon2"
+)
+
+func argon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=19$m=65536,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func writeHtpasswd(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Rename(tmp, path)
+}
+
+func basicAuthHandler(verifier PasswordVerifier, opts ...BasicAuthOption) http.Handler {
+	return BasicAuth(verifier, "tools", opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := PrincipalFrom(r.Context())
+		w.Write([]byte(r.Context().Value(UserIDKey).(string) + " " + p.Method))
+	}))
+}
+
+func basicHeader(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestHtpasswd(t *testing.T) {
+	bcryptHash, _ := HashPassword("secret")
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	writeHtpasswd(t, path, "# tools", "ada:"+bcryptHash, "bob:"+argon2idHash("hunter2"))
+
+	users, err := OpenHtpasswd(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	users.file.interval = 0
+
+	cases := []struct {
+		user, password string
+		want           bool
+	}{
+		{"ada", "secret", true},
+		{"ada", "wrong", false},
+		{"bob", "hunter2", true},
+		{"bob", "secret", false},
+		{"eve", "secret", false},
+	}
+	for _, tc := range cases {
+		if got := users.VerifyPassword(tc.user, tc.password); got != tc.want {
+			t.Fatalf("%s/%s: Expected %v, got %v", tc.user, tc.password, tc.want, got)
+		}
+	}
+
+	// The file is reloaded when it changes
+	writeHtpasswd(t, path, "eve:"+bcryptHash)
+	if users.VerifyPassword("ada", "secret") || !users.VerifyPassword("eve", "secret") {
+		t.Fatal("Expected the reloaded credentials to apply")
+	}
+
+	// A broken file keeps the previous credentials
+	writeHtpasswd(t, path, "broken")
+	if !users.VerifyPassword("eve", "secret") {
+		t.Fatal("Expected previous credentials after a broken reload")
+	}
+
+	writeHtpasswd(t, path, "old:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=")
+	if _, err := OpenHtpasswd(path); err == nil {
+		t.Fatal("Expected an error for an insecure hash")
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	verifier := PasswordVerifierFunc(func(user, password string) bool {
+		return user == "ada" && password == "secret"
+	})
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(basicAuthHandler(verifier))
+
+	routertest.Get("/").Header("Authorization", basicHeader("ada", "secret")).Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertBody("ada basic")
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusUnauthorized).
+		AssertHeader("WWW-Authenticate", `Basic realm="tools", charset="UTF-8"`)
+
+	routertest.Get("/").Header("Authorization", basicHeader("ada", "wrong")).Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+	record, ok := recorder.Find("Authentication failed")
+	if !ok || record.Attrs["user"] != "ada" {
+		t.Fatalf("Expected an audit event for ada, got %v", record.Attrs)
+	}
+}
+
+func TestBasicAuth_Lockout(t *testing.T) {
+	verifier := PasswordVerifierFunc(func(user, password string) bool {
+		return password == "secret"
+	})
+	handler := basicAuthHandler(verifier, WithLockout(3, time.Minute))
+
+	attempt := func(user, password, ip string) *routertest.Response {
+		return routertest.Get("/").
+			Header("Authorization", basicHeader(user, password)).
+			RemoteAddr(ip+":1234").
+			Do(t, handler)
+	}
+
+	for range 3 {
+		attempt("ada", "wrong", "10.0.0.1").AssertStatus(http.StatusUnauthorized)
+	}
+	// Locked out even with the right password, from any address
+	attempt("ada", "secret", "10.0.0.2").
+		AssertStatus(http.StatusTooManyRequests).
+		AssertHeader("Retry-After", "60")
+	// The client IP is locked out for other users too
+	attempt("bob", "secret", "10.0.0.1").AssertStatus(http.StatusTooManyRequests)
+	// Other users from other addresses are unaffected
+	attempt("bob", "secret", "10.0.0.3").AssertStatus(http.StatusOK)
+}
+
+// digestResponse answers a Digest challenge like a client would
+func digestResponse(challenge, user, password, method, uri string) string {
+	return digestResponseCount(challenge, user, password, method, uri, 1)
+}
+
+// digestResponseCount is digestResponse for a later request on the same nonce
+func digestResponseCount(challenge, user, password, method, uri string, nc int) string {
+	params := parseAuthParams(strings.TrimPrefix(challenge, "Digest "))
+	algorithm := params["algorithm"]
+	count := fmt.Sprintf("%08x", nc)
+	ha1 := digestHash(algorithm, user+":"+params["realm"]+":"+password)
+	ha2 := digestHash(algorithm, method+":"+uri)
+	response := digestHash(algorithm, strings.Join([]string{ha1, params["nonce"], count, "abc", "auth", ha2}, ":"))
+	return fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, qop=auth, nc=%s, cnonce="abc", response=%q`,
+		user, params["realm"], params["nonce"], uri, algorithm, count, response)
+}
+
+func TestBasicAuth_Digest(t *testing.T) {
+	verifier := PasswordVerifierFunc(func(user, password string) bool { return false })
+	handler := basicAuthHandler(verifier, WithDigest(DigestPasswords{"ada": "secret"}))
+
+	resp := routertest.Get("/reports?year=2024").Do(t, handler).AssertStatus(http.StatusUnauthorized)
+	challenges := resp.Result.Header.Values("WWW-Authenticate")
+	if len(challenges) != 3 || !strings.Contains(challenges[0], "algorithm=SHA-256") || !strings.HasPrefix(challenges[2], "Basic") {
+		t.Fatalf("Expected SHA-256, MD5 and Basic challenges, got %v", challenges)
+	}
+
+	for i, challenge := range challenges[:2] {
+		// Both challenges share a nonce, so the second request counts on
+		routertest.Get("/reports?year=2024").
+			Header("Authorization", digestResponseCount(challenge, "ada", "secret", "GET", "/reports?year=2024", i+1)).
+			Do(t, handler).
+			AssertStatus(http.StatusOK).
+			AssertBody("ada digest")
+	}
+
+	routertest.Get("/reports?year=2024").
+		Header("Authorization", digestResponse(challenges[0], "ada", "wrong", "GET", "/reports?year=2024")).
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+	routertest.Get("/other").
+		Header("Authorization", digestResponse(challenges[0], "ada", "secret", "GET", "/reports?year=2024")).
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+}
+
+func TestDigest_StaleNonce(t *testing.T) {
+	digest := newDigestAuth(DigestPasswords{"ada": "secret"}, "tools")
+	nonce := digest.nonce()
+	if err := digest.checkNonce(nonce); err != nil {
+		t.Fatalf("Expected a fresh nonce to be valid, got %v", err)
+	}
+
+	digest.now = func() time.Time { return time.Now().Add(digestNonceTTL + time.Second) }
+	if err := digest.checkNonce(nonce); err != errDigestStale {
+		t.Fatalf("Expected a stale nonce, got %v", err)
+	}
+	if err := digest.checkNonce(nonce[:len(nonce)-2] + "AA"); err == nil || err == errDigestStale {
+		t.Fatalf("Expected a forged nonce to be rejected, got %v", err)
+	}
+}
+
+func TestDigest_Replay(t *testing.T) {
+	verifier := PasswordVerifierFunc(func(user, password string) bool { return false })
+	handler := basicAuthHandler(verifier, WithDigest(DigestPasswords{"ada": "secret"}))
+
+	challenge := routertest.Get("/").Do(t, handler).Result.Header.Get("WWW-Authenticate")
+	authorization := digestResponse(challenge, "ada", "secret", "GET", "/")
+
+	routertest.Get("/").Header("Authorization", authorization).Do(t, handler).AssertStatus(http.StatusOK)
+	routertest.Get("/").Header("Authorization", authorization).Do(t, handler).AssertStatus(http.StatusUnauthorized)
+
+	// The client continues with the next count on the same nonce
+	routertest.Get("/").
+		Header("Authorization", digestResponseCount(challenge, "ada", "secret", "GET", "/", 2)).
+		Do(t, handler).
+		AssertStatus(http.StatusOK)
+	routertest.Get("/").
+		Header("Authorization", digestResponseCount(challenge, "ada", "secret", "GET", "/", 2)).
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+}
+
+func TestHtdigest(t *testing.T) {
+	sum := md5.Sum([]byte("ada:tools:secret"))
+	path := filepath.Join(t.TempDir(), "htdigest")
+	writeHtpasswd(t, path, "ada:tools:"+hex.EncodeToString(sum[:]))
+
+	secrets, err := OpenHtdigest(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ha1, ok := secrets.DigestHA1("ada", "tools", "MD5")
+	if !ok || ha1 != digestHash("MD5", "ada:tools:secret") {
+		t.Fatalf("Expected the MD5 HA1, got %q", ha1)
+	}
+	if _, ok := secrets.DigestHA1("ada", "tools", "SHA-256"); ok {
+		t.Fatal("Expected htdigest not to support SHA-256")
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`username="a\"b", realm="x, y",nc=00000001 , qop=auth`)
+	want := map[string]string{"username": `a"b`, "realm": "x, y", "nc": "00000001", "qop": "auth"}
+	for k, v := range want {
+		if params[k] != v {
+			t.Fatalf("Expected %s=%q, got %q", k, v, params[k])
+		}
+	}
+}
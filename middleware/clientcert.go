@@ -0,0 +1,380 @@
+// pkg/middleware/clientcert.go
+package middleware
+
+/**
+ex usage:
+// Services in the mesh are identified by their SPIFFE ID
+router.Use(middleware.ClientCert(
+	middleware.WithCertRules(
+		middleware.CertRule{SPIFFE: "spiffe://prod.example.com/ns/billing/sa/*", Roles: []string{"billing"}},
+		middleware.CertRule{DNSName: "*.internal.example.com"},
+	),
+	middleware.WithCertRoute("/admin/", middleware.CertRule{CommonName: "ops-*", Roles: []string{"admin"}}),
+	middleware.WithCertExempt("GET /healthz"),
+))
+
+// Behind a TLS terminating proxy that forwards the client certificate
+router.Use(middleware.ClientCert(
+	middleware.WithForwardedCert("X-Client-Cert", "10.0.0.0/8"),
+	middleware.WithClientCAs(pool),
+	middleware.WithCertRules(rules...),
+))
+*/
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ClientCertKey is the context key holding the accepted *x509.Certificate
+const ClientCertKey ContextKey = "clientCert"
+
+// CertRule maps matching client certificates to a principal. Every
+// non-empty field must match; patterns use path.Match syntax.
+type CertRule struct {
+	// SPIFFE matches a spiffe:// URI SAN
+	SPIFFE string
+	// DNSName matches a DNS SAN label by label, so *.example.com matches
+	// api.example.com but not a.b.example.com
+	DNSName string
+	// CommonName matches the subject CN
+	CommonName string
+	// OrganizationalUnit matches one of the subject OUs
+	OrganizationalUnit string
+	// Roles are granted to the principal
+	Roles []string
+}
+
+// match returns the principal for cert if the rule matches. The ID is
+// the matched SPIFFE ID, else the matched DNS name, else the CN.
+func (rule CertRule) match(cert *x509.Certificate) (*Principal, bool) {
+	p := &Principal{Name: cert.Subject.CommonName, Roles: rule.Roles, Method: "mtls"}
+
+	if rule.SPIFFE != "" {
+		id, ok := firstMatch(rule.SPIFFE, spiffeIDs(cert))
+		if !ok {
+			return nil, false
+		}
+		p.ID = id
+	}
+	if rule.DNSName != "" {
+		i := slices.IndexFunc(cert.DNSNames, func(name string) bool {
+			return matchDNSName(rule.DNSName, name)
+		})
+		if i < 0 {
+			return nil, false
+		}
+		name := cert.DNSNames[i]
+		if p.ID == "" {
+			p.ID = name
+		}
+	}
+	if rule.CommonName != "" {
+		if _, ok := firstMatch(rule.CommonName, []string{cert.Subject.CommonName}); !ok {
+			return nil, false
+		}
+	}
+	if rule.OrganizationalUnit != "" {
+		if _, ok := firstMatch(rule.OrganizationalUnit, cert.Subject.OrganizationalUnit); !ok {
+			return nil, false
+		}
+	}
+
+	if p.ID == "" {
+		if ids := spiffeIDs(cert); len(ids) > 0 {
+			p.ID = ids[0]
+		} else {
+			p.ID = cert.Subject.CommonName
+		}
+	}
+	return p, p.ID != ""
+}
+
+func firstMatch(pattern string, values []string) (string, bool) {
+	for _, value := range values {
+		if ok, _ := path.Match(pattern, value); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// matchDNSName matches a DNS name against pattern one label at a time,
+// so a wildcard never spans a dot. Names are compared case-insensitively.
+func matchDNSName(pattern, name string) bool {
+	patternLabels := strings.Split(strings.ToLower(pattern), ".")
+	nameLabels := strings.Split(strings.ToLower(name), ".")
+	if len(patternLabels) != len(nameLabels) {
+		return false
+	}
+	for i, label := range patternLabels {
+		if ok, _ := path.Match(label, nameLabels[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func spiffeIDs(cert *x509.Certificate) []string {
+	var ids []string
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			ids = append(ids, uri.String())
+		}
+	}
+	return ids
+}
+
+type clientCertOptions struct {
+	rules      []CertRule
+	routes     *http.ServeMux
+	routeRules map[string][]CertRule
+	exempt     *http.ServeMux
+	exempted   map[string]bool
+	header     string
+	proxies    []netip.Prefix
+	roots      *x509.CertPool
+}
+
+type ClientCertOption func(*clientCertOptions)
+
+// WithCertRules sets the rules certificates are mapped with. The first
+// matching rule wins; without rules every verified certificate is
+// accepted.
+func WithCertRules(rules ...CertRule) ClientCertOption {
+	return func(o *clientCertOptions) {
+		o.rules = rules
+	}
+}
+
+// WithCertRoute uses rules instead of the default rules for requests
+// matching a http.ServeMux pattern. It panics if the pattern was already
+// given.
+func WithCertRoute(pattern string, rules ...CertRule) ClientCertOption {
+	return func(o *clientCertOptions) {
+		if _, ok := o.routeRules[pattern]; ok {
+			panic("middleware: duplicate client certificate route " + pattern)
+		}
+		o.routes.Handle(pattern, http.NotFoundHandler())
+		o.routeRules[pattern] = rules
+	}
+}
+
+// WithCertExempt lets requests matching the patterns through without a
+// certificate. A certificate matching the rules for the request, which
+// may come from WithCertRoute, still sets the principal. It panics if a
+// pattern was already exempted.
+func WithCertExempt(patterns ...string) ClientCertOption {
+	return func(o *clientCertOptions) {
+		for _, pattern := range patterns {
+			if o.exempted[pattern] {
+				panic("middleware: duplicate client certificate exemption " + pattern)
+			}
+			o.exempt.Handle(pattern, http.NotFoundHandler())
+			o.exempted[pattern] = true
+		}
+	}
+}
+
+// WithForwardedCert accepts a certificate forwarded by a TLS terminating
+// proxy in header, as URL escaped PEM or base64 DER, from the given IPs
+// or CIDR ranges only. The header is removed from other requests.
+// Forwarded certificates are verified against WithClientCAs, which is
+// required with it.
+func WithForwardedCert(header string, trustedProxies ...string) ClientCertOption {
+	return func(o *clientCertOptions) {
+		o.header = header
+		for _, proxy := range trustedProxies {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(proxy)
+				if addrErr != nil {
+					panic("middleware: invalid trusted proxy " + proxy)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			o.proxies = append(o.proxies, prefix)
+		}
+	}
+}
+
+// WithClientCAs verifies certificates against roots. It is required with
+// WithForwardedCert, and TLS connections that requested but did not
+// verify certificates are only accepted with it.
+func WithClientCAs(roots *x509.CertPool) ClientCertOption {
+	return func(o *clientCertOptions) {
+		o.roots = roots
+	}
+}
+
+// ClientCertificate returns the client certificate accepted for the request
+func ClientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(ClientCertKey).(*x509.Certificate)
+	return cert, ok
+}
+
+// ClientCert creates a middleware that identifies callers by their TLS
+// client certificate. Requests without a usable certificate get 401 and
+// certificates no rule matches 403, both with an audit event. It panics
+// if WithForwardedCert is given without WithClientCAs.
+func ClientCert(opts ...ClientCertOption) func(http.Handler) http.Handler {
+	options := &clientCertOptions{
+		routes:     http.NewServeMux(),
+		routeRules: make(map[string][]CertRule),
+		exempt:     http.NewServeMux(),
+		exempted:   make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.header != "" && options.roots == nil {
+		panic("middleware: WithForwardedCert requires WithClientCAs")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rules := options.rules
+			if _, pattern := options.routes.Handler(r); pattern != "" {
+				rules = options.routeRules[pattern]
+			}
+			_, pattern := options.exempt.Handler(r)
+			exempt := pattern != ""
+
+			cert, err := options.certificate(r)
+			if cert == nil {
+				if exempt {
+					next.ServeHTTP(w, r)
+					return
+				}
+				reason := "no client certificate"
+				if err != nil {
+					reason = err.Error()
+				}
+				AuditEvent(r, "Client certificate rejected", "reason", reason)
+				RenderProblem(w, r, Problem{
+					Status: http.StatusUnauthorized,
+					Detail: "Client certificate required",
+				})
+				return
+			}
+
+			principal, ok := mapCertificate(cert, rules)
+			if !ok {
+				if exempt {
+					next.ServeHTTP(w, r)
+					return
+				}
+				AuditEvent(r, "Client certificate rejected", "reason", "no matching rule",
+					"subject", cert.Subject.String())
+				RenderProblem(w, r, Problem{
+					Status: http.StatusForbidden,
+					Detail: "Client certificate not allowed",
+				})
+				return
+			}
+
+			ctx := WithPrincipal(r.Context(), principal)
+			r = r.WithContext(context.WithValue(ctx, ClientCertKey, cert))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func mapCertificate(cert *x509.Certificate, rules []CertRule) (*Principal, bool) {
+	if len(rules) == 0 {
+		return CertRule{}.match(cert)
+	}
+	for _, rule := range rules {
+		if p, ok := rule.match(cert); ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// certificate returns the verified client certificate of r, if any
+func (o *clientCertOptions) certificate(r *http.Request) (*x509.Certificate, error) {
+	if o.header != "" {
+		value := r.Header.Get(o.header)
+		if value != "" && o.trustedProxy(r) {
+			cert, err := parseForwardedCert(value)
+			if err != nil {
+				return nil, err
+			}
+			if err := o.verify(cert, nil); err != nil {
+				return nil, err
+			}
+			return cert, nil
+		}
+		// Nobody else may claim a certificate through the header
+		r.Header.Del(o.header)
+	}
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+	if len(r.TLS.VerifiedChains) > 0 {
+		return cert, nil
+	}
+	if o.roots == nil {
+		return nil, errors.New("client certificate not verified")
+	}
+	if err := o.verify(cert, r.TLS.PeerCertificates[1:]); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func (o *clientCertOptions) verify(cert *x509.Certificate, chain []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         o.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+func (o *clientCertOptions) trustedProxy(r *http.Request) bool {
+	addr, err := netip.ParseAddr(KeyByIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(o.proxies, func(p netip.Prefix) bool {
+		return p.Contains(addr)
+	})
+}
+
+// parseForwardedCert accepts URL escaped PEM, as sent by nginx, or
+// base64 DER
+func parseForwardedCert(value string) (*x509.Certificate, error) {
+	value = strings.Trim(value, `"`)
+	if unescaped, err := url.QueryUnescape(value); err == nil && strings.Contains(unescaped, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(unescaped))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, errors.New("invalid forwarded certificate")
+		}
+		return x509.ParseCertificate(block.Bytes)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid forwarded certificate")
+	}
+	return x509.ParseCertificate(der)
+}
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue creates a client certificate; spiffe and dns become SANs
+func (ca *testCA) issue(t *testing.T, cn, spiffe string, dns ...string) *x509.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: []string{"platform"}},
+		DNSNames:     dns,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if spiffe != "" {
+		uri, _ := url.Parse(spiffe)
+		template.URIs = []*url.URL{uri}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// serveWithCert serves a request whose TLS connection presented cert
+func serveWithCert(t *testing.T, handler http.Handler, target string, cert *x509.Certificate, verified bool) *routertest.Response {
+	t.Helper()
+	state := &tls.ConnectionState{}
+	if cert != nil {
+		state.PeerCertificates = []*x509.Certificate{cert}
+		if verified {
+			state.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+	}
+	return routertest.Get(target).TLS(state).Do(t, handler)
+}
+
+func principalEcho() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte(p.ID + " " + p.Method + " " + p.Name))
+		for _, role := range p.Roles {
+			w.Write([]byte(" " + role))
+		}
+	})
+}
+
+func TestClientCert_Rules(t *testing.T) {
+	ca := newTestCA(t)
+	billing := ca.issue(t, "billing", "spiffe://prod.example.com/ns/billing/sa/api")
+	web := ca.issue(t, "web", "", "web.internal.example.com")
+	ops := ca.issue(t, "ops-ada", "")
+	stranger := ca.issue(t, "stranger", "", "stranger.example.org")
+
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(ClientCert(
+		WithCertRules(
+			CertRule{SPIFFE: "spiffe://prod.example.com/ns/billing/sa/*", Roles: []string{"billing"}},
+			CertRule{DNSName: "*.internal.example.com", OrganizationalUnit: "platform"},
+		),
+		WithCertRoute("/admin/", CertRule{CommonName: "ops-*", Roles: []string{"admin"}}),
+		WithCertExempt("GET /healthz"),
+	)(principalEcho()))
+
+	serveWithCert(t, handler, "/", billing, true).
+		AssertStatus(http.StatusOK).
+		AssertBody("spiffe://prod.example.com/ns/billing/sa/api mtls billing billing")
+	serveWithCert(t, handler, "/", web, true).
+		AssertStatus(http.StatusOK).
+		AssertBody("web.internal.example.com mtls web")
+	serveWithCert(t, handler, "/", stranger, true).
+		AssertStatus(http.StatusForbidden)
+
+	// Per route rules replace the defaults
+	serveWithCert(t, handler, "/admin/users", ops, true).
+		AssertStatus(http.StatusOK).
+		AssertBody("ops-ada mtls ops-ada admin")
+	serveWithCert(t, handler, "/admin/users", billing, true).
+		AssertStatus(http.StatusForbidden)
+
+	// Missing and unverified certificates
+	serveWithCert(t, handler, "/", nil, false).AssertStatus(http.StatusUnauthorized)
+	serveWithCert(t, handler, "/", billing, false).AssertStatus(http.StatusUnauthorized)
+	serveWithCert(t, handler, "/healthz", nil, false).
+		AssertStatus(http.StatusOK).
+		AssertBody("anonymous")
+
+	record, ok := recorder.Find("Client certificate rejected")
+	if !ok || record.Attrs["reason"] == nil {
+		t.Fatalf("Expected an audit event with a reason, got %v", record.Attrs)
+	}
+}
+
+func TestClientCert_ExemptRoute(t *testing.T) {
+	ca := newTestCA(t)
+	ops := ca.issue(t, "ops-ada", "")
+	web := ca.issue(t, "web", "", "web.internal.example.com")
+
+	// The same pattern may be both exempt and have its own rules
+	handler := ClientCert(
+		WithCertRules(CertRule{DNSName: "*.internal.example.com"}),
+		WithCertRoute("/status/", CertRule{CommonName: "ops-*", Roles: []string{"admin"}}),
+		WithCertExempt("/status/"),
+	)(principalEcho())
+
+	serveWithCert(t, handler, "/status/db", nil, false).AssertBody("anonymous")
+	serveWithCert(t, handler, "/status/db", ops, true).AssertBody("ops-ada mtls ops-ada admin")
+	// The route rules still apply, so the default rules do not match here
+	serveWithCert(t, handler, "/status/db", web, true).AssertBody("anonymous")
+	serveWithCert(t, handler, "/", web, true).AssertBody("web.internal.example.com mtls web")
+}
+
+func TestClientCert_DuplicatePatternsPanic(t *testing.T) {
+	for name, opts := range map[string][]ClientCertOption{
+		"route":  {WithCertRoute("/admin/"), WithCertRoute("/admin/")},
+		"exempt": {WithCertExempt("GET /healthz", "GET /healthz")},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Expected a duplicate %s to panic", name)
+				}
+			}()
+			ClientCert(opts...)
+		}()
+	}
+}
+
+func TestMatchDNSName(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "API.Example.com", true},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"*example.com", "evil.example.com", false},
+		{"api-*.example.com", "api-1.example.com", true},
+		{"*.*.example.com", "a.b.example.com", true},
+	}
+	for _, c := range cases {
+		if got := matchDNSName(c.pattern, c.name); got != c.want {
+			t.Fatalf("Expected %v for %s against %s, got %v", c.want, c.name, c.pattern, got)
+		}
+	}
+}
+
+func TestClientCert_Verify(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+	handler := ClientCert(WithClientCAs(ca.pool))(principalEcho())
+
+	serveWithCert(t, handler, "/", ca.issue(t, "svc", ""), false).
+		AssertStatus(http.StatusOK).
+		AssertBody("svc mtls svc")
+	serveWithCert(t, handler, "/", other.issue(t, "svc", ""), false).
+		AssertStatus(http.StatusUnauthorized)
+}
+
+func TestClientCert_Forwarded(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, "svc", "spiffe://prod.example.com/svc")
+	escapedPEM := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	der := base64.StdEncoding.EncodeToString(cert.Raw)
+
+	handler := ClientCert(
+		WithForwardedCert("X-Client-Cert", "10.0.0.0/8", "192.0.2.7"),
+		WithClientCAs(ca.pool),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := ClientCertificate(r.Context())
+		w.Write([]byte(c.Subject.CommonName))
+	}))
+
+	for _, value := range []string{escapedPEM, der} {
+		routertest.Get("/").
+			Header("X-Client-Cert", value).
+			RemoteAddr("10.1.2.3:4567").
+			Do(t, handler).
+			AssertStatus(http.StatusOK).
+			AssertBody("svc")
+	}
+
+	// The header is ignored from untrusted addresses
+	routertest.Get("/").
+		Header("X-Client-Cert", der).
+		RemoteAddr("203.0.113.9:4567").
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+
+	// Forwarded certificates are verified too
+	forged := newTestCA(t).issue(t, "svc", "")
+	routertest.Get("/").
+		Header("X-Client-Cert", base64.StdEncoding.EncodeToString(forged.Raw)).
+		RemoteAddr("192.0.2.7:4567").
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+}
+
+func TestClientCert_ForwardedWithoutCAsPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected WithForwardedCert without WithClientCAs to panic")
+		}
+	}()
+	ClientCert(WithForwardedCert("X-Client-Cert", "10.0.0.0/8"))
+}
@@ -0,0 +1,408 @@
+// pkg/middleware/compress.go
+package middleware
+
+/**
+ex usage:
+router.Use(middleware.Compress())
+
+// Prefer brotli when the client accepts it
+router.Use(middleware.Compress(
+	middleware.WithCompressEncoder("br", func(w io.Writer) middleware.CompressWriter {
+		return brotli.NewWriterLevel(w, 5)
+	}),
+	middleware.WithCompressMinSize(512),
+	middleware.WithCompressTypes("text/*", "application/json"),
+))
+*/
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressWriter is a compressing writer that can be reused with Reset.
+// *gzip.Writer, *zlib.Writer and the common brotli and zstd writers
+// implement it.
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// CompressEncoder creates a writer for one content coding
+type CompressEncoder func(w io.Writer) CompressWriter
+
+type compressEncoding struct {
+	name string
+	pool *sync.Pool
+}
+
+type compressOptions struct {
+	level     int
+	minSize   int
+	types     []string
+	custom    []string
+	encoders  map[string]CompressEncoder
+	encodings []compressEncoding
+}
+
+type CompressOption func(*compressOptions)
+
+// WithCompressLevel sets the gzip and deflate level, 5 by default
+func WithCompressLevel(level int) CompressOption {
+	return func(o *compressOptions) {
+		o.level = level
+	}
+}
+
+// WithCompressMinSize sets the smallest body worth compressing, 1024
+// bytes by default
+func WithCompressMinSize(n int) CompressOption {
+	return func(o *compressOptions) {
+		o.minSize = n
+	}
+}
+
+// WithCompressTypes sets the media types that are compressed. Patterns
+// use path.Match, e.g. text/* or application/*+json.
+func WithCompressTypes(types ...string) CompressOption {
+	return func(o *compressOptions) {
+		o.types = types
+	}
+}
+
+// WithCompressEncoder adds a content coding such as br or zstd, or
+// replaces gzip or deflate. Added codings are preferred over the built-in
+// ones when the client accepts several with the same q-value.
+func WithCompressEncoder(name string, encoder CompressEncoder) CompressOption {
+	return func(o *compressOptions) {
+		name = strings.ToLower(name)
+		if _, ok := o.encoders[name]; !ok {
+			o.custom = append(o.custom, name)
+		}
+		o.encoders[name] = encoder
+	}
+}
+
+// Compress creates a middleware that compresses responses with the best
+// coding the client accepts. Only responses of an allowed type and at
+// least the minimum size are compressed; the decision waits until enough
+// of the body is written, or until Flush for streaming responses.
+// Responses that are already encoded, partial, or marked no-transform
+// pass through unchanged. If the handler panics the compressed stream is
+// left without its trailer, so the client sees a truncated body.
+func Compress(opts ...CompressOption) func(http.Handler) http.Handler {
+	options := &compressOptions{
+		level:   5,
+		minSize: 1024,
+		types: []string{
+			"text/*",
+			"application/json",
+			"application/*+json",
+			"application/javascript",
+			"application/xml",
+			"application/*+xml",
+			"image/svg+xml",
+		},
+		encoders: make(map[string]CompressEncoder),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	builtin := map[string]CompressEncoder{
+		"gzip": func(w io.Writer) CompressWriter {
+			gw, err := gzip.NewWriterLevel(w, options.level)
+			if err != nil {
+				gw = gzip.NewWriter(w)
+			}
+			return gw
+		},
+		"deflate": func(w io.Writer) CompressWriter {
+			// The HTTP deflate coding is the zlib format
+			zw, err := zlib.NewWriterLevel(w, options.level)
+			if err != nil {
+				zw = zlib.NewWriter(w)
+			}
+			return zw
+		},
+	}
+	names := options.custom
+	for _, name := range []string{"gzip", "deflate"} {
+		if _, ok := options.encoders[name]; !ok {
+			options.encoders[name] = builtin[name]
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		encoder := options.encoders[name]
+		options.encodings = append(options.encodings, compressEncoding{
+			name: name,
+			pool: &sync.Pool{New: func() any { return encoder(io.Discard) }},
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &compressResponseWriter{
+				ResponseWriter: w,
+				options:        options,
+				head:           r.Method == http.MethodHead,
+				encoding:       options.negotiate(r.Header.Get("Accept-Encoding")),
+				length:         -1,
+			}
+			panicked := true
+			defer func() { cw.finish(panicked) }()
+			next.ServeHTTP(cw, r)
+			panicked = false
+		})
+	}
+}
+
+// negotiate picks the accepted coding with the highest q-value, breaking
+// ties by server preference. It returns nil for identity.
+func (o *compressOptions) negotiate(header string) *compressEncoding {
+	if header == "" {
+		return nil
+	}
+
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		accepted[coding] = q
+	}
+
+	var best *compressEncoding
+	bestQ := 0.0
+	for i, encoding := range o.encodings {
+		q, ok := accepted[encoding.name]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = &o.encodings[i], q
+		}
+	}
+	return best
+}
+
+// allowedType reports whether the media type may be compressed
+func (o *compressOptions) allowedType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range o.types {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compressResponseWriter holds back the start of the body until it
+// knows whether the response is worth compressing
+type compressResponseWriter struct {
+	http.ResponseWriter
+	options  *compressOptions
+	head     bool
+	encoding *compressEncoding
+
+	status      int
+	length      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	cw          CompressWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < 200 {
+		// Informational responses are followed by the real one
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+
+	h := w.Header()
+	if w.head || w.passThrough() || (h.Get("Content-Type") != "" && !w.options.allowedType(h.Get("Content-Type"))) {
+		w.decide(false)
+		return
+	}
+	if length, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		if h.Get("Content-Type") == "" {
+			// Decided on the first Write, once the type can be sniffed
+			w.length = length
+			return
+		}
+		w.decide(length >= w.options.minSize)
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.cw != nil {
+			return w.cw.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.options.minSize || w.length >= 0 {
+		if err := w.decide(len(w.buf) >= w.options.minSize || w.length >= w.options.minSize); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush commits to compressing a streaming response so the data sent so
+// far reaches the client
+func (w *compressResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.cw != nil {
+		w.cw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// passThrough reports whether the status or headers rule out compression
+func (w *compressResponseWriter) passThrough() bool {
+	h := w.Header()
+	switch {
+	case w.status == http.StatusNoContent, w.status == http.StatusNotModified, w.status == http.StatusPartialContent:
+		return true
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "":
+		return true
+	}
+	return strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform")
+}
+
+// compressible reports whether the response may be compressed, ignoring
+// its size. The Content-Type is sniffed from the buffered body when the
+// handler did not set one, as the server would otherwise sniff the
+// compressed bytes.
+func (w *compressResponseWriter) compressible() bool {
+	if w.passThrough() {
+		return false
+	}
+	h := w.Header()
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		if _, set := h["Content-Type"]; set || len(w.buf) == 0 {
+			return false
+		}
+		contentType = http.DetectContentType(w.buf)
+		h.Set("Content-Type", contentType)
+	}
+	return w.options.allowedType(contentType)
+}
+
+// decide sends the header, compressing if requested and possible, and
+// writes out the buffered body
+func (w *compressResponseWriter) decide(compress bool) error {
+	if w.decided {
+		return nil
+	}
+	w.decided = true
+
+	h := w.Header()
+	if w.compressible() {
+		if !varyIncludes(h, "Accept-Encoding") {
+			h.Add("Vary", "Accept-Encoding")
+		}
+		if compress && w.encoding != nil && !w.head {
+			h.Set("Content-Encoding", w.encoding.name)
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			w.cw = w.encoding.pool.Get().(CompressWriter)
+			w.cw.Reset(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	if w.cw != nil {
+		_, err := w.cw.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// finish writes what is left once the handler returns and returns the
+// compressor to its pool. After a panic nothing more is written: a held
+// back response is dropped and a compressed one gets no trailer.
+func (w *compressResponseWriter) finish(panicked bool) {
+	if !w.wroteHeader {
+		return
+	}
+	if !w.decided && !panicked {
+		w.decide(len(w.buf) >= w.options.minSize)
+	}
+	if w.cw != nil {
+		if !panicked {
+			w.cw.Close()
+		}
+		w.cw.Reset(io.Discard)
+		w.encoding.pool.Put(w.cw)
+		w.cw = nil
+	}
+}
+
+func varyIncludes(h http.Header, name string) bool {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
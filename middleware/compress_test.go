@@ -0,0 +1,322 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+var largeText = strings.Repeat("compressible text ", 200)
+
+func textHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, body)
+	})
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected gzip body, got %v", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Expected valid gzip stream, got %v", err)
+	}
+	return string(plain)
+}
+
+func TestCompress_Negotiate(t *testing.T) {
+	options := &compressOptions{}
+	for _, name := range []string{"br", "gzip", "deflate"} {
+		options.encodings = append(options.encodings, compressEncoding{name: name})
+	}
+
+	cases := map[string]string{
+		"":                          "",
+		"gzip":                      "gzip",
+		"deflate, gzip":             "gzip",
+		"gzip, br":                  "br",
+		"br;q=0.5, gzip":            "gzip",
+		"gzip;q=0.8, deflate;q=0.9": "deflate",
+		"*":                         "br",
+		"*;q=0.5, br;q=0":           "gzip",
+		"gzip;q=0, identity":        "",
+		"x-gzip":                    "gzip",
+		"GZIP;Q=0.5":                "gzip",
+		"zstd, compress":            "",
+	}
+	for header, want := range cases {
+		got := ""
+		if encoding := options.negotiate(header); encoding != nil {
+			got = encoding.name
+		}
+		if got != want {
+			t.Fatalf("Expected %q for Accept-Encoding %q, got %q", want, header, got)
+		}
+	}
+}
+
+func TestCompress_Gzip(t *testing.T) {
+	handler := Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "99999")
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, largeText)
+	}))
+
+	resp := routertest.Get("/").Header("Accept-Encoding", "gzip, deflate").Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Encoding", "gzip").
+		AssertHeader("Vary", "Accept-Encoding").
+		AssertHeader("ETag", `W/"v1"`).
+		AssertHeaderAbsent("Content-Length").
+		AssertHeaderAbsent("Accept-Ranges")
+	if got := gunzip(t, resp.Recorder.Body.Bytes()); got != largeText {
+		t.Fatalf("Expected body to round trip, got %d bytes", len(got))
+	}
+	if resp.Recorder.Body.Len() >= len(largeText) {
+		t.Fatalf("Expected compressed body, got %d bytes", resp.Recorder.Body.Len())
+	}
+}
+
+func TestCompress_Deflate(t *testing.T) {
+	resp := routertest.Get("/").Header("Accept-Encoding", "deflate").Do(t, Compress()(textHandler(largeText))).
+		AssertHeader("Content-Encoding", "deflate")
+
+	zr, err := zlib.NewReader(bytes.NewReader(resp.Recorder.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected zlib body, got %v", err)
+	}
+	if plain, _ := io.ReadAll(zr); string(plain) != largeText {
+		t.Fatalf("Expected body to round trip, got %d bytes", len(plain))
+	}
+}
+
+func TestCompress_PassThrough(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.Handler
+		req     *routertest.RequestBuilder
+		vary    bool
+	}{
+		{"no accept-encoding", textHandler(largeText), routertest.Get("/"), true},
+		{"below min size", textHandler("small"), routertest.Get("/").Header("Accept-Encoding", "gzip"), true},
+		{"type not allowed", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, largeText)
+		}), routertest.Get("/").Header("Accept-Encoding", "gzip"), false},
+		{"already encoded", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, largeText)
+		}), routertest.Get("/").Header("Accept-Encoding", "gzip"), false},
+		{"no-transform", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Cache-Control", "public, no-transform")
+			io.WriteString(w, largeText)
+		}), routertest.Get("/").Header("Accept-Encoding", "gzip"), false},
+		{"range", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "data.txt", time.Time{}, strings.NewReader(largeText))
+		}), routertest.Get("/").Header("Accept-Encoding", "gzip").Header("Range", "bytes=0-9"), false},
+		{"head", textHandler(""), routertest.NewRequest(http.MethodHead, "/").Header("Accept-Encoding", "gzip"), true},
+		{"small content-length", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "5")
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, "hello")
+		}), routertest.Get("/").Header("Accept-Encoding", "gzip"), true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Compress()(tc.handler).ServeHTTP(rec, tc.req.Build(t))
+			if rec.Header().Get("Content-Encoding") == "gzip" {
+				t.Fatal("Expected response not to be compressed")
+			}
+			if got := rec.Header().Get("Vary") == "Accept-Encoding"; got != tc.vary {
+				t.Fatalf("Expected Vary set to be %v, got %q", tc.vary, rec.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCompress_RangeBody(t *testing.T) {
+	handler := Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.txt", time.Time{}, strings.NewReader(largeText))
+	}))
+
+	routertest.Get("/").Header("Accept-Encoding", "gzip").Header("Range", "bytes=0-9").Do(t, handler).
+		AssertStatus(http.StatusPartialContent).
+		AssertHeader("Content-Length", "10").
+		AssertHeaderAbsent("Content-Encoding")
+}
+
+func TestCompress_SniffsContentType(t *testing.T) {
+	html := "<!DOCTYPE html><html><body>" + largeText + "</body></html>"
+	handler := Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, html)
+	}))
+
+	resp := routertest.Get("/").Header("Accept-Encoding", "gzip").Do(t, handler).
+		AssertHeader("Content-Type", "text/html; charset=utf-8").
+		AssertHeader("Content-Encoding", "gzip")
+	if got := gunzip(t, resp.Recorder.Body.Bytes()); got != html {
+		t.Fatalf("Expected body to round trip, got %d bytes", len(got))
+	}
+}
+
+func TestCompress_SniffsContentTypeWithLength(t *testing.T) {
+	handler := Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(largeText)))
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, largeText[:100])
+		io.WriteString(w, largeText[100:])
+	}))
+
+	resp := routertest.Get("/").Header("Accept-Encoding", "gzip").Do(t, handler).
+		AssertHeader("Content-Type", "text/plain; charset=utf-8").
+		AssertHeader("Content-Encoding", "gzip").
+		AssertHeaderAbsent("Content-Length")
+	if got := gunzip(t, resp.Recorder.Body.Bytes()); got != largeText {
+		t.Fatalf("Expected body to round trip, got %d bytes", len(got))
+	}
+}
+
+func TestCompress_Panic(t *testing.T) {
+	serve := func(handler http.Handler) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Expected the handler panic to propagate")
+				}
+			}()
+			handler.ServeHTTP(rec, req)
+		}()
+		return rec
+	}
+
+	// A held back response is dropped, leaving the recoverer to answer
+	rec := serve(Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial")
+		panic("handler failed")
+	})))
+	if rec.Body.Len() != 0 || rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Expected nothing to be written, got %q", rec.Body.String())
+	}
+
+	// A compressed response is cut off without the gzip trailer
+	rec = serve(Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, largeText)
+		w.(http.Flusher).Flush()
+		panic("handler failed")
+	})))
+	zr, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected the start of a gzip body, got %v", err)
+	}
+	if _, err := io.ReadAll(zr); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected a truncated gzip stream, got %v", err)
+	}
+
+	// The writer went back to the pool in a usable state
+	resp := routertest.Get("/").Header("Accept-Encoding", "gzip").Do(t, Compress()(textHandler(largeText)))
+	if got := gunzip(t, resp.Recorder.Body.Bytes()); got != largeText {
+		t.Fatalf("Expected body to round trip, got %d bytes", len(got))
+	}
+}
+
+func TestCompress_ExistingVary(t *testing.T) {
+	handler := Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Vary", "Origin, accept-encoding")
+		io.WriteString(w, largeText)
+	}))
+
+	resp := routertest.Get("/").Header("Accept-Encoding", "gzip").Do(t, handler)
+	if vary := resp.Result.Header.Values("Vary"); len(vary) != 1 {
+		t.Fatalf("Expected Vary not to be repeated, got %v", vary)
+	}
+}
+
+func TestCompress_Flush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var flushed []byte
+	handler := Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		flushed = bytes.Clone(rec.Body.Bytes())
+		io.WriteString(w, "second\n")
+	}))
+	handler.ServeHTTP(rec, routertest.Get("/").Header("Accept-Encoding", "gzip").Build(t))
+
+	if !rec.Flushed || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected flushed gzip response, got %v %v", rec.Flushed, rec.Header())
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(flushed))
+	if err != nil {
+		t.Fatalf("Expected gzip header to be flushed, got %v", err)
+	}
+	first := make([]byte, len("first\n"))
+	if _, err := io.ReadFull(zr, first); err != nil || string(first) != "first\n" {
+		t.Fatalf("Expected first chunk before the handler finished, got %q %v", first, err)
+	}
+	if got := gunzip(t, rec.Body.Bytes()); got != "first\nsecond\n" {
+		t.Fatalf("Expected full stream, got %q", got)
+	}
+}
+
+// upperWriter is a stand-in for an external encoder such as brotli
+type upperWriter struct {
+	w io.Writer
+}
+
+func (u *upperWriter) Write(b []byte) (int, error) { return u.w.Write(bytes.ToUpper(b)) }
+func (u *upperWriter) Flush() error                { return nil }
+func (u *upperWriter) Close() error                { return nil }
+func (u *upperWriter) Reset(w io.Writer)           { u.w = w }
+
+func TestCompress_CustomEncoder(t *testing.T) {
+	created := 0
+	handler := Compress(
+		WithCompressEncoder("br", func(w io.Writer) CompressWriter {
+			created++
+			return &upperWriter{w: w}
+		}),
+		WithCompressMinSize(4),
+		WithCompressTypes("text/*"),
+	)(textHandler("hello"))
+
+	for i := 0; i < 20; i++ {
+		routertest.Get("/").Header("Accept-Encoding", "gzip, br").Do(t, handler).
+			AssertHeader("Content-Encoding", "br").
+			AssertBody("HELLO")
+	}
+	if created >= 20 {
+		t.Fatalf("Expected pooled writers to be reused, got %d created", created)
+	}
+
+	resp := routertest.Get("/").Header("Accept-Encoding", "br;q=0.1, gzip").Do(t, handler).
+		AssertHeader("Content-Encoding", "gzip")
+	if got := gunzip(t, resp.Recorder.Body.Bytes()); got != "hello" {
+		t.Fatalf("Expected gzip fallback, got %q", got)
+	}
+}
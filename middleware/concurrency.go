@@ -0,0 +1,309 @@
+// pkg/middleware/concurrency.go
+package middleware
+
+/**
+ex usage:
+// At most 100 requests in flight, 200 more may wait up to 2s
+router.Use(middleware.ConcurrencyLimit(100, 200,
+	middleware.WithQueueTimeout(2*time.Second),
+	middleware.WithQueueMode(middleware.AdaptiveLIFO),
+))
+
+// A tighter limit for an expensive route group
+reports := router.Group(middleware.ConcurrencyLimit(4, 10))
+reports.RouteFunc("GET /reports/{id}", reportHandler)
+*/
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueueMode decides which waiting request is admitted next
+type QueueMode int
+
+const (
+	// FIFO admits the request that has waited longest
+	FIFO QueueMode = iota
+	// LIFO admits the newest request. When the queue is full the oldest
+	// request is shed to make room, so fresh requests win under overload.
+	LIFO
+	// AdaptiveLIFO behaves like FIFO until the queue is half full and like
+	// LIFO beyond that
+	AdaptiveLIFO
+)
+
+var (
+	errShed         = errors.New("request shed")
+	errQueueTimeout = errors.New("queue wait timed out")
+)
+
+// waiter is a request waiting for a slot. The outcome is sent on result
+// while the admission lock is held, so it is never lost.
+type waiter struct {
+	result chan error
+	elem   *list.Element
+
+	// Used by fair queuing
+	key    string
+	weight float64
+	finish float64
+	seq    uint64
+	index  int
+}
+
+func newWaiter() *waiter {
+	return &waiter{result: make(chan error, 1)}
+}
+
+// waitQueue orders the waiting requests
+type waitQueue interface {
+	push(w *waiter)
+	// pop removes the next request to admit
+	pop() *waiter
+	// evict removes a request to make room for incoming, or returns nil
+	// if incoming should be shed instead
+	evict(incoming *waiter) *waiter
+	remove(w *waiter) bool
+	len() int
+}
+
+// dequeQueue implements FIFO, LIFO and AdaptiveLIFO ordering
+type dequeQueue struct {
+	mode     QueueMode
+	capacity int
+	waiters  list.List
+}
+
+func (q *dequeQueue) lifo() bool {
+	switch q.mode {
+	case LIFO:
+		return true
+	case AdaptiveLIFO:
+		return q.waiters.Len()*2 >= q.capacity
+	}
+	return false
+}
+
+func (q *dequeQueue) push(w *waiter) {
+	w.elem = q.waiters.PushBack(w)
+}
+
+func (q *dequeQueue) pop() *waiter {
+	e := q.waiters.Front()
+	if q.lifo() {
+		e = q.waiters.Back()
+	}
+	if e == nil {
+		return nil
+	}
+	return q.take(e)
+}
+
+func (q *dequeQueue) evict(*waiter) *waiter {
+	if !q.lifo() || q.waiters.Len() == 0 {
+		return nil
+	}
+	return q.take(q.waiters.Front())
+}
+
+func (q *dequeQueue) remove(w *waiter) bool {
+	if w.elem == nil {
+		return false
+	}
+	q.take(w.elem)
+	return true
+}
+
+func (q *dequeQueue) take(e *list.Element) *waiter {
+	w := q.waiters.Remove(e).(*waiter)
+	w.elem = nil
+	return w
+}
+
+func (q *dequeQueue) len() int {
+	return q.waiters.Len()
+}
+
+// admission caps the number of requests in flight and queues the rest.
+// The limit can change at runtime, which the adaptive limiter relies on.
+type admission struct {
+	mu       sync.Mutex
+	limit    int
+	inflight int
+	maxQueue int
+	queue    waitQueue
+}
+
+// acquire waits for a slot. It returns errShed if the queue is full and
+// errQueueTimeout if no slot became free within maxWait.
+func (a *admission) acquire(ctx context.Context, w *waiter, maxWait time.Duration) error {
+	a.mu.Lock()
+	if a.inflight < a.limit && a.queue.len() == 0 {
+		a.inflight++
+		a.mu.Unlock()
+		return nil
+	}
+	if a.queue.len() >= a.maxQueue {
+		victim := a.queue.evict(w)
+		if victim == nil {
+			a.mu.Unlock()
+			return errShed
+		}
+		victim.result <- errShed
+	}
+	a.queue.push(w)
+	a.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case err = <-w.result:
+		return err
+	case <-timeout:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	a.mu.Lock()
+	removed := a.queue.remove(w)
+	a.mu.Unlock()
+	if removed {
+		return err
+	}
+	// Admitted or shed while giving up; the outcome is already sent
+	return <-w.result
+}
+
+// release frees a slot and admits waiting requests
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inflight--
+	a.dispatchLocked()
+}
+
+// setLimit changes the number of requests allowed in flight
+func (a *admission) setLimit(limit int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.limit = limit
+	a.dispatchLocked()
+}
+
+func (a *admission) dispatchLocked() {
+	for a.inflight < a.limit && a.queue.len() > 0 {
+		a.inflight++
+		a.queue.pop().result <- nil
+	}
+}
+
+type concurrencyOptions struct {
+	maxWait    time.Duration
+	mode       QueueMode
+	retryAfter time.Duration
+	adaptive   adaptiveOptions
+	classes    map[string]float64
+	classify   Classifier
+	fairKey    KeyFunc
+}
+
+type ConcurrencyOption func(*concurrencyOptions)
+
+// WithQueueTimeout sets how long a request may wait for a slot, 1s by
+// default. Zero waits until the client gives up.
+func WithQueueTimeout(d time.Duration) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.maxWait = d
+	}
+}
+
+// WithQueueMode sets the order waiting requests are admitted in
+func WithQueueMode(mode QueueMode) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.mode = mode
+	}
+}
+
+// WithRetryAfter sets the Retry-After sent with shed responses
+func WithRetryAfter(d time.Duration) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.retryAfter = d
+	}
+}
+
+func newConcurrencyOptions(opts []ConcurrencyOption) *concurrencyOptions {
+	options := &concurrencyOptions{
+		maxWait:    time.Second,
+		mode:       FIFO,
+		retryAfter: time.Second,
+		adaptive: adaptiveOptions{
+			algorithm: AIMD,
+			initial:   20,
+			min:       1,
+			max:       1000,
+			tolerance: 2,
+			window:    time.Second,
+		},
+		classes:  make(map[string]float64),
+		classify: func(*http.Request) string { return "" },
+		fairKey:  KeyByIP,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// ConcurrencyLimit creates a middleware that allows maxInFlight requests
+// at once and up to maxQueue more waiting. Requests that cannot be queued or wait too
+// long are shed with 503 and Retry-After.
+func ConcurrencyLimit(maxInFlight, maxQueue int, opts ...ConcurrencyOption) func(http.Handler) http.Handler {
+	options := newConcurrencyOptions(opts)
+	a := &admission{
+		limit:    maxInFlight,
+		maxQueue: maxQueue,
+		queue:    &dequeQueue{mode: options.mode, capacity: maxQueue},
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := a.acquire(r.Context(), newWaiter(), options.maxWait); err != nil {
+				shed(w, r, err, options.retryAfter)
+				return
+			}
+			defer a.release()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// shed rejects a request that was not admitted
+func shed(w http.ResponseWriter, r *http.Request, err error, retryAfter time.Duration) {
+	if r.Context().Err() != nil {
+		// The client gave up while queued
+		return
+	}
+
+	detail := "The server is overloaded"
+	if errors.Is(err, errQueueTimeout) {
+		detail = "Timed out waiting for capacity"
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(retryAfter), 1)))
+	RenderProblem(w, r, Problem{
+		Status: http.StatusServiceUnavailable,
+		Detail: detail,
+	})
+}
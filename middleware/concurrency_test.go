@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+// blockingHandler holds requests until release is closed
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+}
+
+func TestConcurrencyLimit_Sheds(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handler := ConcurrencyLimit(1, 0, WithRetryAfter(3*time.Second))(blockingHandler(started, release))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	<-started
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertHeader("Retry-After", "3").
+		AssertJSONPath("detail", "The server is overloaded")
+
+	close(release)
+	<-done
+	routertest.Get("/").Do(t, handler).AssertStatus(http.StatusOK)
+}
+
+func TestConcurrencyLimit_QueueTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	handler := ConcurrencyLimit(1, 1, WithQueueTimeout(10*time.Millisecond))(blockingHandler(started, release))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertJSONPath("detail", "Timed out waiting for capacity")
+}
+
+func TestConcurrencyLimit_Queues(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	handler := ConcurrencyLimit(1, 1, WithQueueTimeout(time.Second))(blockingHandler(started, release))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	queued := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		queued <- rec.Code
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if code := <-queued; code != http.StatusOK {
+		t.Fatalf("Expected queued request to be admitted, got %d", code)
+	}
+}
+
+// enqueue starts an acquire and waits until the request is queued
+func enqueue(t *testing.T, a *admission) chan error {
+	t.Helper()
+	result := make(chan error, 1)
+	before := a.queueLen()
+	go func() {
+		result <- a.acquire(context.Background(), newWaiter(), time.Second)
+	}()
+	for a.queueLen() == before {
+		time.Sleep(time.Millisecond)
+	}
+	return result
+}
+
+func (a *admission) queueLen() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.queue.len()
+}
+
+func TestAdmission_LIFO(t *testing.T) {
+	a := &admission{limit: 1, maxQueue: 2, queue: &dequeQueue{mode: LIFO, capacity: 2}}
+	if err := a.acquire(context.Background(), newWaiter(), 0); err != nil {
+		t.Fatalf("Expected first request to be admitted, got %v", err)
+	}
+
+	oldest := enqueue(t, a)
+	older := enqueue(t, a)
+	newest := make(chan error, 1)
+	go func() {
+		newest <- a.acquire(context.Background(), newWaiter(), time.Second)
+	}()
+
+	// The full queue sheds its oldest request to make room
+	if err := <-oldest; err != errShed {
+		t.Fatalf("Expected oldest request to be shed, got %v", err)
+	}
+
+	a.release()
+	if err := <-newest; err != nil {
+		t.Fatalf("Expected newest request to be admitted first, got %v", err)
+	}
+	a.release()
+	if err := <-older; err != nil {
+		t.Fatalf("Expected remaining request to be admitted, got %v", err)
+	}
+}
+
+func TestAdmission_FIFO(t *testing.T) {
+	a := &admission{limit: 1, maxQueue: 2, queue: &dequeQueue{mode: FIFO, capacity: 2}}
+	a.acquire(context.Background(), newWaiter(), 0)
+
+	first := enqueue(t, a)
+	second := enqueue(t, a)
+	if err := a.acquire(context.Background(), newWaiter(), 0); err != errShed {
+		t.Fatalf("Expected new request to be shed when FIFO queue is full, got %v", err)
+	}
+
+	a.release()
+	if err := <-first; err != nil {
+		t.Fatalf("Expected first queued request to be admitted, got %v", err)
+	}
+	select {
+	case <-second:
+		t.Fatal("Expected second request to keep waiting")
+	default:
+	}
+}
+
+func TestConcurrencyLimit_ErrorRenderer(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	renderer := func(w http.ResponseWriter, r *http.Request, p Problem) {
+		http.Error(w, "custom: "+p.Detail, p.Status)
+	}
+	handler := RenderErrors(renderer)(ConcurrencyLimit(1, 0)(blockingHandler(started, release)))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	<-started
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertBody("custom: The server is overloaded\n")
+
+	close(release)
+	<-done
+}
@@ -0,0 +1,57 @@
+// pkg/middleware/contentdigest.go
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"hash"
+)
+
+// ErrContentDigest is returned when a Content-Digest does not match the body
+var ErrContentDigest = errors.New("content digest does not match body")
+
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// ContentDigest returns an RFC 9530 Content-Digest value for body using
+// sha-256
+func ContentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return serializeByteDictionary(map[string][]byte{"sha-256": sum[:]})
+}
+
+// VerifyContentDigest checks an RFC 9530 Content-Digest header against
+// body. Every supported digest present must match, and at least one
+// must be supported; unknown algorithms are ignored.
+func VerifyContentDigest(header string, body []byte) error {
+	members, order, err := parseDictionary(header)
+	if err != nil {
+		return err
+	}
+
+	checked := 0
+	for _, name := range order {
+		newHash, ok := digestAlgorithms[name]
+		if !ok {
+			continue
+		}
+		digest, ok := members[name].Item.Value.([]byte)
+		if !ok || members[name].IsList {
+			return errStructuredField
+		}
+		h := newHash()
+		h.Write(body)
+		if subtle.ConstantTimeCompare(h.Sum(nil), digest) != 1 {
+			return ErrContentDigest
+		}
+		checked++
+	}
+	if checked == 0 {
+		return errors.New("no supported content digest algorithm")
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// pkg/middleware/cors.go
+package middleware
+
+/**
+ex usage:
+// CORS runs before Audit so preflights are answered without being logged
+router.Use(middleware.CORS(
+	middleware.WithAllowedOrigins("https://app.example.com", "https://*.example.com"),
+	middleware.WithAllowedMethods(http.MethodGet, http.MethodPost, http.MethodDelete),
+	middleware.WithAllowedHeaders("Authorization", "Content-Type"),
+	middleware.WithExposedHeaders("X-Request-ID"),
+	middleware.WithAllowCredentials(),
+	middleware.WithMaxAge(10*time.Minute),
+))
+router.Use(middleware.Audit(...))
+*/
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type corsOptions struct {
+	allowAll         bool
+	origins          []string
+	wildcards        []string
+	patterns         []*regexp.Regexp
+	methods          []string
+	headers          []string
+	exposed          []string
+	credentials      bool
+	maxAge           time.Duration
+	passthrough      bool
+	reflectedHeaders bool
+}
+
+type CORSOption func(*corsOptions)
+
+// WithAllowedOrigins sets the allowed origins. An origin may be exact
+// ("https://app.example.com"), a wildcard subdomain
+// ("https://*.example.com") or "*" for any origin.
+func WithAllowedOrigins(origins ...string) CORSOption {
+	return func(o *corsOptions) {
+		for _, origin := range origins {
+			origin = strings.ToLower(origin)
+			switch {
+			case origin == "*":
+				o.allowAll = true
+			case strings.Contains(origin, "://*."):
+				scheme, host, _ := strings.Cut(origin, "://*")
+				o.wildcards = append(o.wildcards, scheme+"://|"+host)
+			default:
+				o.origins = append(o.origins, origin)
+			}
+		}
+	}
+}
+
+// WithAllowedOriginPatterns allows origins matching regular expressions.
+// Each expression must match the whole origin, so
+// `https://.*\.example\.com` does not allow
+// https://evil.example.com.attacker.net. It panics if an expression does
+// not compile.
+func WithAllowedOriginPatterns(exprs ...string) CORSOption {
+	return func(o *corsOptions) {
+		for _, expr := range exprs {
+			o.patterns = append(o.patterns, regexp.MustCompile(`^(?:`+expr+`)$`))
+		}
+	}
+}
+
+// WithAllowedMethods sets the methods allowed in preflight requests,
+// GET, HEAD and POST by default
+func WithAllowedMethods(methods ...string) CORSOption {
+	return func(o *corsOptions) {
+		o.methods = methods
+	}
+}
+
+// WithAllowedHeaders sets the request headers allowed in preflight
+// requests. By default the requested headers are allowed.
+func WithAllowedHeaders(headers ...string) CORSOption {
+	return func(o *corsOptions) {
+		o.headers = headers
+		o.reflectedHeaders = false
+	}
+}
+
+// WithExposedHeaders sets the response headers scripts may read
+func WithExposedHeaders(headers ...string) CORSOption {
+	return func(o *corsOptions) {
+		o.exposed = headers
+	}
+}
+
+// WithAllowCredentials allows cookies and authorization headers. It
+// cannot be combined with allowing any origin, which would let every site
+// make authenticated requests.
+func WithAllowCredentials() CORSOption {
+	return func(o *corsOptions) {
+		o.credentials = true
+	}
+}
+
+// WithMaxAge sets how long browsers may cache preflight results
+func WithMaxAge(d time.Duration) CORSOption {
+	return func(o *corsOptions) {
+		o.maxAge = d
+	}
+}
+
+// WithPreflightPassthrough passes preflight requests on to the next
+// handler after the CORS headers have been set
+func WithPreflightPassthrough() CORSOption {
+	return func(o *corsOptions) {
+		o.passthrough = true
+	}
+}
+
+func (o *corsOptions) originAllowed(origin string) bool {
+	if o.allowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if slices.Contains(o.origins, origin) {
+		return true
+	}
+	for _, wildcard := range o.wildcards {
+		scheme, suffix, _ := strings.Cut(wildcard, "|")
+		if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) &&
+			len(origin) > len(scheme)+len(suffix) {
+			return true
+		}
+	}
+	for _, pattern := range o.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *corsOptions) headersAllowed(requested []string) bool {
+	if o.reflectedHeaders {
+		return true
+	}
+	for _, header := range requested {
+		if !slices.ContainsFunc(o.headers, func(allowed string) bool {
+			return strings.EqualFold(allowed, header)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// CORS creates a middleware implementing cross-origin resource sharing.
+// Preflight requests are answered with 204 and do not reach later
+// middleware or handlers unless WithPreflightPassthrough is set; register
+// CORS before Audit to keep preflights out of the audit log.
+func CORS(opts ...CORSOption) func(http.Handler) http.Handler {
+	options := &corsOptions{
+		methods:          []string{http.MethodGet, http.MethodHead, http.MethodPost},
+		reflectedHeaders: true,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.allowAll && options.credentials {
+		panic("middleware: CORS cannot allow credentials from any origin")
+	}
+	anyOrigin := options.allowAll
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if !anyOrigin {
+				header.Add("Vary", "Origin")
+			}
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if origin == "" || !options.originAllowed(origin) {
+				if preflight && !options.passthrough {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if options.credentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if len(options.exposed) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(options.exposed, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			requested := splitHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+			if slices.Contains(options.methods, method) && options.headersAllowed(requested) {
+				header.Set("Access-Control-Allow-Methods", strings.Join(options.methods, ", "))
+				if len(requested) > 0 {
+					if options.reflectedHeaders {
+						header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+					} else {
+						header.Set("Access-Control-Allow-Headers", strings.Join(options.headers, ", "))
+					}
+				}
+				if options.maxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(int(options.maxAge.Seconds())))
+				}
+			} else {
+				// Without the allow headers the browser fails the preflight
+				header.Del("Access-Control-Allow-Origin")
+				header.Del("Access-Control-Allow-Credentials")
+			}
+
+			if options.passthrough {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// splitHeaderList splits a comma separated header value
+func splitHeaderList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, strings.ToLower(item))
+		}
+	}
+	return items
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func corsHandler(reached *bool, opts ...CORSOption) http.Handler {
+	return CORS(opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*reached = true
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestCORS_Origins(t *testing.T) {
+	var reached bool
+	handler := corsHandler(&reached,
+		WithAllowedOrigins("https://app.example.com", "https://*.example.org"),
+		WithAllowedOriginPatterns(`^https://pr-\d+\.preview\.dev$`),
+		WithExposedHeaders("X-Request-ID"),
+	)
+
+	for _, origin := range []string{"https://app.example.com", "https://a.b.example.org", "https://pr-42.preview.dev"} {
+		routertest.Get("/").Header("Origin", origin).Do(t, handler).
+			AssertHeader("Access-Control-Allow-Origin", origin).
+			AssertHeader("Access-Control-Expose-Headers", "X-Request-ID").
+			AssertHeader("Vary", "Origin")
+	}
+
+	for _, origin := range []string{"https://example.org", "http://app.example.com", "https://pr-x.preview.dev"} {
+		routertest.Get("/").Header("Origin", origin).Do(t, handler).
+			AssertStatus(http.StatusOK).
+			AssertHeaderAbsent("Access-Control-Allow-Origin").
+			AssertHeader("Vary", "Origin")
+	}
+}
+
+func TestCORS_AnyOrigin(t *testing.T) {
+	var reached bool
+	routertest.Get("/").Header("Origin", "https://x.test").
+		Do(t, corsHandler(&reached, WithAllowedOrigins("*"))).
+		AssertHeader("Access-Control-Allow-Origin", "*").
+		AssertHeaderAbsent("Vary")
+
+}
+
+func TestCORS_AnyOriginWithCredentialsPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected credentials from any origin to panic")
+		}
+	}()
+	CORS(WithAllowedOrigins("*"), WithAllowCredentials())
+}
+
+func TestCORS_PatternsMatchWholeOrigin(t *testing.T) {
+	var reached bool
+	handler := corsHandler(&reached, WithAllowedOriginPatterns(`https://[a-z]+\.example\.com`, `https://a\.test|https://b\.test`))
+
+	for _, origin := range []string{"https://app.example.com", "https://a.test", "https://b.test"} {
+		routertest.Get("/").Header("Origin", origin).Do(t, handler).
+			AssertHeader("Access-Control-Allow-Origin", origin)
+	}
+	for _, origin := range []string{
+		"https://evil.example.com.attacker.net",
+		"https://attacker.net/https://app.example.com",
+		"https://a.test.attacker.net",
+		"http://x.b.test",
+	} {
+		routertest.Get("/").Header("Origin", origin).Do(t, handler).
+			AssertHeaderAbsent("Access-Control-Allow-Origin")
+	}
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	var reached bool
+	handler := corsHandler(&reached,
+		WithAllowedOrigins("https://app.example.com"),
+		WithAllowedMethods(http.MethodGet, http.MethodPut),
+		WithAllowedHeaders("Authorization", "Content-Type"),
+		WithMaxAge(10*time.Minute),
+	)
+
+	resp := routertest.NewRequest(http.MethodOptions, "/items").
+		Header("Origin", "https://app.example.com").
+		Header("Access-Control-Request-Method", "PUT").
+		Header("Access-Control-Request-Headers", "content-type, authorization").
+		Do(t, handler).
+		AssertStatus(http.StatusNoContent).
+		AssertHeader("Access-Control-Allow-Origin", "https://app.example.com").
+		AssertHeader("Access-Control-Allow-Methods", "GET, PUT").
+		AssertHeader("Access-Control-Allow-Headers", "Authorization, Content-Type").
+		AssertHeader("Access-Control-Max-Age", "600")
+	if vary := resp.Result.Header.Values("Vary"); len(vary) != 3 {
+		t.Fatalf("Expected Vary on origin and request headers, got %v", vary)
+	}
+	if reached {
+		t.Fatal("Expected preflight not to reach the handler")
+	}
+
+	// A disallowed method fails the preflight
+	routertest.NewRequest(http.MethodOptions, "/items").
+		Header("Origin", "https://app.example.com").
+		Header("Access-Control-Request-Method", "DELETE").
+		Do(t, handler).
+		AssertStatus(http.StatusNoContent).
+		AssertHeaderAbsent("Access-Control-Allow-Origin")
+
+	// A disallowed header fails the preflight
+	routertest.NewRequest(http.MethodOptions, "/items").
+		Header("Origin", "https://app.example.com").
+		Header("Access-Control-Request-Method", "GET").
+		Header("Access-Control-Request-Headers", "x-secret").
+		Do(t, handler).
+		AssertHeaderAbsent("Access-Control-Allow-Origin")
+
+	if reached {
+		t.Fatal("Expected failed preflights not to reach the handler")
+	}
+}
+
+func TestCORS_PreflightPassthrough(t *testing.T) {
+	var reached bool
+	handler := corsHandler(&reached, WithAllowedOrigins("*"), WithPreflightPassthrough())
+
+	routertest.NewRequest(http.MethodOptions, "/").
+		Header("Origin", "https://x.test").
+		Header("Access-Control-Request-Method", "GET").
+		Header("Access-Control-Request-Headers", "X-Custom").
+		Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Access-Control-Allow-Headers", "x-custom")
+	if !reached {
+		t.Fatal("Expected preflight to reach the handler")
+	}
+}
+
+func TestCORS_PreflightSkipsAudit(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	var reached bool
+	handler := CORS(WithAllowedOrigins("*"))(
+		Audit(WithLogger(recorder.Logger()))(corsHandler(&reached)),
+	)
+
+	routertest.NewRequest(http.MethodOptions, "/").
+		Header("Origin", "https://x.test").
+		Header("Access-Control-Request-Method", "GET").
+		Do(t, handler)
+	if len(recorder.Records()) != 0 {
+		t.Fatalf("Expected preflight not to be audited, got %v", recorder.Records())
+	}
+
+	routertest.Get("/").Header("Origin", "https://x.test").Do(t, handler)
+	if len(recorder.Records()) != 1 {
+		t.Fatalf("Expected request to be audited, got %v", recorder.Records())
+	}
+}
@@ -0,0 +1,114 @@
+// pkg/middleware/cspreport.go
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const maxCSPReportSize = 64 << 10
+
+// legacyCSPReport is the body of a report-uri report
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// cspViolation is a report in the Reporting API format, which the legacy
+// format is converted to
+type cspViolation struct {
+	DocumentURL        string `json:"documentURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	BlockedURL         string `json:"blockedURL"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+	Disposition        string `json:"disposition"`
+}
+
+func parseCSPReports(contentType string, body []byte) ([]cspViolation, error) {
+	if strings.HasPrefix(contentType, "application/reports+json") {
+		var reports []struct {
+			Type string       `json:"type"`
+			Body cspViolation `json:"body"`
+		}
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+		var violations []cspViolation
+		for _, report := range reports {
+			if report.Type == "csp-violation" {
+				violations = append(violations, report.Body)
+			}
+		}
+		return violations, nil
+	}
+
+	var legacy legacyCSPReport
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+	directive := legacy.Report.EffectiveDirective
+	if directive == "" {
+		directive = legacy.Report.ViolatedDirective
+	}
+	return []cspViolation{{
+		DocumentURL:        legacy.Report.DocumentURI,
+		EffectiveDirective: directive,
+		BlockedURL:         legacy.Report.BlockedURI,
+		SourceFile:         legacy.Report.SourceFile,
+		LineNumber:         legacy.Report.LineNumber,
+		Disposition:        legacy.Report.Disposition,
+	}}, nil
+}
+
+// CSPReportHandler creates a handler that collects Content-Security-Policy
+// violation reports, in the report-uri or Reporting API format, and logs
+// each one at warn level
+func CSPReportHandler(logger *slog.Logger) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportSize))
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		violations, err := parseCSPReports(r.Header.Get("Content-Type"), body)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		for _, v := range violations {
+			logger.WarnContext(r.Context(), "CSP violation",
+				"document", v.DocumentURL,
+				"directive", v.EffectiveDirective,
+				"blocked", v.BlockedURL,
+				"source", v.SourceFile,
+				"line", v.LineNumber,
+				"disposition", v.Disposition,
+				"userAgent", r.UserAgent(),
+			)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,322 @@
+// pkg/middleware/csrf.go
+package middleware
+
+/**
+ex usage:
+router.Use(middleware.Audit(middleware.WithLogger(logger)))
+router.Use(middleware.CSRF(
+	middleware.WithCSRFSecret(secret),
+	middleware.WithCSRFTrustedOrigins("https://admin.example.com"),
+	middleware.WithCSRFExempt("POST /webhooks/"),
+))
+
+// In templates: <input type="hidden" name="csrf_token" value="{{ .CSRF }}">
+// with CSRF: middleware.CSRFToken(r.Context()), or send it from scripts
+// in the X-CSRF-Token header
+*/
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// CSRFTokenKey is the context key holding the token for the current request
+const CSRFTokenKey ContextKey = "csrfToken"
+
+const csrfTokenLength = 32
+
+type csrfOptions struct {
+	secret         []byte
+	cookieName     string
+	headerName     string
+	fieldName      string
+	secureCookie   bool
+	trustedOrigins []string
+	exempt         *http.ServeMux
+	hasExempt      bool
+}
+
+type CSRFOption func(*csrfOptions)
+
+// WithCSRFSecret sets the key used to sign token cookies. Without it a
+// random key is generated, so tokens do not survive restarts and are not
+// shared between instances.
+func WithCSRFSecret(secret []byte) CSRFOption {
+	return func(o *csrfOptions) {
+		o.secret = secret
+	}
+}
+
+// WithCSRFCookie sets the name of the token cookie, "_csrf" by default
+func WithCSRFCookie(name string) CSRFOption {
+	return func(o *csrfOptions) {
+		o.cookieName = name
+	}
+}
+
+// WithCSRFHeader sets the header scripts send the token in,
+// "X-CSRF-Token" by default
+func WithCSRFHeader(name string) CSRFOption {
+	return func(o *csrfOptions) {
+		o.headerName = name
+	}
+}
+
+// WithCSRFField sets the form field the token is read from,
+// "csrf_token" by default
+func WithCSRFField(name string) CSRFOption {
+	return func(o *csrfOptions) {
+		o.fieldName = name
+	}
+}
+
+// WithCSRFSecureCookie always marks the token cookie Secure, for servers
+// behind a TLS terminating proxy. Over direct TLS it is set anyway.
+func WithCSRFSecureCookie() CSRFOption {
+	return func(o *csrfOptions) {
+		o.secureCookie = true
+	}
+}
+
+// WithCSRFTrustedOrigins allows unsafe requests from other origins,
+// e.g. "https://admin.example.com"
+func WithCSRFTrustedOrigins(origins ...string) CSRFOption {
+	return func(o *csrfOptions) {
+		for _, origin := range origins {
+			o.trustedOrigins = append(o.trustedOrigins, strings.ToLower(origin))
+		}
+	}
+}
+
+// WithCSRFExempt skips protection for requests matching http.ServeMux
+// patterns, e.g. webhooks authenticated by signature
+func WithCSRFExempt(patterns ...string) CSRFOption {
+	return func(o *csrfOptions) {
+		for _, pattern := range patterns {
+			o.exempt.Handle(pattern, http.NotFoundHandler())
+		}
+		o.hasExempt = true
+	}
+}
+
+// CSRFToken returns the token to embed in forms for the current request.
+// A new masked token is returned for every request so it cannot be
+// recovered through compression side channels.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(CSRFTokenKey).(string)
+	return token
+}
+
+// CSRF creates a middleware protecting cookie authenticated routes against
+// cross-site request forgery with signed double-submit tokens. Unsafe
+// requests must come from the same origin, judged by Sec-Fetch-Site,
+// Origin and Referer, and carry the token from the cookie in a header or
+// form field. Failures are rendered as 403 problems and recorded with
+// AuditEvent.
+func CSRF(opts ...CSRFOption) func(http.Handler) http.Handler {
+	options := &csrfOptions{
+		cookieName: "_csrf",
+		headerName: "X-CSRF-Token",
+		fieldName:  "csrf_token",
+		exempt:     http.NewServeMux(),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.secret == nil {
+		options.secret = make([]byte, 32)
+		if _, err := rand.Read(options.secret); err != nil {
+			panic("csrf: failed to generate secret: " + err.Error())
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if options.hasExempt {
+				if _, pattern := options.exempt.Handler(r); pattern != "" {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			// Every response varies by cookie since it may set a new token
+			w.Header().Add("Vary", "Cookie")
+
+			token, ok := options.readCookie(r)
+			if !ok {
+				var err error
+				if token, err = randomBytes(csrfTokenLength); err != nil {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				options.writeCookie(w, r, token)
+			}
+
+			if !isSafeMethod(r.Method) {
+				if reason := options.check(r, token, ok); reason != "" {
+					AuditEvent(r, "CSRF check failed", "reason", reason)
+					RenderProblem(w, r, Problem{
+						Status: http.StatusForbidden,
+						Detail: "CSRF check failed: " + reason,
+					})
+					return
+				}
+			}
+
+			masked, err := maskToken(token)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			ctx := context.WithValue(r.Context(), CSRFTokenKey, masked)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// check returns why an unsafe request fails, or "" if it passes
+func (o *csrfOptions) check(r *http.Request, token []byte, hadCookie bool) string {
+	if site := r.Header.Get("Sec-Fetch-Site"); site == "cross-site" || site == "same-site" {
+		if !o.trustedOrigin(r.Header.Get("Origin")) {
+			return "cross-site request"
+		}
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !sameOrigin(r, origin) && !o.trustedOrigin(origin) {
+			return "origin not allowed"
+		}
+	} else if r.TLS != nil {
+		// Browsers without Origin still send Referer over HTTPS
+		referer, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil || referer.Host == "" {
+			return "missing referer"
+		}
+		origin := referer.Scheme + "://" + referer.Host
+		if !sameOrigin(r, origin) && !o.trustedOrigin(origin) {
+			return "referer not allowed"
+		}
+	}
+
+	if !hadCookie {
+		return "missing token cookie"
+	}
+
+	submitted := r.Header.Get(o.headerName)
+	if submitted == "" {
+		submitted = r.PostFormValue(o.fieldName)
+	}
+	if submitted == "" {
+		return "missing token"
+	}
+	unmasked, ok := unmaskToken(submitted)
+	if !ok || subtle.ConstantTimeCompare(unmasked, token) != 1 {
+		return "invalid token"
+	}
+	return ""
+}
+
+func (o *csrfOptions) trustedOrigin(origin string) bool {
+	return origin != "" && slices.Contains(o.trustedOrigins, strings.ToLower(origin))
+}
+
+// readCookie returns the token from a cookie with a valid signature
+func (o *csrfOptions) readCookie(r *http.Request) ([]byte, bool) {
+	cookie, err := r.Cookie(o.cookieName)
+	if err != nil {
+		return nil, false
+	}
+	value, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return nil, false
+	}
+	token, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(token) != csrfTokenLength {
+		return nil, false
+	}
+	expected := o.sign(token)
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, expected) {
+		return nil, false
+	}
+	return token, true
+}
+
+func (o *csrfOptions) writeCookie(w http.ResponseWriter, r *http.Request, token []byte) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     o.cookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(token) + "." + base64.RawURLEncoding.EncodeToString(o.sign(token)),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   o.secureCookie || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (o *csrfOptions) sign(token []byte) []byte {
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write(token)
+	return mac.Sum(nil)
+}
+
+// sameOrigin reports whether origin matches the host the request was sent to
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return strings.EqualFold(u.Host, r.Host) && (u.Scheme == scheme || r.TLS == nil)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// maskToken XORs token with a random pad and prepends the pad
+func maskToken(token []byte) (string, error) {
+	pad, err := randomBytes(len(token))
+	if err != nil {
+		return "", err
+	}
+	masked := make([]byte, 2*len(token))
+	copy(masked, pad)
+	for i := range token {
+		masked[len(token)+i] = token[i] ^ pad[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked), nil
+}
+
+func unmaskToken(masked string) ([]byte, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(masked)
+	if err != nil || len(data) != 2*csrfTokenLength {
+		return nil, false
+	}
+	token := make([]byte, csrfTokenLength)
+	for i := range token {
+		token[i] = data[i] ^ data[csrfTokenLength+i]
+	}
+	return token, true
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
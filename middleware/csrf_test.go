@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+// csrfSession fetches a page and returns the token cookie and form token
+func csrfSession(t *testing.T, handler http.Handler) (*http.Cookie, string) {
+	t.Helper()
+	resp := routertest.Get("/form").Do(t, handler).AssertStatus(http.StatusOK)
+	cookies := resp.Result.Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly token cookie, got %v", cookies)
+	}
+	return cookies[0], resp.Body()
+}
+
+func csrfTestHandler(opts ...CSRFOption) http.Handler {
+	return CSRF(append([]CSRFOption{WithCSRFSecret([]byte("test-secret"))}, opts...)...)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(CSRFToken(r.Context())))
+		}),
+	)
+}
+
+func TestCSRF_Token(t *testing.T) {
+	handler := csrfTestHandler()
+	cookie, token := csrfSession(t, handler)
+
+	routertest.Post("/form").
+		Header("Cookie", cookie.String()).
+		Header("X-CSRF-Token", token).
+		Header("Origin", "http://example.com").
+		Do(t, handler).
+		AssertStatus(http.StatusOK)
+
+	// A form field works as well, and each response masks the token anew
+	next := routertest.Post("/form").
+		Header("Cookie", cookie.String()).
+		Header("Content-Type", "application/x-www-form-urlencoded").
+		Body(url.Values{"csrf_token": {token}}.Encode()).
+		Do(t, handler).
+		AssertStatus(http.StatusOK)
+	if next.Body() == token {
+		t.Fatal("Expected a freshly masked token")
+	}
+}
+
+func TestCSRF_Failures(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(csrfTestHandler())
+	cookie, token := csrfSession(t, handler)
+	otherCookie, _ := csrfSession(t, handler)
+
+	cases := map[string]*routertest.RequestBuilder{
+		"missing token cookie": routertest.Post("/form").Header("X-CSRF-Token", token),
+		"missing token":        routertest.Post("/form").Header("Cookie", cookie.String()),
+		"invalid token": routertest.Post("/form").
+			Header("Cookie", otherCookie.String()).
+			Header("X-CSRF-Token", token),
+		"cross-site request": routertest.Post("/form").
+			Header("Cookie", cookie.String()).
+			Header("X-CSRF-Token", token).
+			Header("Sec-Fetch-Site", "cross-site"),
+		"origin not allowed": routertest.Post("/form").
+			Header("Cookie", cookie.String()).
+			Header("X-CSRF-Token", token).
+			Header("Origin", "https://evil.test"),
+	}
+
+	for reason, req := range cases {
+		req.Do(t, handler).
+			AssertStatus(http.StatusForbidden).
+			AssertHeader("Content-Type", "application/problem+json").
+			AssertJSONPath("detail", "CSRF check failed: "+reason)
+	}
+
+	failures := 0
+	for _, record := range recorder.Records() {
+		if record.Message == "CSRF check failed" {
+			failures++
+		}
+	}
+	if failures != len(cases) {
+		t.Fatalf("Expected %d audit events, got %d", len(cases), failures)
+	}
+}
+
+func TestCSRF_TamperedCookie(t *testing.T) {
+	handler := csrfTestHandler()
+	cookie, token := csrfSession(t, handler)
+
+	value, _, _ := strings.Cut(cookie.Value, ".")
+	cookie.Value = value + ".forged"
+	routertest.Post("/form").
+		Header("Cookie", cookie.String()).
+		Header("X-CSRF-Token", token).
+		Do(t, handler).
+		AssertStatus(http.StatusForbidden).
+		AssertJSONPath("detail", "CSRF check failed: missing token cookie")
+}
+
+func TestCSRF_TrustedOriginAndExempt(t *testing.T) {
+	handler := csrfTestHandler(
+		WithCSRFTrustedOrigins("https://admin.example.com"),
+		WithCSRFExempt("POST /webhooks/"),
+	)
+	cookie, token := csrfSession(t, handler)
+
+	routertest.Post("/form").
+		Header("Cookie", cookie.String()).
+		Header("X-CSRF-Token", token).
+		Header("Origin", "https://admin.example.com").
+		Header("Sec-Fetch-Site", "same-site").
+		Do(t, handler).
+		AssertStatus(http.StatusOK)
+
+	routertest.Post("/webhooks/github").Do(t, handler).AssertStatus(http.StatusOK)
+}
+
+func TestCSRF_ErrorRenderer(t *testing.T) {
+	renderer := func(w http.ResponseWriter, r *http.Request, p Problem) {
+		http.Error(w, "custom: "+p.Detail, p.Status)
+	}
+	handler := RenderErrors(renderer)(csrfTestHandler())
+
+	routertest.Post("/form").Do(t, handler).
+		AssertStatus(http.StatusForbidden).
+		AssertBody("custom: CSRF check failed: missing token cookie\n")
+}
@@ -0,0 +1,299 @@
+// pkg/middleware/digest.go
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestNonceTTL is how long a Digest nonce is accepted
+const digestNonceTTL = 5 * time.Minute
+
+// DigestSecrets provides the HA1 value, H(user:realm:password), for RFC
+// 7616 Digest authentication with the given algorithm
+type DigestSecrets interface {
+	DigestHA1(user, realm, algorithm string) (string, bool)
+}
+
+// DigestPasswords holds plain text passwords by user and supports every
+// algorithm
+type DigestPasswords map[string]string
+
+// DigestHA1 implements DigestSecrets
+func (p DigestPasswords) DigestHA1(user, realm, algorithm string) (string, bool) {
+	password, ok := p[user]
+	if !ok {
+		return "", false
+	}
+	return digestHash(algorithm, user+":"+realm+":"+password), true
+}
+
+// Htdigest reads HA1 values from an htdigest file, which only supports
+// MD5. The file is reloaded when it changes.
+type Htdigest struct {
+	mu      sync.Mutex
+	file    watchedFile
+	entries map[string]string
+}
+
+// OpenHtdigest loads the htdigest file at path
+func OpenHtdigest(path string) (*Htdigest, error) {
+	h := &Htdigest{file: watchedFile{path: path, interval: time.Second}}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Htdigest) load() error {
+	data, err := os.ReadFile(h.file.path)
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.Split(text, ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("%s: line %d: expected user:realm:hash", h.file.path, line)
+		}
+		entries[parts[0]+":"+parts[1]] = parts[2]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	h.entries = entries
+	h.file.update()
+	return nil
+}
+
+// DigestHA1 implements DigestSecrets
+func (h *Htdigest) DigestHA1(user, realm, algorithm string) (string, bool) {
+	if algorithm != "MD5" {
+		return "", false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file.stale() {
+		_ = h.load()
+	}
+	ha1, ok := h.entries[user+":"+realm]
+	return ha1, ok
+}
+
+func digestHash(algorithm, data string) string {
+	var h hash.Hash
+	if algorithm == "SHA-256" {
+		h = sha256.New()
+	} else {
+		h = md5.New()
+	}
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digestAuth issues and verifies Digest challenges. Nonces are a
+// timestamp signed with a per-process key; the only state kept is the
+// highest nonce count seen for each nonce in use, so a captured request
+// cannot be replayed.
+type digestAuth struct {
+	secrets DigestSecrets
+	realm   string
+	key     []byte
+	now     func() time.Time
+
+	mu     sync.Mutex
+	counts map[string]digestCount
+	swept  time.Time
+}
+
+type digestCount struct {
+	nc      uint64
+	expires time.Time
+}
+
+var errDigestStale = errors.New("stale nonce")
+
+func newDigestAuth(secrets DigestSecrets, realm string) *digestAuth {
+	key, err := randomBytes(32)
+	if err != nil {
+		panic("digest: " + err.Error())
+	}
+	return &digestAuth{secrets: secrets, realm: realm, key: key, now: time.Now, counts: make(map[string]digestCount)}
+}
+
+func (d *digestAuth) nonce() string {
+	buf := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(buf, uint64(d.now().UnixNano()))
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(buf)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(buf))
+}
+
+func (d *digestAuth) checkNonce(nonce string) error {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) != 8+sha256.Size {
+		return errors.New("malformed nonce")
+	}
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(raw[:8])
+	if !hmac.Equal(mac.Sum(nil), raw[8:]) {
+		return errors.New("invalid nonce")
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(raw[:8])))
+	if d.now().Sub(issued) > digestNonceTTL {
+		return errDigestStale
+	}
+	return nil
+}
+
+// useCount records nc for nonce. Each request must use a higher count
+// than the last accepted one with the same nonce.
+func (d *digestAuth) useCount(nonce, nc string) error {
+	count, err := strconv.ParseUint(nc, 16, 32)
+	if err != nil || len(nc) != 8 {
+		return errors.New("malformed nonce count")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if now.Sub(d.swept) >= digestNonceTTL {
+		for n, c := range d.counts {
+			if !now.Before(c.expires) {
+				delete(d.counts, n)
+			}
+		}
+		d.swept = now
+	}
+
+	last, ok := d.counts[nonce]
+	if ok && count <= last.nc {
+		return errors.New("nonce count reused")
+	}
+	if !ok {
+		// checkNonce rejects the nonce by then, so the entry can go
+		last.expires = now.Add(digestNonceTTL)
+	}
+	d.counts[nonce] = digestCount{nc: count, expires: last.expires}
+	return nil
+}
+
+// challenges returns WWW-Authenticate values, SHA-256 preferred
+func (d *digestAuth) challenges(stale bool) []string {
+	nonce := d.nonce()
+	var result []string
+	for _, algorithm := range []string{"SHA-256", "MD5"} {
+		challenge := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=%s, nonce=%q`, d.realm, algorithm, nonce)
+		if stale {
+			challenge += ", stale=true"
+		}
+		result = append(result, challenge)
+	}
+	return result
+}
+
+// verify checks the Authorization parameters of a Digest request and
+// returns the username
+func (d *digestAuth) verify(r *http.Request, params map[string]string) (string, error) {
+	user := params["username"]
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+
+	switch {
+	case user == "":
+		return "", errors.New("missing username")
+	case params["realm"] != d.realm:
+		return user, errors.New("wrong realm")
+	case algorithm != "MD5" && algorithm != "SHA-256":
+		return user, errors.New("unsupported algorithm")
+	case params["qop"] != "auth":
+		return user, errors.New("unsupported qop")
+	case params["uri"] != r.URL.RequestURI():
+		return user, errors.New("uri mismatch")
+	case params["nc"] == "" || params["cnonce"] == "" || params["response"] == "":
+		return user, errors.New("missing parameters")
+	}
+	if err := d.checkNonce(params["nonce"]); err != nil {
+		return user, err
+	}
+
+	ha1, ok := d.secrets.DigestHA1(user, d.realm, algorithm)
+	if !ok {
+		return user, errors.New("unknown user")
+	}
+	ha2 := digestHash(algorithm, r.Method+":"+params["uri"])
+	expected := digestHash(algorithm, strings.Join([]string{
+		ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2,
+	}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return user, errors.New("wrong password")
+	}
+	if err := d.useCount(params["nonce"], params["nc"]); err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// parseAuthParams parses comma separated key=value pairs with optionally
+// quoted values, as used by Digest credentials
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[key] = value.String()
+	}
+}
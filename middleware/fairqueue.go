@@ -0,0 +1,274 @@
+// pkg/middleware/fairqueue.go
+package middleware
+
+/**
+ex usage:
+router.Use(middleware.FairConcurrencyLimit(50, 500,
+	middleware.WithPriorityClasses(
+		middleware.PriorityClass{Name: "health", Weight: 100},
+		middleware.PriorityClass{Name: "paid", Weight: 10},
+		middleware.PriorityClass{Name: "free", Weight: 1},
+	),
+	// The plan comes from the authenticated principal, so register the
+	// authentication middleware first. Headers are client controlled.
+	middleware.WithClassifier(middleware.ClassifyFirst(
+		middleware.ClassifyByRoute(map[string]string{"GET /health": "health"}),
+		middleware.ClassifyByPrincipal(func(p *middleware.Principal) string {
+			if p.HasRole("paid") {
+				return "paid"
+			}
+			return "free"
+		}),
+	)),
+	middleware.WithFairKey(middleware.KeyFirst(middleware.KeyByUser, middleware.KeyByIP)),
+))
+*/
+
+import (
+	"container/heap"
+	"net/http"
+)
+
+// DefaultPriorityClass is used for requests that match no class
+const DefaultPriorityClass = "default"
+
+// PriorityClass gives a share of capacity to a class of requests. A client
+// in a class with weight 10 is admitted ten times as often as a client in
+// a class with weight 1 when both are queued.
+type PriorityClass struct {
+	Name   string
+	Weight float64
+}
+
+// Classifier returns the priority class name for a request, or "" for the
+// default class
+type Classifier func(r *http.Request) string
+
+// ClassifyByHeader uses the value of a header as the class name. Clients
+// can set any header, so only use it behind a proxy that sets the header
+// itself.
+func ClassifyByHeader(name string) Classifier {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// ClassifyByPrincipal maps the authenticated principal to a class name.
+// Unauthenticated requests get the default class.
+func ClassifyByPrincipal(class func(p *Principal) string) Classifier {
+	return func(r *http.Request) string {
+		if p, ok := PrincipalFrom(r.Context()); ok {
+			return class(p)
+		}
+		return ""
+	}
+}
+
+// ClassifyByRoute maps http.ServeMux patterns to class names
+func ClassifyByRoute(classes map[string]string) Classifier {
+	mux := http.NewServeMux()
+	for pattern := range classes {
+		mux.Handle(pattern, http.NotFoundHandler())
+	}
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return classes[pattern]
+	}
+}
+
+// ClassifyFirst uses the first classifier that returns a class
+func ClassifyFirst(classifiers ...Classifier) Classifier {
+	return func(r *http.Request) string {
+		for _, classify := range classifiers {
+			if class := classify(r); class != "" {
+				return class
+			}
+		}
+		return ""
+	}
+}
+
+// WithPriorityClasses sets the classes requests are sorted into. Requests
+// in unknown classes get weight 1.
+func WithPriorityClasses(classes ...PriorityClass) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		for _, class := range classes {
+			o.classes[class.Name] = class.Weight
+		}
+	}
+}
+
+// WithClassifier sets how requests are sorted into priority classes
+func WithClassifier(classify Classifier) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.classify = classify
+	}
+}
+
+// WithFairKey sets how clients are told apart for fair queuing, by client
+// IP by default
+func WithFairKey(fn KeyFunc) ConcurrencyOption {
+	return func(o *concurrencyOptions) {
+		o.fairKey = fn
+	}
+}
+
+// FairConcurrencyLimit creates a middleware like ConcurrencyLimit that
+// admits queued requests by weighted fair queuing: each client gets a share
+// of the slots in proportion to its class weight, so a burst from one
+// client cannot monopolize them. When the queue is full, the request
+// furthest back in line is shed, which is the bursting client's.
+func FairConcurrencyLimit(maxInFlight, maxQueue int, opts ...ConcurrencyOption) func(http.Handler) http.Handler {
+	options := newConcurrencyOptions(opts)
+	a := &admission{
+		limit:    maxInFlight,
+		maxQueue: maxQueue,
+		queue:    newFairQueue(),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := options.classify(r)
+			if class == "" {
+				class = DefaultPriorityClass
+			}
+			weight, ok := options.classes[class]
+			if !ok || weight <= 0 {
+				weight = 1
+			}
+
+			waiter := newWaiter()
+			waiter.key = class + "|" + options.fairKey(r)
+			waiter.weight = weight
+
+			if err := a.acquire(r.Context(), waiter, options.maxWait); err != nil {
+				shed(w, r, err, options.retryAfter)
+				return
+			}
+			defer a.release()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fairQueue orders waiters by virtual finish time. Each client's requests
+// are spaced 1/weight apart, starting no earlier than the current virtual
+// time, so clients are served round robin in proportion to their weights.
+type fairQueue struct {
+	waiters    waiterHeap
+	virtual    float64
+	lastFinish map[string]float64
+	seq        uint64
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{lastFinish: make(map[string]float64)}
+}
+
+// tag returns the finish time w would get if it were queued now
+func (q *fairQueue) tag(w *waiter) float64 {
+	start := max(q.virtual, q.lastFinish[w.key])
+	return start + 1/w.weight
+}
+
+func (q *fairQueue) push(w *waiter) {
+	w.finish = q.tag(w)
+	q.seq++
+	w.seq = q.seq
+	q.lastFinish[w.key] = w.finish
+	heap.Push(&q.waiters, w)
+}
+
+func (q *fairQueue) pop() *waiter {
+	if len(q.waiters) == 0 {
+		return nil
+	}
+	w := heap.Pop(&q.waiters).(*waiter)
+	q.virtual = w.finish
+
+	// Clients that are caught up start from the virtual time again
+	for key, finish := range q.lastFinish {
+		if finish <= q.virtual {
+			delete(q.lastFinish, key)
+		}
+	}
+	return w
+}
+
+// evict sheds the waiter furthest back in line if incoming would be
+// served before it
+func (q *fairQueue) evict(incoming *waiter) *waiter {
+	var last *waiter
+	for _, w := range q.waiters {
+		if last == nil || w.finish > last.finish || (w.finish == last.finish && w.seq > last.seq) {
+			last = w
+		}
+	}
+	if last == nil || q.tag(incoming) >= last.finish {
+		return nil
+	}
+	heap.Remove(&q.waiters, last.index)
+	q.rollback(last)
+	return last
+}
+
+func (q *fairQueue) remove(w *waiter) bool {
+	if w.index < 0 || w.index >= len(q.waiters) || q.waiters[w.index] != w {
+		return false
+	}
+	heap.Remove(&q.waiters, w.index)
+	q.rollback(w)
+	return true
+}
+
+// rollback takes back the finish time given to a waiter that left the
+// queue unserved, so shed or cancelled requests do not push the client's
+// later requests back
+func (q *fairQueue) rollback(w *waiter) {
+	if q.lastFinish[w.key] != w.finish {
+		return
+	}
+	delete(q.lastFinish, w.key)
+	for _, other := range q.waiters {
+		if other.key == w.key && other.finish > q.lastFinish[w.key] {
+			q.lastFinish[w.key] = other.finish
+		}
+	}
+}
+
+func (q *fairQueue) len() int {
+	return len(q.waiters)
+}
+
+// waiterHeap is a min-heap by finish time, then arrival
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].finish != h[j].finish {
+		return h[i].finish < h[j].finish
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func fairWaiter(key string, weight float64) *waiter {
+	w := newWaiter()
+	w.key = key
+	w.weight = weight
+	return w
+}
+
+func popKeys(q *fairQueue) []string {
+	var keys []string
+	for w := q.pop(); w != nil; w = q.pop() {
+		keys = append(keys, w.key)
+	}
+	return keys
+}
+
+func TestFairQueue_RoundRobin(t *testing.T) {
+	q := newFairQueue()
+	for i := 0; i < 3; i++ {
+		q.push(fairWaiter("noisy", 1))
+	}
+	q.push(fairWaiter("quiet", 1))
+
+	keys := popKeys(q)
+	if keys[0] != "noisy" || keys[1] != "quiet" {
+		t.Fatalf("Expected quiet client to be served second, got %v", keys)
+	}
+}
+
+func TestFairQueue_Weights(t *testing.T) {
+	q := newFairQueue()
+	for i := 0; i < 4; i++ {
+		q.push(fairWaiter("free", 1))
+	}
+	for i := 0; i < 4; i++ {
+		q.push(fairWaiter("paid", 4))
+	}
+	q.push(fairWaiter("health", 100))
+
+	// Paid requests finish every 0.25, free ones every 1; the tie at 1 goes
+	// to the request that arrived first
+	keys := popKeys(q)
+	want := []string{"health", "paid", "paid", "paid", "free", "paid", "free", "free", "free"}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("Expected order %v, got %v", want, keys)
+		}
+	}
+}
+
+func TestFairQueue_EvictsBurst(t *testing.T) {
+	a := &admission{limit: 1, maxQueue: 2, queue: newFairQueue()}
+	a.acquire(context.Background(), newWaiter(), 0)
+
+	enqueueWaiter := func(w *waiter) chan error {
+		result := make(chan error, 1)
+		before := a.queueLen()
+		go func() { result <- a.acquire(context.Background(), w, time.Second) }()
+		for a.queueLen() == before {
+			time.Sleep(time.Millisecond)
+		}
+		return result
+	}
+
+	first := enqueueWaiter(fairWaiter("noisy", 1))
+	second := enqueueWaiter(fairWaiter("noisy", 1))
+
+	// Another noisy request is shed, a quiet one displaces the burst
+	if err := a.acquire(context.Background(), fairWaiter("noisy", 1), 0); err != errShed {
+		t.Fatalf("Expected noisy request to be shed, got %v", err)
+	}
+	quiet := make(chan error, 1)
+	go func() { quiet <- a.acquire(context.Background(), fairWaiter("quiet", 1), time.Second) }()
+	if err := <-second; err != errShed {
+		t.Fatalf("Expected last noisy request to be evicted, got %v", err)
+	}
+
+	a.release()
+	if err := <-first; err != nil {
+		t.Fatalf("Expected first noisy request to be admitted, got %v", err)
+	}
+	a.release()
+	if err := <-quiet; err != nil {
+		t.Fatalf("Expected quiet request to be admitted, got %v", err)
+	}
+}
+
+func TestFairConcurrencyLimit(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handler := FairConcurrencyLimit(1, 0,
+		WithPriorityClasses(PriorityClass{Name: "health", Weight: 100}),
+		WithClassifier(ClassifyFirst(
+			ClassifyByRoute(map[string]string{"GET /health": "health"}),
+			ClassifyByHeader("X-Plan"),
+		)),
+	)(blockingHandler(started, release))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+		close(done)
+	}()
+	<-started
+
+	routertest.Get("/").Do(t, handler).AssertStatus(http.StatusServiceUnavailable)
+	close(release)
+	<-done
+}
+
+func TestClassifyByRoute(t *testing.T) {
+	classify := ClassifyFirst(
+		ClassifyByRoute(map[string]string{"GET /health": "health"}),
+		ClassifyByHeader("X-Plan"),
+	)
+	if class := classify(routertest.Get("/health").Build(t)); class != "health" {
+		t.Fatalf("Expected health class, got %q", class)
+	}
+	if class := classify(routertest.Get("/orders").Header("X-Plan", "paid").Build(t)); class != "paid" {
+		t.Fatalf("Expected paid class, got %q", class)
+	}
+	if class := classify(routertest.Get("/orders").Build(t)); class != "" {
+		t.Fatalf("Expected default class, got %q", class)
+	}
+}
+
+func TestFairQueue_RemoveRollsBack(t *testing.T) {
+	q := newFairQueue()
+	first := fairWaiter("a", 1)
+	q.push(first)
+	cancelled := fairWaiter("a", 1)
+	q.push(cancelled)
+	q.remove(cancelled)
+
+	// The client's next request takes the place of the cancelled one
+	next := fairWaiter("a", 1)
+	q.push(next)
+	if next.finish != cancelled.finish {
+		t.Fatalf("Expected finish %v, got %v", cancelled.finish, next.finish)
+	}
+
+	q.remove(next)
+	q.remove(first)
+	if _, ok := q.lastFinish["a"]; ok {
+		t.Fatal("Expected client with no queued requests to be forgotten")
+	}
+}
+
+func TestClassifyByPrincipal(t *testing.T) {
+	classify := ClassifyByPrincipal(func(p *Principal) string {
+		if p.HasRole("paid") {
+			return "paid"
+		}
+		return "free"
+	})
+
+	r := routertest.Get("/").Header("X-Plan", "paid").Build(t)
+	if class := classify(r); class != "" {
+		t.Fatalf("Expected default class without a principal, got %q", class)
+	}
+	r = r.WithContext(WithPrincipal(r.Context(), &Principal{ID: "u1", Roles: []string{"paid"}}))
+	if class := classify(r); class != "paid" {
+		t.Fatalf("Expected paid class, got %q", class)
+	}
+}
@@ -0,0 +1,44 @@
+// pkg/middleware/file.go
+package middleware
+
+import (
+	"os"
+	"time"
+)
+
+// watchedFile tracks a file that other processes may replace, checking
+// it at most once per interval
+type watchedFile struct {
+	path     string
+	interval time.Duration
+	info     os.FileInfo
+	checked  time.Time
+}
+
+// stale reports whether the file changed since the last update. Writes
+// replace the file, so a new inode means new content even within the
+// mtime resolution.
+func (f *watchedFile) stale() bool {
+	if time.Since(f.checked) < f.interval {
+		return false
+	}
+	f.checked = time.Now()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false
+	}
+	return f.info == nil || !os.SameFile(info, f.info) ||
+		!info.ModTime().Equal(f.info.ModTime()) || info.Size() != f.info.Size()
+}
+
+// update records the current state of the file after loading or writing it
+func (f *watchedFile) update() {
+	f.checked = time.Now()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.info = nil
+		return
+	}
+	f.info = info
+}
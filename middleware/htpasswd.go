@@ -0,0 +1,158 @@
+// pkg/middleware/htpasswd.go
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordVerifier checks a username and password
+type PasswordVerifier interface {
+	VerifyPassword(user, password string) bool
+}
+
+// PasswordVerifierFunc adapts a function to PasswordVerifier
+type PasswordVerifierFunc func(user, password string) bool
+
+// VerifyPassword implements PasswordVerifier
+func (f PasswordVerifierFunc) VerifyPassword(user, password string) bool {
+	return f(user, password)
+}
+
+// dummyHash is compared against for unknown users so they take as long
+// to reject as wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// Htpasswd verifies passwords against an htpasswd file with bcrypt
+// ($2y$) or argon2id ($argon2id$) hashes. The file is reloaded when it
+// changes, checked at most once per second.
+type Htpasswd struct {
+	mu    sync.Mutex
+	file  watchedFile
+	users map[string]string
+}
+
+// OpenHtpasswd loads the htpasswd file at path
+func OpenHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{file: watchedFile{path: path, interval: time.Second}}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Htpasswd) load() error {
+	data, err := os.ReadFile(h.file.path)
+	if err != nil {
+		return err
+	}
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.file.path, err)
+	}
+	h.users = users
+	h.file.update()
+	return nil
+}
+
+// VerifyPassword implements PasswordVerifier
+func (h *Htpasswd) VerifyPassword(user, password string) bool {
+	h.mu.Lock()
+	if h.file.stale() {
+		// Keep the previous credentials if the new file is broken
+		_ = h.load()
+	}
+	hash, ok := h.users[user]
+	h.mu.Unlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return checkPasswordHash(hash, password)
+}
+
+func parseHtpasswd(data []byte) (map[string]string, error) {
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", line)
+		}
+		if !supportedPasswordHash(hash) {
+			return nil, fmt.Errorf("line %d: unsupported hash for %s, use bcrypt or argon2id", line, user)
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+func supportedPasswordHash(hash string) bool {
+	for _, prefix := range []string{"$2y$", "$2a$", "$2b$", "$argon2id$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashPassword returns a bcrypt hash for an htpasswd file
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func checkPasswordHash(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		ok, err := checkArgon2id(hash, password)
+		return err == nil && ok
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// checkArgon2id verifies a PHC string such as
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func checkArgon2id(encoded, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errors.New("unsupported argon2 version")
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+
+	got := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
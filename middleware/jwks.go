@@ -0,0 +1,331 @@
+// pkg/middleware/jwks.go
+package middleware
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadPEMKeys reads public keys from PEM files. Each file may hold PUBLIC
+// KEY, RSA PUBLIC KEY or CERTIFICATE blocks; the key ID is the file name
+// without extension, suffixed with the block index after the first.
+func LoadPEMKeys(paths ...string) (StaticKeySet, error) {
+	keys := make(StaticKeySet)
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		for i := 0; ; i++ {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				if i == 0 {
+					return nil, fmt.Errorf("%s: no PEM data", path)
+				}
+				break
+			}
+
+			key, err := parsePEMKey(block)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			id := kid
+			if i > 0 {
+				id = fmt.Sprintf("%s-%d", kid, i)
+			}
+			keys[id] = key
+		}
+	}
+	return keys, nil
+}
+
+func parsePEMKey(block *pem.Block) (any, error) {
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+type jwksOptions struct {
+	client          *http.Client
+	ttl             time.Duration
+	refreshInterval time.Duration
+}
+
+type JWKSOption func(*jwksOptions)
+
+// WithJWKSClient sets the HTTP client used to fetch the key set
+func WithJWKSClient(client *http.Client) JWKSOption {
+	return func(o *jwksOptions) {
+		o.client = client
+	}
+}
+
+// WithJWKSCacheTTL sets how long a fetched key set is used, one hour by
+// default
+func WithJWKSCacheTTL(d time.Duration) JWKSOption {
+	return func(o *jwksOptions) {
+		o.ttl = d
+	}
+}
+
+// WithJWKSRefreshInterval sets the minimum time between fetches triggered
+// by unknown key IDs, one minute by default
+func WithJWKSRefreshInterval(d time.Duration) JWKSOption {
+	return func(o *jwksOptions) {
+		o.refreshInterval = d
+	}
+}
+
+// JWKS is a KeySet fetched from a JSON Web Key Set URL. Keys are cached
+// and refetched when the cache expires or a token names an unknown key
+// ID, so signing key rotation is picked up without a restart. Concurrent
+// lookups share one fetch, and failed fetches are retried with backoff
+// while the cached keys stay in use.
+type JWKS struct {
+	url     string
+	options *jwksOptions
+
+	mu        sync.Mutex
+	keys      StaticKeySet
+	fetched   time.Time
+	attempted time.Time
+	failures  int
+	retryAt   time.Time
+	lastErr   error
+	call      *jwksCall
+	now       func() time.Time
+}
+
+// jwksCall is a fetch in progress that other lookups wait for
+type jwksCall struct {
+	done chan struct{}
+}
+
+// NewJWKS creates a key set for url. Nothing is fetched until first use.
+func NewJWKS(url string, opts ...JWKSOption) *JWKS {
+	options := &jwksOptions{
+		client:          &http.Client{Timeout: 10 * time.Second},
+		ttl:             time.Hour,
+		refreshInterval: time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &JWKS{url: url, options: options, now: time.Now}
+}
+
+// Keys implements KeySet
+func (j *JWKS) Keys(ctx context.Context, kid string) ([]any, error) {
+	j.mu.Lock()
+	now := j.now()
+	_, known := j.keys[kid]
+	stale := j.keys == nil || now.Sub(j.fetched) >= j.options.ttl
+	rotated := kid != "" && !known && now.Sub(j.attempted) >= j.options.refreshInterval
+
+	call := j.call
+	leader := false
+	if call == nil && (stale || rotated) && !now.Before(j.retryAt) {
+		call = &jwksCall{done: make(chan struct{})}
+		j.call = call
+		j.attempted = now
+		leader = true
+	}
+	wait := call != nil && (stale || (kid != "" && !known))
+	j.mu.Unlock()
+
+	if leader {
+		// The fetch is shared, so it must not fail because the request
+		// that started it went away
+		keys, err := j.fetch(context.WithoutCancel(ctx))
+		j.finish(call, keys, err)
+	} else if wait {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.keys == nil {
+		// Nothing was ever fetched; report why
+		return nil, j.lastErr
+	}
+	if key, ok := j.keys[kid]; ok {
+		return []any{key}, nil
+	}
+	if kid != "" {
+		return nil, nil
+	}
+	return j.keys.Keys(ctx, kid)
+}
+
+// finish stores the result of a fetch and wakes the lookups waiting for
+// it. Failures back off exponentially from one second up to the refresh
+// interval; the previous keys are kept meanwhile.
+func (j *JWKS) finish(call *jwksCall, keys StaticKeySet, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	if err != nil {
+		j.failures++
+		backoff := min(time.Second<<min(j.failures-1, 16), max(j.options.refreshInterval, time.Second))
+		j.retryAt = now.Add(backoff)
+		j.lastErr = err
+	} else {
+		j.keys = keys
+		j.fetched = now
+		j.failures = 0
+		j.retryAt = time.Time{}
+		j.lastErr = nil
+	}
+	j.call = nil
+	close(call.done)
+}
+
+func (j *JWKS) fetch(ctx context.Context) (StaticKeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.options.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(StaticKeySet, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip key types we cannot use rather than failing the set
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// jsonWebKey is an RFC 7517 public key
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		// ecdh rejects points that are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,436 @@
+// pkg/middleware/jwt.go
+package middleware
+
+/**
+ex usage:
+// Keys from a JWKS endpoint, cached and refreshed on rotation
+router.Use(middleware.JWT(
+	middleware.WithJWTKeys(middleware.NewJWKS("https://auth.example.com/.well-known/jwks.json")),
+	middleware.WithJWTIssuer("https://auth.example.com/"),
+	middleware.WithJWTAudience("orders-api"),
+))
+
+// Keys from PEM files
+keys, err := middleware.LoadPEMKeys("/etc/api/signing.pub")
+router.Use(middleware.JWT(middleware.WithJWTKeys(keys)))
+
+// In handlers
+principal, _ := middleware.PrincipalFrom(r.Context())
+claims, _ := middleware.JWTClaimsFrom(r.Context())
+*/
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JWTClaimsKey is the context key holding the verified JWTClaims
+const JWTClaimsKey ContextKey = "jwtClaims"
+
+// Supported signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrTokenMalformed    = errors.New("token is malformed")
+	ErrTokenAlgorithm    = errors.New("token algorithm is not allowed")
+	ErrTokenSignature    = errors.New("token signature is invalid")
+	ErrTokenExpired      = errors.New("token is expired")
+	ErrTokenNotYetValid  = errors.New("token is not valid yet")
+	ErrTokenIssuer       = errors.New("token issuer is not accepted")
+	ErrTokenAudience     = errors.New("token audience is not accepted")
+	ErrTokenMissingClaim = errors.New("token is missing a required claim")
+)
+
+// JWTClaims are the claims of a verified token
+type JWTClaims map[string]any
+
+// String returns a string claim
+func (c JWTClaims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Subject returns the "sub" claim
+func (c JWTClaims) Subject() string {
+	return c.String("sub")
+}
+
+// Audience returns the "aud" claim, which may be a string or a list
+func (c JWTClaims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		var result []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// Time returns a NumericDate claim such as "exp"
+func (c JWTClaims) Time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		sec, frac := int64(v), v-float64(int64(v))
+		return time.Unix(sec, int64(frac*1e9)), true
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(f), 0), true
+	}
+	return time.Time{}, false
+}
+
+// Strings returns a claim holding a list of strings, or a space separated
+// string as used by "scope"
+func (c JWTClaims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// PrincipalFromClaims maps the common claims sub, name, tenant (or tid),
+// roles and scope (or scp) to a principal
+func PrincipalFromClaims(claims JWTClaims) *Principal {
+	p := &Principal{
+		ID:     claims.Subject(),
+		Name:   claims.String("name"),
+		Tenant: claims.String("tenant"),
+		Roles:  claims.Strings("roles"),
+		Scopes: claims.Strings("scope"),
+		Method: "jwt",
+	}
+	if p.Tenant == "" {
+		p.Tenant = claims.String("tid")
+	}
+	if p.Scopes == nil {
+		p.Scopes = claims.Strings("scp")
+	}
+	return p
+}
+
+// JWTClaimsFrom returns the verified claims stored by the JWT middleware
+func JWTClaimsFrom(ctx context.Context) (JWTClaims, bool) {
+	claims, ok := ctx.Value(JWTClaimsKey).(JWTClaims)
+	return claims, ok
+}
+
+// KeySet resolves the keys a token may be signed with. HS256 keys are
+// []byte, the others *rsa.PublicKey, *ecdsa.PublicKey and
+// ed25519.PublicKey.
+type KeySet interface {
+	// Keys returns candidate keys for the key ID from the token header,
+	// which may be empty
+	Keys(ctx context.Context, kid string) ([]any, error)
+}
+
+// StaticKeySet is a fixed set of keys by key ID. Tokens with an unknown
+// or missing key ID are checked against every key.
+type StaticKeySet map[string]any
+
+// Keys implements KeySet
+func (s StaticKeySet) Keys(_ context.Context, kid string) ([]any, error) {
+	if key, ok := s[kid]; ok {
+		return []any{key}, nil
+	}
+	keys := make([]any, 0, len(s))
+	for _, key := range s {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+type jwtOptions struct {
+	keys       KeySet
+	algorithms []string
+	issuer     string
+	audience   []string
+	skew       time.Duration
+	now        func() time.Time
+	principal  func(JWTClaims) *Principal
+}
+
+type JWTOption func(*jwtOptions)
+
+// WithJWTKeys sets where verification keys come from
+func WithJWTKeys(keys KeySet) JWTOption {
+	return func(o *jwtOptions) {
+		o.keys = keys
+	}
+}
+
+// WithJWTSecret verifies HS256 tokens with a shared secret
+func WithJWTSecret(secret []byte) JWTOption {
+	return func(o *jwtOptions) {
+		o.keys = StaticKeySet{"": secret}
+	}
+}
+
+// WithJWTAlgorithms restricts the accepted algorithms, all supported ones
+// by default
+func WithJWTAlgorithms(algorithms ...string) JWTOption {
+	return func(o *jwtOptions) {
+		o.algorithms = algorithms
+	}
+}
+
+// WithJWTIssuer requires the "iss" claim to equal issuer
+func WithJWTIssuer(issuer string) JWTOption {
+	return func(o *jwtOptions) {
+		o.issuer = issuer
+	}
+}
+
+// WithJWTAudience requires the "aud" claim to contain one of audience
+func WithJWTAudience(audience ...string) JWTOption {
+	return func(o *jwtOptions) {
+		o.audience = audience
+	}
+}
+
+// WithClockSkew sets the leeway for "exp" and "nbf", one minute by default
+func WithClockSkew(d time.Duration) JWTOption {
+	return func(o *jwtOptions) {
+		o.skew = d
+	}
+}
+
+// WithJWTPrincipal sets how claims map to the request principal,
+// PrincipalFromClaims by default. Returning nil or a principal without an
+// ID rejects the token.
+func WithJWTPrincipal(fn func(JWTClaims) *Principal) JWTOption {
+	return func(o *jwtOptions) {
+		o.principal = fn
+	}
+}
+
+// JWTValidator verifies tokens and validates their claims
+type JWTValidator struct {
+	options *jwtOptions
+}
+
+// NewJWTValidator creates a validator
+func NewJWTValidator(opts ...JWTOption) *JWTValidator {
+	options := &jwtOptions{
+		algorithms: []string{HS256, RS256, ES256, EdDSA},
+		skew:       time.Minute,
+		now:        time.Now,
+		principal:  PrincipalFromClaims,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &JWTValidator{options: options}
+}
+
+// Validate verifies the signature of a compact JWS token and validates
+// exp, nbf, iss and aud. Tokens without exp are rejected.
+func (v *JWTValidator) Validate(ctx context.Context, token string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !slices.Contains(v.options.algorithms, header.Alg) {
+		return nil, ErrTokenAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if v.options.keys == nil {
+		return nil, errors.New("no verification keys configured")
+	}
+	keys, err := v.options.keys.Keys(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("resolving keys: %w", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if verifySignature(header.Alg, key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrTokenSignature
+	}
+
+	var claims JWTClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTValidator) validateClaims(claims JWTClaims) error {
+	now := v.options.now()
+
+	exp, ok := claims.Time("exp")
+	if !ok {
+		return fmt.Errorf("%w: exp", ErrTokenMissingClaim)
+	}
+	if !now.Before(exp.Add(v.options.skew)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(v.options.skew).Before(nbf) {
+		return ErrTokenNotYetValid
+	}
+	if v.options.issuer != "" && claims.String("iss") != v.options.issuer {
+		return ErrTokenIssuer
+	}
+	if len(v.options.audience) > 0 {
+		accepted := slices.ContainsFunc(claims.Audience(), func(aud string) bool {
+			return slices.Contains(v.options.audience, aud)
+		})
+		if !accepted {
+			return ErrTokenAudience
+		}
+	}
+	return nil
+}
+
+// verifySignature checks the signature with key if the key type matches
+// the algorithm, which prevents algorithm confusion
+func verifySignature(alg string, key any, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != 256 || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signed, signature)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// JWT creates a middleware that requires a valid bearer token. The claims
+// are mapped to the request principal and stored under JWTClaimsKey.
+// Missing or invalid tokens, and tokens whose principal has no ID, get 401
+// with a WWW-Authenticate challenge.
+func JWT(opts ...JWTOption) func(http.Handler) http.Handler {
+	validator := NewJWTValidator(opts...)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				RenderProblem(w, r, Problem{
+					Status: http.StatusUnauthorized,
+					Detail: "Missing bearer token",
+				})
+				return
+			}
+
+			claims, err := validator.Validate(r.Context(), token)
+			var principal *Principal
+			if err == nil {
+				if principal = validator.options.principal(claims); principal == nil {
+					err = errors.New("token maps to no principal")
+				} else if principal.ID == "" {
+					// Tokens without a subject would share one identity
+					err = errors.New("token has no subject")
+				}
+			}
+			if err != nil {
+				AuditEvent(r, "JWT rejected", "error", err.Error())
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				RenderProblem(w, r, Problem{
+					Status: http.StatusUnauthorized,
+					Detail: "Invalid bearer token",
+				})
+				return
+			}
+
+			ctx := WithPrincipal(r.Context(), principal)
+			r = r.WithContext(context.WithValue(ctx, JWTClaimsKey, claims))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken extracts the token from the Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
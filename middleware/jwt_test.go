@@ -0,0 +1,442 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+// signJWT builds a compact token signed with key
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("Expected no error signing, got %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub": "user-1",
+		"iss": "https://issuer.example",
+		"aud": []string{"api"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestJWTValidator_Algorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("shared-secret")
+
+	keys := StaticKeySet{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+		"es": &ecKey.PublicKey,
+		"ed": edPub,
+	}
+	validator := NewJWTValidator(WithJWTKeys(keys))
+
+	cases := map[string]string{
+		"hs": signJWT(t, HS256, "hs", secret, validClaims()),
+		"rs": signJWT(t, RS256, "rs", rsaKey, validClaims()),
+		"es": signJWT(t, ES256, "es", ecKey, validClaims()),
+		"ed": signJWT(t, EdDSA, "ed", edKey, validClaims()),
+		// No kid falls back to trying every key
+		"no kid": signJWT(t, RS256, "", rsaKey, validClaims()),
+	}
+	for name, token := range cases {
+		claims, err := validator.Validate(context.Background(), token)
+		if err != nil {
+			t.Fatalf("%s: Expected valid token, got %v", name, err)
+		}
+		if claims.Subject() != "user-1" {
+			t.Fatalf("%s: Expected subject user-1, got %q", name, claims.Subject())
+		}
+	}
+}
+
+func TestJWTValidator_Rejects(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pubDER := x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)
+
+	validator := NewJWTValidator(
+		WithJWTKeys(StaticKeySet{"rs": &rsaKey.PublicKey}),
+		WithJWTIssuer("https://issuer.example"),
+		WithJWTAudience("api"),
+		WithClockSkew(30*time.Second),
+	)
+
+	with := func(name string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	cases := map[string]struct {
+		token string
+		err   error
+	}{
+		"expired":       {signJWT(t, RS256, "rs", rsaKey, with("exp", time.Now().Add(-time.Minute).Unix())), ErrTokenExpired},
+		"not yet valid": {signJWT(t, RS256, "rs", rsaKey, with("nbf", time.Now().Add(time.Minute).Unix())), ErrTokenNotYetValid},
+		"no exp":        {signJWT(t, RS256, "rs", rsaKey, with("exp", nil)), ErrTokenMissingClaim},
+		"issuer":        {signJWT(t, RS256, "rs", rsaKey, with("iss", "https://evil.example")), ErrTokenIssuer},
+		"audience":      {signJWT(t, RS256, "rs", rsaKey, with("aud", "other")), ErrTokenAudience},
+		"wrong key":     {signJWT(t, RS256, "rs", otherKey, validClaims()), ErrTokenSignature},
+		// HS256 signed with the public key bytes must not verify
+		"confusion": {signJWT(t, HS256, "rs", pubDER, validClaims()), ErrTokenSignature},
+		"none":      {signJWT(t, "none", "rs", []byte{}, validClaims()), ErrTokenAlgorithm},
+		"malformed": {"not-a-token", ErrTokenMalformed},
+	}
+	for name, tc := range cases {
+		if _, err := validator.Validate(context.Background(), tc.token); !errors.Is(err, tc.err) {
+			t.Fatalf("%s: Expected %v, got %v", name, tc.err, err)
+		}
+	}
+
+	// Within the skew an expired token is still accepted
+	token := signJWT(t, RS256, "rs", rsaKey, with("exp", time.Now().Add(-10*time.Second).Unix()))
+	if _, err := validator.Validate(context.Background(), token); err != nil {
+		t.Fatalf("Expected token within skew to be valid, got %v", err)
+	}
+}
+
+func TestJWT_Middleware(t *testing.T) {
+	secret := []byte("shared-secret")
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(JWT(WithJWTSecret(secret))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := JWTClaimsFrom(r.Context())
+			w.Write([]byte(r.Context().Value(UserIDKey).(string) + " " + claims.String("iss")))
+		}),
+	))
+
+	token := signJWT(t, HS256, "", secret, validClaims())
+	routertest.Get("/").
+		Header("Authorization", "Bearer "+token).
+		Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertBody("user-1 https://issuer.example")
+
+	routertest.Get("/").
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized).
+		AssertHeader("WWW-Authenticate", "Bearer").
+		AssertHeader("Content-Type", "application/problem+json")
+
+	routertest.Get("/").
+		Header("Authorization", "Bearer "+token+"x").
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized).
+		AssertHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+
+	if _, ok := recorder.Find("JWT rejected"); !ok {
+		t.Fatal("Expected an audit event for the rejected token")
+	}
+	if _, ok := recorder.Find("Principal authenticated"); !ok {
+		t.Fatal("Expected an audit record for the accepted token")
+	}
+}
+
+func TestJWT_NilPrincipal(t *testing.T) {
+	secret := []byte("shared-secret")
+	handler := JWT(WithJWTSecret(secret), WithJWTPrincipal(func(JWTClaims) *Principal { return nil }))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+
+	routertest.Get("/").
+		Header("Authorization", "Bearer "+signJWT(t, HS256, "", secret, validClaims())).
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+}
+
+func TestJWT_EmptySubject(t *testing.T) {
+	secret := []byte("shared-secret")
+	handler := JWT(WithJWTSecret(secret))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, sub := range []any{nil, ""} {
+		claims := validClaims()
+		if sub == nil {
+			delete(claims, "sub")
+		} else {
+			claims["sub"] = sub
+		}
+		routertest.Get("/").
+			Header("Authorization", "Bearer "+signJWT(t, HS256, "", secret, claims)).
+			Do(t, handler).
+			AssertStatus(http.StatusUnauthorized).
+			AssertHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+}
+
+func TestJWKS_Rotation(t *testing.T) {
+	first, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	second, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	ecJWK := func(kid string, key *ecdsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "EC", "crv": "P-256", "kid": kid, "use": "sig",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}
+	}
+
+	var current atomic.Value
+	current.Store([]map[string]string{ecJWK("k1", first)})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": current.Load()})
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL, WithJWKSRefreshInterval(0))
+	validator := NewJWTValidator(WithJWTKeys(jwks))
+
+	if _, err := validator.Validate(context.Background(), signJWT(t, ES256, "k1", first, validClaims())); err != nil {
+		t.Fatalf("Expected valid token, got %v", err)
+	}
+	if _, err := validator.Validate(context.Background(), signJWT(t, ES256, "k1", first, validClaims())); err != nil {
+		t.Fatalf("Expected valid token, got %v", err)
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("Expected the key set to be cached, got %d fetches", fetches.Load())
+	}
+
+	// The issuer rotates to a new key; the unknown kid triggers a refetch
+	current.Store([]map[string]string{
+		ecJWK("k2", second),
+		{"kty": "OKP", "crv": "Ed25519", "kid": "k3", "x": base64.RawURLEncoding.EncodeToString(edPub)},
+		{"kty": "oct", "kid": "ignored"},
+	})
+	if _, err := validator.Validate(context.Background(), signJWT(t, ES256, "k2", second, validClaims())); err != nil {
+		t.Fatalf("Expected rotated key to verify, got %v", err)
+	}
+	if _, err := validator.Validate(context.Background(), signJWT(t, EdDSA, "k3", edKey, validClaims())); err != nil {
+		t.Fatalf("Expected Ed25519 key to verify, got %v", err)
+	}
+	if _, err := validator.Validate(context.Background(), signJWT(t, ES256, "k1", first, validClaims())); !errors.Is(err, ErrTokenSignature) {
+		t.Fatalf("Expected retired key to be rejected, got %v", err)
+	}
+}
+
+func TestJWKS_RefreshInterval(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	for range 3 {
+		jwks.Keys(context.Background(), "unknown")
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("Expected unknown kids to be rate limited, got %d fetches", fetches.Load())
+	}
+}
+
+func TestLoadPEMKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "signing.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+
+	keys, err := LoadPEMKeys(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := keys["signing"]; !ok {
+		t.Fatalf("Expected key ID signing, got %v", keys)
+	}
+
+	validator := NewJWTValidator(WithJWTKeys(keys))
+	if _, err := validator.Validate(context.Background(), signJWT(t, RS256, "signing", rsaKey, validClaims())); err != nil {
+		t.Fatalf("Expected valid token, got %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad.pem")
+	os.WriteFile(bad, []byte("nope"), 0o600)
+	if _, err := LoadPEMKeys(bad); err == nil {
+		t.Fatal("Expected an error for a file without PEM data")
+	}
+}
+
+func TestPrincipalFromClaims(t *testing.T) {
+	secret := []byte("shared-secret")
+	claims := validClaims()
+	claims["name"] = "Ada"
+	claims["tid"] = "acme"
+	claims["roles"] = []string{"admin"}
+	claims["scope"] = "orders:read orders:write"
+
+	handler := JWT(WithJWTSecret(secret))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			t.Fatal("Expected a principal")
+		}
+		if p.ID != "user-1" || p.Name != "Ada" || p.Tenant != "acme" || p.Method != "jwt" {
+			t.Fatalf("Expected mapped claims, got %+v", p)
+		}
+		if !p.HasRole("admin") || !p.HasScope("orders:write") {
+			t.Fatalf("Expected roles and scopes, got %+v", p)
+		}
+	}))
+
+	routertest.Get("/").
+		Header("Authorization", "Bearer "+signJWT(t, HS256, "", secret, claims)).
+		Do(t, handler).
+		AssertStatus(http.StatusOK)
+}
+
+func TestJWKS_SharedFetch(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	done := make(chan error)
+	for range 5 {
+		go func() {
+			_, err := jwks.Keys(context.Background(), "k1")
+			done <- err
+		}()
+	}
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for range 5 {
+		if err := <-done; err != nil {
+			t.Fatalf("Expected lookup to succeed, got %v", err)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("Expected concurrent lookups to share one fetch, got %d", fetches.Load())
+	}
+}
+
+func TestJWKS_FailureBackoff(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "EC", "crv": "P-256", "kid": "k1",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	jwks := NewJWKS(server.URL, WithJWKSCacheTTL(time.Minute))
+	jwks.now = func() time.Time { return now }
+	if keys, err := jwks.Keys(context.Background(), "k1"); err != nil || len(keys) != 1 {
+		t.Fatalf("Expected the key, got %v %v", keys, err)
+	}
+
+	// The endpoint goes down after the cache expires
+	failing.Store(true)
+	now = now.Add(2 * time.Minute)
+	for _, kid := range []string{"k1", "x1", "x2", "x3"} {
+		jwks.Keys(context.Background(), kid)
+	}
+	if fetches.Load() != 2 {
+		t.Fatalf("Expected failed fetches to back off, got %d fetches", fetches.Load())
+	}
+	if keys, err := jwks.Keys(context.Background(), "k1"); err != nil || len(keys) != 1 {
+		t.Fatalf("Expected cached key while the endpoint is down, got %v %v", keys, err)
+	}
+
+	now = now.Add(time.Second)
+	jwks.Keys(context.Background(), "k1")
+	if fetches.Load() != 3 {
+		t.Fatalf("Expected a retry after the backoff, got %d fetches", fetches.Load())
+	}
+	now = now.Add(time.Second)
+	jwks.Keys(context.Background(), "k1")
+	if fetches.Load() != 3 {
+		t.Fatalf("Expected the backoff to grow, got %d fetches", fetches.Load())
+	}
+}
+
+func TestJWKS_ColdFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	for range 2 {
+		if _, err := jwks.Keys(context.Background(), "k1"); err == nil {
+			t.Fatal("Expected an error without any cached keys")
+		}
+	}
+}
@@ -11,15 +11,19 @@ import (
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		requestID := r.Context().Value(RequestIDKey).(string)
+		requestID, ok := r.Context().Value(RequestIDKey).(string)
+		if !ok {
+			requestID = "unknown"
+		}
 		rw := newResponseWriter(w)
+		ctx, slot := withPrincipalSlot(r.Context())
 
 		log.Printf("[%s] Starting %s %s", requestID, r.Method, r.URL.Path)
-		next.ServeHTTP(rw, r)
+		next.ServeHTTP(rw, r.WithContext(ctx))
 
 		duration := time.Since(start)
-		log.Printf("[%s] Completed %s %s [%d] in %v",
-			requestID, r.Method, r.URL.Path, rw.statusCode, duration,
+		log.Printf("[%s] Completed %s %s [%d] in %v%s",
+			requestID, r.Method, r.URL.Path, rw.statusCode, duration, principalSuffix(slot.Load()),
 		)
 	})
 }
@@ -37,3 +41,25 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// principalSuffix describes the authenticated caller for the log line
+func principalSuffix(p *Principal) string {
+	switch {
+	case p == nil:
+		return ""
+	case p.Method == "":
+		return " as " + p.ID
+	default:
+		return " as " + p.ID + " via " + p.Method
+	}
+}
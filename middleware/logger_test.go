@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/vhellman/lw-router/routertest"
 )
 
 const testRequestID = "test-request-id"
@@ -26,9 +28,10 @@ func TestLogger(t *testing.T) {
 	req = req.WithContext(ctx)
 
 	var buf bytes.Buffer
+	prev := log.Writer()
 	log.SetOutput(&buf)
 	defer func() {
-		log.SetOutput(nil)
+		log.SetOutput(prev)
 	}()
 
 	resp, err := http.DefaultClient.Do(req)
@@ -76,3 +79,19 @@ func TestResponseWriter_WriteHeader(t *testing.T) {
 func contains(s, substr string) bool {
 	return bytes.Contains([]byte(s), []byte(substr))
 }
+
+func TestLogger_RequestIDFromContext(t *testing.T) {
+	logs := routertest.CaptureLog(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	routertest.Get("/tea").
+		ContextValue(RequestIDKey, testRequestID).
+		Do(t, Logger(handler)).
+		AssertStatus(http.StatusTeapot)
+
+	if !logs.Contains("[test-request-id] Completed GET /tea [418]") {
+		t.Fatalf("Expected completion line with request ID, got %s", logs.String())
+	}
+}
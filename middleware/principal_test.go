@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func TestWithPrincipal(t *testing.T) {
+	p := &Principal{ID: "user-1", Roles: []string{"admin"}, Scopes: []string{"orders:read"}}
+	ctx := WithPrincipal(context.Background(), p)
+
+	got, ok := PrincipalFrom(ctx)
+	if !ok || got != p {
+		t.Fatalf("Expected principal %v, got %v", p, got)
+	}
+	if id := ctx.Value(UserIDKey); id != "user-1" {
+		t.Fatalf("Expected user ID user-1, got %v", id)
+	}
+	if !got.HasRole("admin") || got.HasRole("owner") {
+		t.Fatalf("Expected only role admin, got %v", got.Roles)
+	}
+	if !got.HasScope("orders:read") || got.HasScope("orders:write") {
+		t.Fatalf("Expected only scope orders:read, got %v", got.Scopes)
+	}
+
+	if _, ok := PrincipalFrom(context.Background()); ok {
+		t.Fatal("Expected no principal in an empty context")
+	}
+}
+
+func TestWithPrincipal_Nil(t *testing.T) {
+	ctx := context.Background()
+	if WithPrincipal(ctx, nil) != ctx {
+		t.Fatal("Expected a nil principal to leave the context unchanged")
+	}
+}
+
+func TestWithPrincipal_Audits(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(http.StripPrefix("/api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A third-party authenticator only calls WithPrincipal
+		WithPrincipal(r.Context(), &Principal{ID: "user-1"})
+	})))
+
+	routertest.Get("/api/orders").Do(t, handler)
+	record, ok := recorder.Find("Principal authenticated")
+	if !ok || record.Attrs["principal.id"] != "user-1" || record.Attrs["path"] != "/api/orders" {
+		t.Fatalf("Expected a Principal authenticated record, got %v", recorder.Records())
+	}
+}
+
+// authenticate stands in for an auth middleware further down the chain
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := &Principal{ID: "user-1", Tenant: "acme", Method: "test"}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+func TestAudit_Principal(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()), WithHeaders([]string{"X-Client"}))(
+		authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			AuditEvent(r, "Access denied")
+			w.WriteHeader(http.StatusForbidden)
+		})),
+	)
+
+	routertest.Get("/").Header("X-Client", "cli").Do(t, handler)
+
+	record, ok := recorder.Find("Principal authenticated")
+	if !ok {
+		t.Fatal("Expected a Principal authenticated record")
+	}
+	if record.Attrs["principal.id"] != "user-1" || record.Attrs["principal.tenant"] != "acme" {
+		t.Fatalf("Expected principal attributes, got %v", record.Attrs)
+	}
+	if record.Attrs["X-Client"] != "cli" {
+		t.Fatalf("Expected audited headers, got %v", record.Attrs)
+	}
+
+	event, _ := recorder.Find("Access denied")
+	if event.Attrs["principal.method"] != "test" {
+		t.Fatalf("Expected the event to include the principal, got %v", event.Attrs)
+	}
+}
+
+func TestLogger_Principal(t *testing.T) {
+	capture := routertest.CaptureLog(t)
+	handler := Logger(authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	routertest.Get("/orders").Do(t, handler)
+
+	if !capture.Contains("[200] in") || !capture.Contains("as user-1 via test") {
+		t.Fatalf("Expected the completion line to name the principal, got %s", capture.String())
+	}
+}
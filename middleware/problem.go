@@ -0,0 +1,57 @@
+// pkg/middleware/problem.go
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Problem is an RFC 9457 problem details document
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// WriteProblem writes p as an application/problem+json response
+func WriteProblem(w http.ResponseWriter, p Problem) {
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// ErrorRenderer writes the response for a request rejected by middleware
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, p Problem)
+
+// ErrorRendererKey is the context key holding the ErrorRenderer
+const ErrorRendererKey ContextKey = "errorRenderer"
+
+// RenderErrors creates a middleware that makes every later middleware
+// render rejections through renderer instead of WriteProblem
+func RenderErrors(renderer ErrorRenderer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), ErrorRendererKey, renderer)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RenderProblem writes p with the renderer from the context, falling back
+// to WriteProblem. Middleware outside this package uses it to reject
+// requests consistently.
+func RenderProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if renderer, ok := r.Context().Value(ErrorRendererKey).(ErrorRenderer); ok && renderer != nil {
+		renderer(w, r, p)
+		return
+	}
+	WriteProblem(w, p)
+}
@@ -0,0 +1,286 @@
+// pkg/middleware/quota.go
+package middleware
+
+/**
+ex usage:
+store, err := middleware.OpenFileQuotaStore("/var/lib/api/quotas.json")
+if err != nil {
+	log.Fatal(err)
+}
+defer store.Close()
+
+quotas := []middleware.QuotaOption{
+	middleware.WithQuota(middleware.Monthly, 100000),
+	middleware.WithQuota(middleware.Daily, 10000),
+	middleware.WithQuotaKey(middleware.KeyByHeader("X-API-Key")),
+	middleware.WithQuotaWarning(0.9, func(u middleware.QuotaUsage) {
+		notifyCustomer(u.Key, u.Used, u.Limit)
+	}),
+}
+router.Use(middleware.Quota(store, quotas...))
+adminMux.Handle("/quotas", middleware.QuotaReport(store, quotas...))
+*/
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// QuotaPeriod is a calendar period in UTC that usage is counted over
+type QuotaPeriod int
+
+const (
+	Daily QuotaPeriod = iota
+	Monthly
+)
+
+// String returns the period name used in records and reports
+func (p QuotaPeriod) String() string {
+	if p == Monthly {
+		return "monthly"
+	}
+	return "daily"
+}
+
+// Start returns the start of the period containing t
+func (p QuotaPeriod) Start(t time.Time) time.Time {
+	t = t.UTC()
+	if p == Monthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// End returns the start of the period after the one containing t
+func (p QuotaPeriod) End(t time.Time) time.Time {
+	if p == Monthly {
+		return p.Start(t).AddDate(0, 1, 0)
+	}
+	return p.Start(t).AddDate(0, 0, 1)
+}
+
+// QuotaRecord is the stored usage of one key in one period
+type QuotaRecord struct {
+	Key    string    `json:"key"`
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	Used   int64     `json:"used"`
+}
+
+// parseQuotaPeriod is the inverse of QuotaPeriod.String
+func parseQuotaPeriod(s string) QuotaPeriod {
+	if s == "monthly" {
+		return Monthly
+	}
+	return Daily
+}
+
+// QuotaCharge is one counter a request is charged against
+type QuotaCharge struct {
+	Period QuotaPeriod
+	Start  time.Time
+	Limit  int64
+}
+
+// QuotaStore persists usage counters
+type QuotaStore interface {
+	// Consume adds one request to the counters for key in every charged
+	// period, or to none of them if any would exceed its limit. used holds
+	// the usage per charge. Counters from an earlier period start over.
+	Consume(key string, charges []QuotaCharge) (used []int64, ok bool, err error)
+	// Records returns the usage of every key in its latest period
+	Records() ([]QuotaRecord, error)
+}
+
+// QuotaUsage is passed to warning callbacks
+type QuotaUsage struct {
+	Key    string
+	Period QuotaPeriod
+	Used   int64
+	Limit  int64
+	Reset  time.Time
+}
+
+type quotaLimit struct {
+	period QuotaPeriod
+	limit  int64
+}
+
+type quotaWarning struct {
+	threshold float64
+	fn        func(QuotaUsage)
+}
+
+type quotaOptions struct {
+	limits   []quotaLimit
+	keyFunc  KeyFunc
+	warnings []quotaWarning
+	logger   *slog.Logger
+}
+
+type QuotaOption func(*quotaOptions)
+
+// WithQuota limits each key to limit requests per period. It can be given
+// once per period.
+func WithQuota(period QuotaPeriod, limit int64) QuotaOption {
+	return func(o *quotaOptions) {
+		o.limits = append(o.limits, quotaLimit{period, limit})
+	}
+}
+
+// WithQuotaKey sets how requests are grouped
+func WithQuotaKey(fn KeyFunc) QuotaOption {
+	return func(o *quotaOptions) {
+		o.keyFunc = fn
+	}
+}
+
+// WithQuotaWarning calls fn once per period when a key's usage reaches
+// the given fraction of a quota, e.g. 0.9 for 90%
+func WithQuotaWarning(threshold float64, fn func(QuotaUsage)) QuotaOption {
+	return func(o *quotaOptions) {
+		o.warnings = append(o.warnings, quotaWarning{threshold, fn})
+	}
+}
+
+// WithQuotaLogger sets the logger used to report store errors
+func WithQuotaLogger(logger *slog.Logger) QuotaOption {
+	return func(o *quotaOptions) {
+		o.logger = logger
+	}
+}
+
+func newQuotaOptions(opts []QuotaOption) *quotaOptions {
+	options := &quotaOptions{
+		keyFunc: KeyByIP,
+		logger:  slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// Quota creates a middleware that enforces long-window usage quotas.
+// Responses carry X-Quota-Limit, X-Quota-Remaining and X-Quota-Reset for
+// the quota closest to running out; exhausted quotas get 429.
+func Quota(store QuotaStore, opts ...QuotaOption) func(http.Handler) http.Handler {
+	options := newQuotaOptions(opts)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := options.keyFunc(r)
+			if key == "" || len(options.limits) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now()
+			charges := make([]QuotaCharge, len(options.limits))
+			for i, l := range options.limits {
+				charges[i] = QuotaCharge{Period: l.period, Start: l.period.Start(now), Limit: l.limit}
+			}
+			used, ok, err := store.Consume(key, charges)
+			if err != nil {
+				// Fail open so a broken store does not take the API down
+				options.logger.ErrorContext(r.Context(), "Quota store failed", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var tightest QuotaUsage
+			remaining := int64(math.MaxInt64)
+			for i, l := range options.limits {
+				usage := QuotaUsage{Key: key, Period: l.period, Used: used[i], Limit: l.limit, Reset: l.period.End(now)}
+				if !ok {
+					if used[i] < l.limit {
+						continue
+					}
+					setQuotaHeaders(w, usage, now)
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(usage.Reset.Sub(now))))
+					RenderProblem(w, r, Problem{
+						Status: http.StatusTooManyRequests,
+						Detail: "The " + l.period.String() + " quota is exhausted",
+					})
+					return
+				}
+
+				options.warn(usage)
+				if l.limit-used[i] < remaining {
+					remaining = l.limit - used[i]
+					tightest = usage
+				}
+			}
+
+			setQuotaHeaders(w, tightest, now)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// warn fires the callbacks whose threshold was reached by this request
+func (o *quotaOptions) warn(usage QuotaUsage) {
+	for _, warning := range o.warnings {
+		if usage.Used == int64(math.Ceil(warning.threshold*float64(usage.Limit))) {
+			warning.fn(usage)
+		}
+	}
+}
+
+func setQuotaHeaders(w http.ResponseWriter, usage QuotaUsage, now time.Time) {
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(usage.Limit, 10))
+	w.Header().Set("X-Quota-Remaining", strconv.FormatInt(max(usage.Limit-usage.Used, 0), 10))
+	w.Header().Set("X-Quota-Reset", strconv.Itoa(ceilSeconds(usage.Reset.Sub(now))))
+}
+
+// QuotaReportEntry is one line of the usage report
+type QuotaReportEntry struct {
+	QuotaRecord
+	Limit     int64 `json:"limit"`
+	Remaining int64 `json:"remaining"`
+}
+
+// QuotaReport creates a handler reporting usage per key as JSON. Pass the
+// same options as to Quota to include limits; ?key= filters to one key.
+// Mount it behind authentication.
+func QuotaReport(store QuotaStore, opts ...QuotaOption) http.Handler {
+	options := newQuotaOptions(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		records, err := store.Records()
+		if err != nil {
+			options.logger.ErrorContext(r.Context(), "Quota store failed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		filter := r.URL.Query().Get("key")
+		now := time.Now()
+		entries := make([]QuotaReportEntry, 0, len(records))
+		for _, record := range records {
+			if filter != "" && record.Key != filter {
+				continue
+			}
+			entry := QuotaReportEntry{QuotaRecord: record}
+			for _, l := range options.limits {
+				if l.period.String() != record.Period {
+					continue
+				}
+				// Usage from an earlier period no longer counts
+				if record.Start.Before(l.period.Start(now)) {
+					entry.Used = 0
+				}
+				entry.Limit = l.limit
+				entry.Remaining = max(l.limit-entry.Used, 0)
+			}
+			entries = append(entries, entry)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	})
+}
@@ -0,0 +1,219 @@
+// pkg/middleware/quota_store.go
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vhellman/lw-router/internal/fsutil"
+)
+
+// MemoryQuotaStore keeps quota counters in memory. Usage is lost on restart.
+type MemoryQuotaStore struct {
+	mu      sync.Mutex
+	records map[string]*QuotaRecord
+}
+
+// NewMemoryQuotaStore creates an empty in-memory store
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{records: make(map[string]*QuotaRecord)}
+}
+
+// Consume implements QuotaStore
+func (s *MemoryQuotaStore) Consume(key string, charges []QuotaCharge) ([]int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used, ok := consumeRecords(s.records, key, charges)
+	return used, ok, nil
+}
+
+// Records implements QuotaStore
+func (s *MemoryQuotaStore) Records() ([]QuotaRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedRecords(s.records), nil
+}
+
+// FileQuotaStore keeps quota counters in memory and persists them to a
+// JSON file so they survive restarts. Changes are written in the
+// background at most once per flush interval, and counters of ended
+// periods are dropped when writing; call Close on shutdown to write the
+// final state.
+type FileQuotaStore struct {
+	mu       sync.Mutex
+	path     string
+	interval time.Duration
+	records  map[string]*QuotaRecord
+	dirty    bool
+	timer    *time.Timer
+	// writeMu orders writes so an older snapshot never replaces a newer one
+	writeMu sync.Mutex
+}
+
+// OpenFileQuotaStore loads the store at path, creating it if needed,
+// and flushes changes at most once per second
+func OpenFileQuotaStore(path string) (*FileQuotaStore, error) {
+	return OpenFileQuotaStoreWithInterval(path, time.Second)
+}
+
+// OpenFileQuotaStoreWithInterval is like OpenFileQuotaStore with a custom
+// flush interval. An interval of zero writes on every change.
+func OpenFileQuotaStoreWithInterval(path string, interval time.Duration) (*FileQuotaStore, error) {
+	s := &FileQuotaStore{
+		path:     path,
+		interval: interval,
+		records:  make(map[string]*QuotaRecord),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []QuotaRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	for i := range records {
+		s.records[recordKey(records[i].Key, records[i].Period)] = &records[i]
+	}
+	return s, nil
+}
+
+// Consume implements QuotaStore
+func (s *FileQuotaStore) Consume(key string, charges []QuotaCharge) ([]int64, bool, error) {
+	s.mu.Lock()
+	used, ok := consumeRecords(s.records, key, charges)
+	if !ok {
+		s.mu.Unlock()
+		return used, ok, nil
+	}
+
+	s.dirty = true
+	if s.interval > 0 {
+		if s.timer == nil {
+			s.timer = time.AfterFunc(s.interval, s.flushInBackground)
+		}
+		s.mu.Unlock()
+		return used, ok, nil
+	}
+	s.mu.Unlock()
+	return used, ok, s.Flush()
+}
+
+// Records implements QuotaStore
+func (s *FileQuotaStore) Records() ([]QuotaRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedRecords(s.records), nil
+}
+
+// Flush writes pending changes to disk
+func (s *FileQuotaStore) Flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	pruneRecords(s.records, time.Now())
+	data, err := json.MarshalIndent(sortedRecords(s.records), "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err == nil {
+		err = fsutil.WriteFileAtomic(s.path, data)
+	}
+	if err != nil {
+		// Keep the changes pending so the next flush retries them
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Close stops background writes and writes pending changes to disk
+func (s *FileQuotaStore) Close() error {
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mu.Unlock()
+	return s.Flush()
+}
+
+// flushInBackground runs from the flush timer. A failed write is retried
+// with the next change.
+func (s *FileQuotaStore) flushInBackground() {
+	s.mu.Lock()
+	s.timer = nil
+	s.mu.Unlock()
+	s.Flush()
+}
+
+func recordKey(key, period string) string {
+	return period + "|" + key
+}
+
+// consumeRecords implements Consume on a map of records. Every limit is
+// checked before any counter is changed.
+func consumeRecords(records map[string]*QuotaRecord, key string, charges []QuotaCharge) ([]int64, bool) {
+	current := make([]*QuotaRecord, len(charges))
+	used := make([]int64, len(charges))
+	ok := true
+	for i, c := range charges {
+		record := records[recordKey(key, c.Period.String())]
+		if record != nil && !record.Start.Before(c.Start) {
+			current[i] = record
+			used[i] = record.Used
+		}
+		ok = ok && used[i] < c.Limit
+	}
+	if !ok {
+		return used, false
+	}
+
+	for i, c := range charges {
+		if current[i] == nil {
+			current[i] = &QuotaRecord{Key: key, Period: c.Period.String(), Start: c.Start}
+			records[recordKey(key, c.Period.String())] = current[i]
+		}
+		current[i].Used++
+		used[i] = current[i].Used
+	}
+	return used, true
+}
+
+// pruneRecords drops the counters of periods that have ended
+func pruneRecords(records map[string]*QuotaRecord, now time.Time) {
+	for id, record := range records {
+		if !parseQuotaPeriod(record.Period).End(record.Start).After(now) {
+			delete(records, id)
+		}
+	}
+}
+
+func sortedRecords(records map[string]*QuotaRecord) []QuotaRecord {
+	result := make([]QuotaRecord, 0, len(records))
+	for _, record := range records {
+		result = append(result, *record)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Key != result[j].Key {
+			return result[i].Key < result[j].Key
+		}
+		return result[i].Period < result[j].Period
+	})
+	return result
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func TestQuotaPeriod(t *testing.T) {
+	now := time.Date(2024, time.February, 29, 15, 4, 5, 0, time.UTC)
+	if got := Daily.End(now); !got.Equal(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected end of day: %v", got)
+	}
+	if got := Monthly.Start(now); !got.Equal(time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected start of month: %v", got)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	var warnings []QuotaUsage
+	store := NewMemoryQuotaStore()
+	handler := Quota(store,
+		WithQuota(Monthly, 3),
+		WithQuota(Daily, 10),
+		WithQuotaKey(KeyByHeader("X-API-Key")),
+		WithQuotaWarning(0.5, func(u QuotaUsage) {
+			warnings = append(warnings, u)
+		}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertHeader("X-Quota-Limit", "3").
+		AssertHeader("X-Quota-Remaining", "2").
+		AssertHeaderPresent("X-Quota-Reset")
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler).AssertStatus(http.StatusOK)
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler).AssertHeader("X-Quota-Remaining", "0")
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler).
+		AssertStatus(http.StatusTooManyRequests).
+		AssertHeaderPresent("Retry-After").
+		AssertHeader("Content-Type", "application/problem+json").
+		AssertJSONPath("status", http.StatusTooManyRequests)
+
+	// Each key has its own quota
+	routertest.Get("/").Header("X-API-Key", "b").Do(t, handler).AssertStatus(http.StatusOK)
+
+	// 50% of the monthly quota is reached on the second request, 50% of the
+	// daily quota is never reached
+	if len(warnings) != 1 || warnings[0].Key != "X-API-Key:a" || warnings[0].Period != Monthly || warnings[0].Used != 2 {
+		t.Fatalf("Expected one monthly warning, got %+v", warnings)
+	}
+}
+
+func TestQuota_RejectionChargesNothing(t *testing.T) {
+	store := NewMemoryQuotaStore()
+	handler := Quota(store,
+		WithQuota(Monthly, 10),
+		WithQuota(Daily, 1),
+		WithQuotaKey(KeyByHeader("X-API-Key")),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler).AssertStatus(http.StatusOK)
+	for i := 0; i < 3; i++ {
+		routertest.Get("/").Header("X-API-Key", "a").Do(t, handler).
+			AssertStatus(http.StatusTooManyRequests).
+			AssertHeader("X-Quota-Limit", "1")
+	}
+
+	records, _ := store.Records()
+	for _, record := range records {
+		if record.Used != 1 {
+			t.Fatalf("Expected rejected requests not to be counted, got %+v", record)
+		}
+	}
+}
+
+func TestQuotaReport(t *testing.T) {
+	store := NewMemoryQuotaStore()
+	opts := []QuotaOption{WithQuota(Daily, 5), WithQuotaKey(KeyByHeader("X-API-Key"))}
+	handler := Quota(store, opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler)
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler)
+	routertest.Get("/").Header("X-API-Key", "b").Do(t, handler)
+
+	routertest.Get("/quotas").Do(t, QuotaReport(store, opts...)).
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("0.key", "X-API-Key:a").
+		AssertJSONPath("0.period", "daily").
+		AssertJSONPath("0.used", 2).
+		AssertJSONPath("0.remaining", 3).
+		AssertJSONPath("1.used", 1)
+
+	// Exhausted quotas still report their limit and remaining count
+	for i := 0; i < 3; i++ {
+		routertest.Get("/").Header("X-API-Key", "a").Do(t, handler)
+	}
+	routertest.Get("/quotas?key=X-API-Key:a").Do(t, QuotaReport(store, opts...)).
+		AssertJSONPath("0.limit", 5).
+		AssertJSONPath("0.remaining", 0)
+
+	routertest.Get("/quotas?key=X-API-Key:b").Do(t, QuotaReport(store, opts...)).
+		AssertJSONPath("0.key", "X-API-Key:b")
+}
+
+func TestFileQuotaStore_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	start := Monthly.Start(time.Now())
+
+	store, err := OpenFileQuotaStoreWithInterval(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	charge := []QuotaCharge{{Period: Monthly, Start: start, Limit: 100}}
+	for i := 0; i < 3; i++ {
+		store.Consume("k", charge)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+
+	reopened, err := OpenFileQuotaStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	used, ok, err := reopened.Consume("k", charge)
+	if err != nil || !ok || used[0] != 4 {
+		t.Fatalf("Expected usage to survive restart, got used=%d ok=%v err=%v", used, ok, err)
+	}
+
+	// A new period starts over
+	used, _, _ = reopened.Consume("k", []QuotaCharge{{Period: Monthly, Start: start.AddDate(0, 1, 0), Limit: 100}})
+	if used[0] != 1 {
+		t.Fatalf("Expected new period to reset usage, got %d", used)
+	}
+}
+
+func TestFileQuotaStore_PrunesEndedPeriods(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	now := time.Now()
+
+	store, err := OpenFileQuotaStoreWithInterval(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	store.Consume("old", []QuotaCharge{{Period: Daily, Start: Daily.Start(now).AddDate(0, 0, -2), Limit: 10}})
+	store.Consume("new", []QuotaCharge{{Period: Daily, Start: Daily.Start(now), Limit: 10}})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+
+	reopened, err := OpenFileQuotaStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	records, _ := reopened.Records()
+	if len(records) != 1 || records[0].Key != "new" {
+		t.Fatalf("Expected only the current period to be kept, got %+v", records)
+	}
+}
+
+func TestFileQuotaStore_FlushesInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	store, err := OpenFileQuotaStoreWithInterval(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	charge := []QuotaCharge{{Period: Daily, Start: Daily.Start(time.Now()), Limit: 10}}
+	store.Consume("k", charge)
+	store.Consume("k", charge)
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("Expected changes to be batched, got an immediate write")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if data, err := os.ReadFile(path); err == nil && strings.Contains(string(data), `"used": 2`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected changes to be written after the flush interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
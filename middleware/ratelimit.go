@@ -0,0 +1,227 @@
+// pkg/middleware/ratelimit.go
+package middleware
+
+/**
+ex usage:
+// 100 requests per minute per client IP
+router.Use(middleware.RateLimit(
+	middleware.WithRateLimit(100, time.Minute),
+))
+
+// Sliding window keyed by API key, falling back to client IP
+router.Use(middleware.RateLimit(
+	middleware.WithRateLimit(1000, time.Hour),
+	middleware.WithRateLimitAlgorithm(middleware.SlidingWindow),
+	middleware.WithRateLimitKey(middleware.KeyFirst(
+		middleware.KeyByHeader("X-API-Key"),
+		middleware.KeyByIP,
+	)),
+))
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitAlgorithm selects how requests are counted
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts up to the limit and refills continuously
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow approximates a rolling window from the current and
+	// previous fixed windows
+	SlidingWindow
+)
+
+// RateLimitPolicy describes a limit of Limit requests per Window
+type RateLimitPolicy struct {
+	Name      string
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Window    time.Duration
+}
+
+// RateLimitResult is the outcome of counting one request
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the counters for every key
+type RateLimitStore interface {
+	Allow(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+// KeyFunc derives the rate limit key from a request. An empty key means
+// the request is not limited.
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP keys requests by the client IP address
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader keys requests by the value of a header, e.g. an API key
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if value := r.Header.Get(name); value != "" {
+			return name + ":" + value
+		}
+		return ""
+	}
+}
+
+// KeyByUser keys requests by the authenticated user stored under UserIDKey
+func KeyByUser(r *http.Request) string {
+	if userID, ok := r.Context().Value(UserIDKey).(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	return ""
+}
+
+// KeyFirst uses the first key function that returns a non-empty key
+func KeyFirst(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		for _, fn := range fns {
+			if key := fn(r); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+type rateLimitOptions struct {
+	policy  RateLimitPolicy
+	keyFunc KeyFunc
+	store   RateLimitStore
+	logger  *slog.Logger
+}
+
+type RateLimitOption func(*rateLimitOptions)
+
+// WithRateLimit sets the number of requests allowed per window. Both
+// must be positive; RateLimit panics otherwise.
+func WithRateLimit(limit int, window time.Duration) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.policy.Limit = limit
+		o.policy.Window = window
+	}
+}
+
+// WithRateLimitAlgorithm sets the counting algorithm
+func WithRateLimitAlgorithm(algorithm RateLimitAlgorithm) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.policy.Algorithm = algorithm
+	}
+}
+
+// WithRateLimitName sets the policy name reported in the RateLimit headers
+func WithRateLimitName(name string) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.policy.Name = name
+	}
+}
+
+// WithRateLimitKey sets how requests are grouped
+func WithRateLimitKey(fn KeyFunc) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.keyFunc = fn
+	}
+}
+
+// WithRateLimitStore sets a custom counter store. Limiters with different
+// policies may share one store.
+func WithRateLimitStore(store RateLimitStore) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.store = store
+	}
+}
+
+// WithRateLimitLogger sets the logger used to report store errors
+func WithRateLimitLogger(logger *slog.Logger) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.logger = logger
+	}
+}
+
+// RateLimit creates a middleware that limits requests per key. Rejected
+// requests get 429 with Retry-After; every limited response carries the
+// IETF RateLimit-Policy and RateLimit headers.
+func RateLimit(opts ...RateLimitOption) func(http.Handler) http.Handler {
+	options := &rateLimitOptions{
+		policy: RateLimitPolicy{
+			Name:      "default",
+			Algorithm: TokenBucket,
+			Limit:     60,
+			Window:    time.Minute,
+		},
+		keyFunc: KeyByIP,
+		logger:  slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.store == nil {
+		options.store = NewMemoryRateLimitStore()
+	}
+
+	policy := options.policy
+	if policy.Limit <= 0 || policy.Window <= 0 {
+		panic(fmt.Sprintf("middleware: rate limit needs a positive limit and window, got %d per %v", policy.Limit, policy.Window))
+	}
+	policyHeader := fmt.Sprintf("%q;q=%d;w=%d", policy.Name, policy.Limit, ceilSeconds(policy.Window))
+	// Store keys carry the policy so limiters sharing a store keep
+	// separate counters
+	storePrefix := fmt.Sprintf("%q;%d;%d;%s|", policy.Name, policy.Algorithm, policy.Limit, policy.Window)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := options.keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := options.store.Allow(storePrefix+key, policy, time.Now())
+			if err != nil {
+				// Fail open so a broken store does not take the API down
+				options.logger.ErrorContext(r.Context(), "Rate limit store failed", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", policyHeader)
+			w.Header().Set("RateLimit", fmt.Sprintf("%q;r=%d;t=%d", policy.Name, result.Remaining, ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+				RenderProblem(w, r, Problem{
+					Status: http.StatusTooManyRequests,
+					Detail: "Rate limit exceeded",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,166 @@
+// pkg/middleware/ratelimit_store.go
+package middleware
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const rateLimitShards = 32
+
+// MemoryRateLimitStore is an in-memory RateLimitStore. Keys are spread over
+// shards to reduce lock contention, and keys that have been idle long
+// enough to be fully replenished are evicted.
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+}
+
+type rateLimitEntry struct {
+	// Token bucket state
+	tokens float64
+	last   time.Time
+
+	// Sliding window state
+	windowStart time.Time
+	current     int
+	previous    int
+
+	expires time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateLimitEntry)
+	}
+	return s
+}
+
+// Allow implements RateLimitStore
+func (s *MemoryRateLimitStore) Allow(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.sweep(now, policy.Window)
+
+	entry, ok := shard.entries[key]
+	if !ok {
+		entry = &rateLimitEntry{tokens: float64(policy.Limit), last: now}
+		shard.entries[key] = entry
+	}
+	// After two windows both algorithms have fully recovered, so the
+	// entry can be dropped without changing any outcome
+	entry.expires = now.Add(2 * policy.Window)
+
+	if policy.Algorithm == SlidingWindow {
+		return entry.slidingWindow(policy, now), nil
+	}
+	return entry.tokenBucket(policy, now), nil
+}
+
+// Len returns the number of tracked keys
+func (s *MemoryRateLimitStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+		n += len(s.shards[i].entries)
+		s.shards[i].mu.Unlock()
+	}
+	return n
+}
+
+func (s *MemoryRateLimitStore) shard(key string) *rateLimitShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &s.shards[h.Sum32()%rateLimitShards]
+}
+
+// sweep evicts expired entries at most once per window
+func (sh *rateLimitShard) sweep(now time.Time, window time.Duration) {
+	if now.Sub(sh.lastSweep) < window {
+		return
+	}
+	sh.lastSweep = now
+	for key, entry := range sh.entries {
+		if now.After(entry.expires) {
+			delete(sh.entries, key)
+		}
+	}
+}
+
+func (e *rateLimitEntry) tokenBucket(policy RateLimitPolicy, now time.Time) RateLimitResult {
+	rate := float64(policy.Limit) / policy.Window.Seconds()
+	e.tokens = math.Min(float64(policy.Limit), e.tokens+now.Sub(e.last).Seconds()*rate)
+	e.last = now
+
+	result := RateLimitResult{}
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - e.tokens) / rate)
+	}
+	result.Remaining = int(e.tokens)
+	result.Reset = seconds((float64(policy.Limit) - e.tokens) / rate)
+	return result
+}
+
+func (e *rateLimitEntry) slidingWindow(policy RateLimitPolicy, now time.Time) RateLimitResult {
+	start := now.Truncate(policy.Window)
+	if !start.Equal(e.windowStart) {
+		if start.Sub(e.windowStart) == policy.Window {
+			e.previous = e.current
+		} else {
+			e.previous = 0
+		}
+		e.current = 0
+		e.windowStart = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(policy.Window)
+	estimate := float64(e.previous)*weight + float64(e.current)
+	limit := float64(policy.Limit)
+
+	result := RateLimitResult{Reset: policy.Window - elapsed}
+	if estimate+1 <= limit {
+		e.current++
+		estimate++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = e.slidingRetryAfter(policy, elapsed)
+	}
+	result.Remaining = max(int(limit-math.Ceil(estimate)), 0)
+	return result
+}
+
+// slidingRetryAfter estimates when the weighted count drops far enough
+// to admit one more request
+func (e *rateLimitEntry) slidingRetryAfter(policy RateLimitPolicy, elapsed time.Duration) time.Duration {
+	limit := float64(policy.Limit)
+	window := float64(policy.Window)
+
+	if float64(e.current) < limit && e.previous > 0 {
+		// Wait for the previous window's weight to decay
+		x := 1 - (limit-1-float64(e.current))/float64(e.previous)
+		return max(time.Duration(x*window)-elapsed, 0)
+	}
+
+	// The current window is full; it becomes the previous one next window
+	x := 1 - (limit-1)/float64(e.current)
+	return policy.Window - elapsed + time.Duration(x*window)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func TestRateLimit_TokenBucket(t *testing.T) {
+	handler := RateLimit(WithRateLimit(2, time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertHeader("RateLimit-Policy", `"default";q=2;w=60`).
+		AssertHeader("RateLimit", `"default";r=1;t=30`)
+	routertest.Get("/").Do(t, handler).AssertStatus(http.StatusOK)
+
+	resp := routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusTooManyRequests).
+		AssertHeaderPresent("Retry-After").
+		AssertHeader("Content-Type", "application/problem+json").
+		AssertJSONPath("detail", "Rate limit exceeded")
+	if retry, _ := strconv.Atoi(resp.Result.Header.Get("Retry-After")); retry < 29 || retry > 30 {
+		t.Fatalf("Expected Retry-After around 30s, got %d", retry)
+	}
+
+	// A different client has its own bucket
+	req := routertest.Get("/").Build(t)
+	req.RemoteAddr = "10.0.0.2:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestRateLimit_KeyByHeader(t *testing.T) {
+	handler := RateLimit(
+		WithRateLimit(1, time.Minute),
+		WithRateLimitName("api"),
+		WithRateLimitKey(KeyByHeader("X-API-Key")),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler).AssertStatus(http.StatusOK)
+	routertest.Get("/").Header("X-API-Key", "a").Do(t, handler).
+		AssertStatus(http.StatusTooManyRequests).
+		AssertHeader("RateLimit-Policy", `"api";q=1;w=60`)
+	routertest.Get("/").Header("X-API-Key", "b").Do(t, handler).AssertStatus(http.StatusOK)
+
+	// Requests without a key are not limited
+	routertest.Get("/").Do(t, handler).AssertStatus(http.StatusOK).AssertHeaderAbsent("RateLimit")
+	routertest.Get("/").Do(t, handler).AssertStatus(http.StatusOK)
+}
+
+func TestRateLimit_KeyByUser(t *testing.T) {
+	key := KeyFirst(KeyByUser, KeyByIP)
+	user := routertest.Get("/").ContextValue(UserIDKey, "alice").Build(t)
+	if got := key(user); got != "user:alice" {
+		t.Fatalf("Expected user key, got %q", got)
+	}
+	anonymous := routertest.Get("/").Build(t)
+	if got := key(anonymous); got != "192.0.2.1" {
+		t.Fatalf("Expected IP key, got %q", got)
+	}
+}
+
+func TestMemoryRateLimitStore_TokenBucketRefill(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Algorithm: TokenBucket, Limit: 10, Window: 10 * time.Second}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 10; i++ {
+		if res, _ := store.Allow("k", policy, now); !res.Allowed {
+			t.Fatalf("Expected request %d to be allowed", i)
+		}
+	}
+	res, _ := store.Allow("k", policy, now)
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Fatalf("Expected denial with 1s retry, got %+v", res)
+	}
+
+	// One token per second
+	res, _ = store.Allow("k", policy, now.Add(time.Second))
+	if !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("Expected refilled token to be allowed, got %+v", res)
+	}
+}
+
+func TestMemoryRateLimitStore_SlidingWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Algorithm: SlidingWindow, Limit: 4, Window: time.Minute}
+	start := time.Unix(600, 0) // aligned to the minute
+
+	for i := 0; i < 4; i++ {
+		store.Allow("k", policy, start)
+	}
+	res, _ := store.Allow("k", policy, start.Add(10*time.Second))
+	if res.Allowed || res.Reset != 50*time.Second {
+		t.Fatalf("Expected denial in full window, got %+v", res)
+	}
+
+	// Halfway into the next window the previous window counts for half
+	mid := start.Add(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if res, _ := store.Allow("k", policy, mid); !res.Allowed {
+			t.Fatalf("Expected request %d to be allowed, got %+v", i, res)
+		}
+	}
+	res, _ = store.Allow("k", policy, mid)
+	if res.Allowed || res.RetryAfter != 15*time.Second {
+		t.Fatalf("Expected denial with 15s retry, got %+v", res)
+	}
+}
+
+func TestMemoryRateLimitStore_EvictsIdleKeys(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Limit: 1, Window: time.Second}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 100; i++ {
+		store.Allow(strconv.Itoa(i), policy, now)
+	}
+	if store.Len() != 100 {
+		t.Fatalf("Expected 100 keys, got %d", store.Len())
+	}
+
+	// Touching each shard after the keys expired sweeps them
+	later := now.Add(time.Minute)
+	for i := 100; i < 400; i++ {
+		store.Allow(strconv.Itoa(i), policy, later)
+	}
+	if store.Len() != 300 {
+		t.Fatalf("Expected idle keys to be evicted, got %d keys", store.Len())
+	}
+}
+
+func TestMemoryRateLimitStore_Concurrent(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Limit: 50, Window: time.Hour}
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, _ := store.Allow("shared", policy, now); res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 50 {
+		t.Fatalf("Expected exactly 50 allowed requests, got %d", allowed)
+	}
+}
+
+func TestRateLimit_InvalidPolicyPanics(t *testing.T) {
+	for _, opt := range []RateLimitOption{
+		WithRateLimit(0, time.Minute),
+		WithRateLimit(-1, time.Minute),
+		WithRateLimit(10, 0),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Expected RateLimit to panic on an invalid policy")
+				}
+			}()
+			RateLimit(opt)
+		}()
+	}
+}
+
+func TestRateLimit_SharedStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	strict := RateLimit(WithRateLimit(1, time.Minute), WithRateLimitStore(store))(ok)
+	loose := RateLimit(WithRateLimit(10, time.Minute), WithRateLimitStore(store))(ok)
+
+	routertest.Get("/").Do(t, strict).AssertStatus(http.StatusOK)
+	routertest.Get("/").Do(t, strict).AssertStatus(http.StatusTooManyRequests)
+
+	// The loose policy keeps its own bucket for the same client
+	routertest.Get("/").Do(t, loose).
+		AssertStatus(http.StatusOK).
+		AssertHeader("RateLimit", `"default";r=9;t=6`)
+	if store.Len() != 2 {
+		t.Fatalf("Expected 2 keys, got %d", store.Len())
+	}
+}
@@ -0,0 +1,179 @@
+// pkg/middleware/replay.go
+package middleware
+
+/**
+ex usage:
+// Clients send a fresh X-Nonce and the current unix time in X-Timestamp,
+// both covered by their message signature
+router.Use(
+	middleware.VerifyMessageSignature(partners,
+		middleware.WithRequiredComponents("@method", "@target-uri", "x-nonce", "x-timestamp"),
+	),
+	middleware.ReplayProtection(
+		middleware.WithReplayWindow(2*time.Minute),
+		middleware.WithNonceStore(sharedStore),
+	),
+)
+*/
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// NonceStore remembers nonces that have been used. Shared backends let
+// several instances reject replays sent to any of them.
+type NonceStore interface {
+	// Add records nonce for scope, the principal ID, until expires. It
+	// returns false if the nonce is already recorded for the scope and
+	// has not expired. Use NonceKey to combine both into one key.
+	Add(scope, nonce string, expires, now time.Time) (bool, error)
+}
+
+type replayOptions struct {
+	nonceHeader     string
+	timestampHeader string
+	window          time.Duration
+	store           NonceStore
+	logger          *slog.Logger
+	now             func() time.Time
+}
+
+type ReplayOption func(*replayOptions)
+
+// WithNonceHeader sets the header carrying the nonce, X-Nonce by default
+func WithNonceHeader(name string) ReplayOption {
+	return func(o *replayOptions) {
+		o.nonceHeader = name
+	}
+}
+
+// WithTimestampHeader sets the header carrying the request time in unix
+// seconds, X-Timestamp by default
+func WithTimestampHeader(name string) ReplayOption {
+	return func(o *replayOptions) {
+		o.timestampHeader = name
+	}
+}
+
+// WithReplayWindow sets how far the timestamp may be from now, five
+// minutes by default. Nonces are remembered until their timestamp leaves
+// the window.
+func WithReplayWindow(d time.Duration) ReplayOption {
+	return func(o *replayOptions) {
+		o.window = d
+	}
+}
+
+// WithNonceStore sets the store of used nonces. The default is an
+// in-memory store holding 100000 nonces, 1000 per principal.
+func WithNonceStore(store NonceStore) ReplayOption {
+	return func(o *replayOptions) {
+		o.store = store
+	}
+}
+
+// WithReplayLogger sets the logger used to report store errors
+func WithReplayLogger(logger *slog.Logger) ReplayOption {
+	return func(o *replayOptions) {
+		o.logger = logger
+	}
+}
+
+// ReplayProtection creates a middleware that rejects replayed requests.
+// Every request needs a timestamp within the window and a nonce that has
+// not been used before by the principal. It must run after
+// authentication; requests without a principal get 401.
+//
+// Missing or stale values get 400, replays get 409 and an audit event.
+// A principal holding too many live nonces gets 429, and if the store
+// fails the request is refused with 503.
+func ReplayProtection(opts ...ReplayOption) func(http.Handler) http.Handler {
+	options := &replayOptions{
+		nonceHeader:     "X-Nonce",
+		timestampHeader: "X-Timestamp",
+		window:          5 * time.Minute,
+		logger:          slog.Default(),
+		now:             time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.store == nil {
+		options.store = NewMemoryNonceStore(100000, 1000)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFrom(r.Context())
+			if !ok {
+				AuditEvent(r, "Replay rejected", "reason", "no principal")
+				RenderProblem(w, r, Problem{
+					Status: http.StatusUnauthorized,
+					Detail: "Authentication required",
+				})
+				return
+			}
+
+			nonce := r.Header.Get(options.nonceHeader)
+			if nonce == "" || len(nonce) > 256 {
+				RenderProblem(w, r, Problem{
+					Status: http.StatusBadRequest,
+					Detail: "Missing or invalid " + options.nonceHeader + " header",
+				})
+				return
+			}
+			unix, err := strconv.ParseInt(r.Header.Get(options.timestampHeader), 10, 64)
+			if err != nil {
+				RenderProblem(w, r, Problem{
+					Status: http.StatusBadRequest,
+					Detail: "Missing or invalid " + options.timestampHeader + " header",
+				})
+				return
+			}
+
+			now := options.now()
+			timestamp := time.Unix(unix, 0)
+			if timestamp.Before(now.Add(-options.window)) || timestamp.After(now.Add(options.window)) {
+				AuditEvent(r, "Replay rejected", "reason", "timestamp outside window", "timestamp", unix)
+				RenderProblem(w, r, Problem{
+					Status: http.StatusBadRequest,
+					Detail: "Request timestamp outside the allowed window",
+				})
+				return
+			}
+
+			fresh, err := options.store.Add(p.ID, nonce, timestamp.Add(options.window), now)
+			if errors.Is(err, ErrNonceScopeFull) {
+				AuditEvent(r, "Replay rejected", "reason", "too many nonces")
+				RenderProblem(w, r, Problem{
+					Status: http.StatusTooManyRequests,
+					Detail: "Too many requests in the replay window",
+				})
+				return
+			}
+			if err != nil {
+				options.logger.ErrorContext(r.Context(), "Nonce store failed", "error", err)
+				RenderProblem(w, r, Problem{
+					Status: http.StatusServiceUnavailable,
+					Detail: "Replay protection unavailable",
+				})
+				return
+			}
+			if !fresh {
+				AuditEvent(r, "Replay rejected", "reason", "nonce reused", "nonce", nonce)
+				RenderProblem(w, r, Problem{
+					Status: http.StatusConflict,
+					Detail: "Request has already been processed",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// pkg/middleware/replay_store.go
+package middleware
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNonceStoreFull is returned when a bounded store has no room for a
+// nonce that is still within its replay window
+var ErrNonceStoreFull = errors.New("nonce store full")
+
+// ErrNonceScopeFull is returned when one scope holds as many live nonces
+// as a bounded store allows per scope
+var ErrNonceScopeFull = errors.New("nonce scope full")
+
+// MemoryNonceStore is an in-memory NonceStore holding at most a fixed
+// number of nonces, and a smaller number per scope so one principal
+// cannot fill it. Expired nonces are evicted first; when every stored
+// nonce is still live, new ones are refused rather than evicting a nonce
+// that could then be replayed.
+type MemoryNonceStore struct {
+	mu       sync.Mutex
+	max      int
+	perScope int
+	nonces   map[string]time.Time
+	scopes   map[string]int
+	expires  nonceHeap
+}
+
+type nonceEntry struct {
+	key     string
+	scope   string
+	expires time.Time
+}
+
+// nonceHeap orders nonces by expiry, soonest first
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h nonceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x any)        { *h = append(*h, x.(nonceEntry)) }
+func (h *nonceHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// NewMemoryNonceStore creates a store holding at most max nonces, and
+// at most perScope of them for any one scope. It panics unless both are
+// positive and perScope is at most max.
+func NewMemoryNonceStore(max, perScope int) *MemoryNonceStore {
+	if max <= 0 || perScope <= 0 || perScope > max {
+		panic(fmt.Sprintf("middleware: nonce store needs 0 < perScope <= max, got %d per scope of %d", perScope, max))
+	}
+	return &MemoryNonceStore{
+		max:      max,
+		perScope: perScope,
+		nonces:   make(map[string]time.Time),
+		scopes:   make(map[string]int),
+	}
+}
+
+// Add implements NonceStore
+func (s *MemoryNonceStore) Add(scope, nonce string, expires, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.expires) > 0 && !s.expires[0].expires.After(now) {
+		entry := heap.Pop(&s.expires).(nonceEntry)
+		delete(s.nonces, entry.key)
+		if s.scopes[entry.scope]--; s.scopes[entry.scope] == 0 {
+			delete(s.scopes, entry.scope)
+		}
+	}
+
+	key := NonceKey(scope, nonce)
+	if _, seen := s.nonces[key]; seen {
+		return false, nil
+	}
+	if s.scopes[scope] >= s.perScope {
+		return false, ErrNonceScopeFull
+	}
+	if len(s.nonces) >= s.max {
+		return false, ErrNonceStoreFull
+	}
+	s.nonces[key] = expires
+	s.scopes[scope]++
+	heap.Push(&s.expires, nonceEntry{key, scope, expires})
+	return true, nil
+}
+
+// NonceKey joins scope and nonce into one key. The scope is length
+// prefixed, so no two scope and nonce pairs share a key.
+func NonceKey(scope, nonce string) string {
+	return strconv.Itoa(len(scope)) + ":" + scope + nonce
+}
+
+// Len returns the number of stored nonces
+func (s *MemoryNonceStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.nonces)
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func TestReplayProtection(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(authenticate(ReplayProtection()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	routertest.Post("/").Header("X-Nonce", "n-1").Header("X-Timestamp", now).Do(t, handler).AssertStatus(http.StatusOK)
+	routertest.Post("/").Header("X-Nonce", "n-2").Header("X-Timestamp", now).Do(t, handler).AssertStatus(http.StatusOK)
+	routertest.Post("/").Header("X-Nonce", "n-1").Header("X-Timestamp", now).Do(t, handler).AssertStatus(http.StatusConflict)
+
+	record, ok := recorder.Find("Replay rejected")
+	if !ok || record.Attrs["reason"] != "nonce reused" || record.Attrs["nonce"] != "n-1" {
+		t.Fatalf("Expected replay audit event, got %v", recorder.Records())
+	}
+
+	stale := strconv.FormatInt(time.Now().Add(-6*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(6*time.Minute).Unix(), 10)
+	routertest.Post("/").Header("X-Nonce", "n-3").Header("X-Timestamp", stale).Do(t, handler).AssertStatus(http.StatusBadRequest)
+	routertest.Post("/").Header("X-Nonce", "n-3").Header("X-Timestamp", future).Do(t, handler).AssertStatus(http.StatusBadRequest)
+	routertest.Post("/").Header("X-Timestamp", now).Do(t, handler).AssertStatus(http.StatusBadRequest)
+	routertest.Post("/").Header("X-Nonce", "n-3").Do(t, handler).AssertStatus(http.StatusBadRequest)
+	routertest.Post("/").Header("X-Nonce", "n-3").Header("X-Timestamp", "yesterday").Do(t, handler).AssertStatus(http.StatusBadRequest)
+}
+
+func TestReplayProtection_Options(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	handler := authenticate(ReplayProtection(
+		WithNonceHeader("Idempotency-Nonce"),
+		WithTimestampHeader("Request-Time"),
+		WithReplayWindow(time.Minute),
+		func(o *replayOptions) { o.now = func() time.Time { return clock } },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	send := func(nonce string, at time.Time) *routertest.Response {
+		return routertest.Post("/").
+			Header("Idempotency-Nonce", nonce).
+			Header("Request-Time", strconv.FormatInt(at.Unix(), 10)).
+			Do(t, handler)
+	}
+
+	send("a", clock).AssertStatus(http.StatusOK)
+	send("a", clock).AssertStatus(http.StatusConflict)
+
+	// Once the timestamp has left the window the nonce may be forgotten,
+	// because the old request would now be rejected as stale
+	clock = clock.Add(2 * time.Minute)
+	send("a", clock.Add(-2*time.Minute)).AssertStatus(http.StatusBadRequest)
+	send("a", clock).AssertStatus(http.StatusOK)
+}
+
+func TestReplayProtection_ScopedToPrincipal(t *testing.T) {
+	handler := ReplayProtection()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	send := func(id string) *routertest.Response {
+		return routertest.Post("/").
+			Header("X-Nonce", "shared").
+			Header("X-Timestamp", now).
+			ContextValue(PrincipalKey, &Principal{ID: id}).
+			Do(t, handler)
+	}
+
+	send("alice").AssertStatus(http.StatusOK)
+	send("bob").AssertStatus(http.StatusOK)
+	send("alice").AssertStatus(http.StatusConflict)
+}
+
+func TestReplayProtection_RequiresPrincipal(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	store := NewMemoryNonceStore(10, 10)
+	handler := Audit(WithLogger(recorder.Logger()))(ReplayProtection(WithNonceStore(store))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	routertest.Post("/").
+		Header("X-Nonce", "a").
+		Header("X-Timestamp", strconv.FormatInt(time.Now().Unix(), 10)).
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+
+	if record, ok := recorder.Find("Replay rejected"); !ok || record.Attrs["reason"] != "no principal" {
+		t.Fatalf("Expected audit event, got %v", recorder.Records())
+	}
+	if store.Len() != 0 {
+		t.Fatalf("Expected no stored nonces, got %d", store.Len())
+	}
+}
+
+func TestReplayProtection_PrincipalLimit(t *testing.T) {
+	handler := ReplayProtection(WithNonceStore(NewMemoryNonceStore(10, 2)))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	send := func(id, nonce string) *routertest.Response {
+		return routertest.Post("/").
+			Header("X-Nonce", nonce).
+			Header("X-Timestamp", now).
+			ContextValue(PrincipalKey, &Principal{ID: id}).
+			Do(t, handler)
+	}
+
+	send("alice", "a").AssertStatus(http.StatusOK)
+	send("alice", "b").AssertStatus(http.StatusOK)
+	send("alice", "c").AssertStatus(http.StatusTooManyRequests)
+	send("bob", "c").AssertStatus(http.StatusOK)
+}
+
+type failingNonceStore struct{}
+
+func (failingNonceStore) Add(string, string, time.Time, time.Time) (bool, error) {
+	return false, errors.New("unavailable")
+}
+
+func TestReplayProtection_StoreError(t *testing.T) {
+	handler := authenticate(ReplayProtection(WithNonceStore(failingNonceStore{}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	routertest.Post("/").
+		Header("X-Nonce", "a").
+		Header("X-Timestamp", strconv.FormatInt(time.Now().Unix(), 10)).
+		Do(t, handler).
+		AssertStatus(http.StatusServiceUnavailable)
+}
+
+func TestMemoryNonceStore_Bounded(t *testing.T) {
+	store := NewMemoryNonceStore(2, 1)
+	now := time.Now()
+
+	store.Add("a", "a", now.Add(time.Second), now)
+	store.Add("b", "b", now.Add(time.Minute), now)
+	if _, err := store.Add("c", "c", now.Add(time.Minute), now); !errors.Is(err, ErrNonceStoreFull) {
+		t.Fatalf("Expected ErrNonceStoreFull while all nonces are live, got %v", err)
+	}
+	if fresh, err := store.Add("b", "b", now.Add(time.Minute), now); fresh || err != nil {
+		t.Fatalf("Expected duplicate to be reported, got %v %v", fresh, err)
+	}
+
+	// The expired nonce makes room
+	later := now.Add(2 * time.Second)
+	if fresh, err := store.Add("c", "c", later.Add(time.Minute), later); !fresh || err != nil {
+		t.Fatalf("Expected expired nonce to be evicted, got %v %v", fresh, err)
+	}
+	if fresh, _ := store.Add("a", "a", later.Add(time.Minute), later); fresh {
+		t.Fatal("Expected store to be full again")
+	}
+	if store.Len() != 2 {
+		t.Fatalf("Expected 2 nonces, got %d", store.Len())
+	}
+}
+
+func TestMemoryNonceStore_InvalidLimitsPanics(t *testing.T) {
+	for _, limits := range [][2]int{{0, 1}, {10, 0}, {-1, -1}, {1, 2}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Expected NewMemoryNonceStore(%d, %d) to panic", limits[0], limits[1])
+				}
+			}()
+			NewMemoryNonceStore(limits[0], limits[1])
+		}()
+	}
+}
+
+func TestMemoryNonceStore_ScopeLimit(t *testing.T) {
+	store := NewMemoryNonceStore(10, 1)
+	now := time.Now()
+
+	store.Add("alice", "a", now.Add(time.Second), now)
+	if _, err := store.Add("alice", "b", now.Add(time.Minute), now); !errors.Is(err, ErrNonceScopeFull) {
+		t.Fatalf("Expected ErrNonceScopeFull, got %v", err)
+	}
+	if fresh, err := store.Add("bob", "b", now.Add(time.Minute), now); !fresh || err != nil {
+		t.Fatalf("Expected other scope to have room, got %v %v", fresh, err)
+	}
+
+	// The expired nonce frees the scope
+	later := now.Add(2 * time.Second)
+	if fresh, err := store.Add("alice", "b", later.Add(time.Minute), later); !fresh || err != nil {
+		t.Fatalf("Expected expired nonce to free the scope, got %v %v", fresh, err)
+	}
+}
+
+func TestNonceKey(t *testing.T) {
+	if NonceKey("a|b", "c") == NonceKey("a", "b|c") {
+		t.Fatal("Expected distinct keys for distinct scopes")
+	}
+}
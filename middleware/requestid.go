@@ -14,6 +14,7 @@ const DefaultRequestIDHeader = "X-Request-ID"
 type requestIDOptions struct {
 	headerName string
 	contextKey ContextKey
+	generator  func() (string, error)
 }
 
 type RequestIDOption func(*requestIDOptions)
@@ -32,6 +33,13 @@ func WithContextKey(key ContextKey) RequestIDOption {
 	}
 }
 
+// WithIDGenerator sets a custom function for generating request IDs
+func WithIDGenerator(fn func() (string, error)) RequestIDOption {
+	return func(o *requestIDOptions) {
+		o.generator = fn
+	}
+}
+
 // generateUUID generates a UUID using crypto/rand
 func generateUUID() (string, error) {
 	uuid := make([]byte, 16)
@@ -53,6 +61,7 @@ func RequestID(opts ...RequestIDOption) func(http.Handler) http.Handler {
 	options := &requestIDOptions{
 		headerName: DefaultRequestIDHeader,
 		contextKey: RequestIDKey,
+		generator:  generateUUID,
 	}
 
 	for _, opt := range opts {
@@ -65,7 +74,7 @@ func RequestID(opts ...RequestIDOption) func(http.Handler) http.Handler {
 			requestID := r.Header.Get(options.headerName)
 			if requestID == "" {
 				var err error
-				requestID, err = generateUUID()
+				requestID, err = options.generator()
 				if err != nil {
 					// If UUID generation fails, use timestamp or another fallback
 					requestID = fmt.Sprintf("fallback-%d", time.Now().UnixNano())
@@ -5,10 +5,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/vhellman/lw-router/routertest"
 )
 
 const testHeaderName = "X-Test-Request-ID"
-const testContextKey = "test-request-id"
+const testContextKey ContextKey = "test-request-id"
 
 func TestRequestID_Default(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -89,3 +91,18 @@ func TestRequestID_CustomContextKey(t *testing.T) {
 		t.Fatalf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
 	}
 }
+
+func TestRequestID_WithIDGenerator(t *testing.T) {
+	var seen []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Context().Value(RequestIDKey).(string))
+	})
+
+	chain := RequestID(WithIDGenerator(routertest.SequentialIDs("req")))(handler)
+	routertest.Get("/").Do(t, chain).AssertHeader(DefaultRequestIDHeader, "req-1")
+	routertest.Get("/").Do(t, chain).AssertHeader(DefaultRequestIDHeader, "req-2")
+
+	if len(seen) != 2 || seen[0] != "req-1" || seen[1] != "req-2" {
+		t.Fatalf("Expected sequential request IDs in context, got %v", seen)
+	}
+}
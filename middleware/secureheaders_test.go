@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func TestSecureHeaders_Defaults(t *testing.T) {
+	handler := SecureHeaders()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	routertest.Get("/").Do(t, handler).
+		AssertHeader("Strict-Transport-Security", "max-age=63072000; includeSubDomains").
+		AssertHeader("X-Content-Type-Options", "nosniff").
+		AssertHeader("X-Frame-Options", "DENY").
+		AssertHeader("Referrer-Policy", "strict-origin-when-cross-origin").
+		AssertHeader("Cross-Origin-Opener-Policy", "same-origin").
+		AssertHeader("Cross-Origin-Resource-Policy", "same-origin").
+		AssertHeaderAbsent("Cross-Origin-Embedder-Policy").
+		AssertHeaderAbsent("Content-Security-Policy")
+}
+
+func TestSecureHeaders_Options(t *testing.T) {
+	handler := SecureHeaders(
+		WithHSTS(365*24*time.Hour, true, true),
+		WithFrameOptions(""),
+		WithReferrerPolicy("no-referrer"),
+		WithPermissionsPolicy("camera=(), geolocation=()"),
+		WithCrossOriginPolicies("same-origin", "require-corp", "cross-origin"),
+		WithCSP(NewCSP().DefaultSrc("'self'").ImgSrc("'self'", "data:")),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CSPNonce(r.Context()) != "" {
+			t.Error("Expected no nonce for a policy without NonceSource")
+		}
+	}))
+
+	routertest.Get("/").Do(t, handler).
+		AssertHeader("Strict-Transport-Security", "max-age=31536000; includeSubDomains; preload").
+		AssertHeaderAbsent("X-Frame-Options").
+		AssertHeader("Referrer-Policy", "no-referrer").
+		AssertHeader("Permissions-Policy", "camera=(), geolocation=()").
+		AssertHeader("Cross-Origin-Embedder-Policy", "require-corp").
+		AssertHeader("Cross-Origin-Resource-Policy", "cross-origin").
+		AssertHeader("Content-Security-Policy", "default-src 'self'; img-src 'self' data:")
+}
+
+func TestSecureHeaders_Nonce(t *testing.T) {
+	var nonces []string
+	csp := NewCSP().ScriptSrc("'self'", NonceSource).ReportURI("/csp-report")
+	handler := SecureHeaders(WithCSP(csp), WithCSPReportOnly())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, CSPNonce(r.Context()))
+	}))
+
+	first := routertest.Get("/").Do(t, handler).AssertHeaderAbsent("Content-Security-Policy")
+	routertest.Get("/").Do(t, handler)
+
+	if nonces[0] == "" || nonces[0] == nonces[1] {
+		t.Fatalf("Expected a fresh nonce per request, got %v", nonces)
+	}
+	want := "script-src 'self' 'nonce-" + nonces[0] + "'; report-uri /csp-report"
+	if got := first.Result.Header.Get("Content-Security-Policy-Report-Only"); got != want {
+		t.Fatalf("Expected policy %q, got %q", want, got)
+	}
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := CSPReportHandler(recorder.Logger())
+
+	routertest.Post("/csp-report").
+		Header("Content-Type", "application/csp-report").
+		Body(`{"csp-report":{"document-uri":"https://app.test/","violated-directive":"script-src","blocked-uri":"https://evil.test/x.js","line-number":3}}`).
+		Do(t, handler).
+		AssertStatus(http.StatusNoContent)
+
+	routertest.Post("/csp-report").
+		Header("Content-Type", "application/reports+json").
+		Body(`[{"type":"csp-violation","body":{"documentURL":"https://app.test/a","effectiveDirective":"img-src","blockedURL":"data"}},{"type":"deprecation","body":{}}]`).
+		Do(t, handler).
+		AssertStatus(http.StatusNoContent)
+
+	records := recorder.Records()
+	if len(records) != 2 {
+		t.Fatalf("Expected two violations to be logged, got %v", records)
+	}
+	if records[0].Attrs["directive"] != "script-src" || records[0].Attrs["blocked"] != "https://evil.test/x.js" {
+		t.Fatalf("Unexpected legacy report record: %v", records[0].Attrs)
+	}
+	if records[1].Attrs["directive"] != "img-src" || records[1].Attrs["document"] != "https://app.test/a" {
+		t.Fatalf("Unexpected Reporting API record: %v", records[1].Attrs)
+	}
+
+	routertest.Post("/csp-report").Body("not json").Do(t, handler).AssertStatus(http.StatusBadRequest)
+	routertest.Get("/csp-report").Do(t, handler).AssertStatus(http.StatusMethodNotAllowed)
+}
+
+func TestCSP_String(t *testing.T) {
+	csp := NewCSP().DefaultSrc("'none'").Directive("upgrade-insecure-requests").DefaultSrc("'self'")
+	if got := csp.String(); !strings.HasPrefix(got, "default-src 'none' 'self'; upgrade-insecure-requests") {
+		t.Fatalf("Unexpected policy: %q", got)
+	}
+
+	csp = NewCSP().ScriptSrc("'self'", NonceSource)
+	if got, want := csp.String(), "script-src 'self' "+NonceSource; got != want {
+		t.Fatalf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,342 @@
+// pkg/middleware/structuredfield.go
+package middleware
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This file implements the parts of RFC 8941 structured fields used by
+// message signatures and Content-Digest: dictionaries whose members are
+// items or inner lists, with parameters. Values are string, int64,
+// []byte, bool, or sfToken for tokens.
+
+type sfToken string
+
+type sfParam struct {
+	Name  string
+	Value any
+}
+
+type sfParams []sfParam
+
+// Get returns the value of a parameter
+func (p sfParams) Get(name string) (any, bool) {
+	for _, param := range p {
+		if param.Name == name {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+type sfItem struct {
+	Value  any
+	Params sfParams
+}
+
+// sfMember is a dictionary member, either an item or an inner list
+type sfMember struct {
+	Item      sfItem
+	InnerList []sfItem
+	IsList    bool
+	Params    sfParams
+}
+
+var errStructuredField = errors.New("malformed structured field")
+
+type sfParser struct {
+	s   string
+	pos int
+}
+
+// parseDictionary parses a dictionary, returning the member names in order
+func parseDictionary(s string) (map[string]sfMember, []string, error) {
+	p := &sfParser{s: s}
+	members := make(map[string]sfMember)
+	var order []string
+
+	p.skipSpaces()
+	for !p.done() {
+		name, err := p.key()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var member sfMember
+		if p.peek() == '=' {
+			p.pos++
+			if member, err = p.member(); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			// A bare key is the boolean true
+			params, err := p.params()
+			if err != nil {
+				return nil, nil, err
+			}
+			member = sfMember{Item: sfItem{Value: true, Params: params}}
+		}
+		if _, seen := members[name]; !seen {
+			order = append(order, name)
+		}
+		members[name] = member
+
+		p.skipOWS()
+		if p.done() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, nil, errStructuredField
+		}
+		p.pos++
+		p.skipOWS()
+		if p.done() {
+			return nil, nil, errStructuredField
+		}
+	}
+	return members, order, nil
+}
+
+func (p *sfParser) done() bool { return p.pos >= len(p.s) }
+
+func (p *sfParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *sfParser) skipSpaces() {
+	for !p.done() && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.done() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *sfParser) member() (sfMember, error) {
+	if p.peek() != '(' {
+		item, err := p.item()
+		return sfMember{Item: item}, err
+	}
+
+	p.pos++
+	var items []sfItem
+	for {
+		p.skipSpaces()
+		if p.peek() == ')' {
+			p.pos++
+			params, err := p.params()
+			return sfMember{InnerList: items, IsList: true, Params: params}, err
+		}
+		if p.done() {
+			return sfMember{}, errStructuredField
+		}
+		item, err := p.item()
+		if err != nil {
+			return sfMember{}, err
+		}
+		items = append(items, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return sfMember{}, errStructuredField
+		}
+	}
+}
+
+func (p *sfParser) item() (sfItem, error) {
+	value, err := p.bareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	params, err := p.params()
+	return sfItem{Value: value, Params: params}, err
+}
+
+func (p *sfParser) params() (sfParams, error) {
+	var params sfParams
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSpaces()
+		name, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var value any = true
+		if p.peek() == '=' {
+			p.pos++
+			if value, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, sfParam{name, value})
+	}
+	return params, nil
+}
+
+func (p *sfParser) key() (string, error) {
+	start := p.pos
+	if c := p.peek(); !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", errStructuredField
+	}
+	for !p.done() {
+		c := p.s[p.pos]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && !strings.ContainsRune("_-.*", rune(c)) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *sfParser) bareItem() (any, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.string()
+	case c == ':':
+		end := strings.IndexByte(p.s[p.pos+1:], ':')
+		if end < 0 {
+			return nil, errStructuredField
+		}
+		b, err := base64.StdEncoding.DecodeString(p.s[p.pos+1 : p.pos+1+end])
+		if err != nil {
+			return nil, errStructuredField
+		}
+		p.pos += end + 2
+		return b, nil
+	case c == '?':
+		if p.pos+1 >= len(p.s) || (p.s[p.pos+1] != '0' && p.s[p.pos+1] != '1') {
+			return nil, errStructuredField
+		}
+		p.pos += 2
+		return p.s[p.pos-1] == '1', nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for !p.done() && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(p.s[start:p.pos], 10, 64)
+		if err != nil {
+			return nil, errStructuredField
+		}
+		return n, nil
+	case c == '*' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		start := p.pos
+		for !p.done() && !strings.ContainsRune(" ;,()=\"\t", rune(p.s[p.pos])) {
+			p.pos++
+		}
+		return sfToken(p.s[start:p.pos]), nil
+	}
+	return nil, errStructuredField
+}
+
+func (p *sfParser) string() (string, error) {
+	var b strings.Builder
+	for p.pos++; !p.done(); p.pos++ {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\':
+			p.pos++
+			if p.done() || (p.s[p.pos] != '"' && p.s[p.pos] != '\\') {
+				return "", errStructuredField
+			}
+			b.WriteByte(p.s[p.pos])
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", errStructuredField
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errStructuredField
+}
+
+// serializeBareItem writes a value in structured field syntax
+func serializeBareItem(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case string:
+		b.WriteByte('"')
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v))
+		b.WriteByte('"')
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	case sfToken:
+		b.WriteString(string(v))
+	default:
+		panic(fmt.Sprintf("structured field: unsupported value %T", v))
+	}
+}
+
+func serializeParams(b *strings.Builder, params sfParams) {
+	for _, param := range params {
+		b.WriteByte(';')
+		b.WriteString(param.Name)
+		if v, ok := param.Value.(bool); ok && v {
+			continue
+		}
+		b.WriteByte('=')
+		serializeBareItem(b, param.Value)
+	}
+}
+
+func serializeItem(b *strings.Builder, item sfItem) {
+	serializeBareItem(b, item.Value)
+	serializeParams(b, item.Params)
+}
+
+func serializeInnerList(items []sfItem, params sfParams) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, item := range items {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		serializeItem(&b, item)
+	}
+	b.WriteByte(')')
+	serializeParams(&b, params)
+	return b.String()
+}
+
+// serializeByteDictionary writes a dictionary of byte sequences in key
+// order, as used by Content-Digest and Signature
+func serializeByteDictionary(values map[string][]byte) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		serializeBareItem(&b, values[k])
+	}
+	return b.String()
+}
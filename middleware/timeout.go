@@ -0,0 +1,261 @@
+// pkg/middleware/timeout.go
+package middleware
+
+/**
+ex usage:
+router.Use(middleware.Timeout(5*time.Second,
+	middleware.WithTimeoutStatus(http.StatusGatewayTimeout),
+	middleware.WithRouteTimeout("GET /exports/{id}", 5*time.Minute),
+	middleware.WithRouteTimeout("GET /events", 0), // no timeout for SSE
+))
+*/
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type timeoutOptions struct {
+	status int
+	routes *http.ServeMux
+	byPath map[string]time.Duration
+}
+
+type TimeoutOption func(*timeoutOptions)
+
+// WithTimeoutStatus sets the status returned on timeout, 503 by default
+func WithTimeoutStatus(code int) TimeoutOption {
+	return func(o *timeoutOptions) {
+		o.status = code
+	}
+}
+
+// WithRouteTimeout overrides the timeout for requests matching a
+// http.ServeMux pattern. A duration of zero disables the timeout.
+func WithRouteTimeout(pattern string, d time.Duration) TimeoutOption {
+	return func(o *timeoutOptions) {
+		o.routes.Handle(pattern, http.NotFoundHandler())
+		o.byPath[pattern] = d
+	}
+}
+
+// timeoutFor returns the timeout for the request
+func (o *timeoutOptions) timeoutFor(r *http.Request, d time.Duration) time.Duration {
+	if len(o.byPath) == 0 {
+		return d
+	}
+	if _, pattern := o.routes.Handler(r); pattern != "" {
+		if override, ok := o.byPath[pattern]; ok {
+			return override
+		}
+	}
+	return d
+}
+
+// Timeout creates a middleware that sets a context deadline on the request.
+// If the handler has not started writing by the deadline, a problem
+// response is sent and later writes from the handler fail with
+// http.ErrHandlerTimeout. Handlers that have started streaming keep the
+// connection and are expected to stop when the context is done.
+//
+// Unlike http.TimeoutHandler the response is not buffered, so streaming,
+// trailers and http.ResponseController flushes and write deadlines keep
+// working. Hijacking is not supported; give such routes no timeout.
+func Timeout(d time.Duration, opts ...TimeoutOption) func(http.Handler) http.Handler {
+	options := &timeoutOptions{
+		status: http.StatusServiceUnavailable,
+		routes: http.NewServeMux(),
+		byPath: make(map[string]time.Duration),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := options.timeoutFor(r, d)
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				tw.finish(ctx)
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				wrote := tw.wroteHeader
+				tw.mu.Unlock()
+				if wrote || ctx.Err() == nil {
+					return
+				}
+				// The handler gave up at the deadline without responding
+			case <-ctx.Done():
+			}
+
+			tw.mu.Lock()
+			if tw.wroteHeader {
+				// The response is streaming; let the handler finish it
+				tw.mu.Unlock()
+				select {
+				case p := <-panicked:
+					panic(p)
+				case <-done:
+				}
+				return
+			}
+			tw.timedOut = true
+			tw.mu.Unlock()
+
+			// A panic in the abandoned handler can no longer reach the
+			// server, so log it instead of losing it
+			go func() {
+				select {
+				case p := <-panicked:
+					log.Printf("PANIC after timeout: %v", p)
+				case <-done:
+				}
+			}()
+
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The client went away, there is nobody to respond to
+				return
+			}
+			RenderProblem(w, r, Problem{
+				Status: options.status,
+				Detail: "The request did not complete within " + timeout.String(),
+			})
+		})
+	}
+}
+
+// timeoutWriter guards the response so the abandoned handler cannot write
+// after the timeout response has been sent. Headers are kept separately
+// until the handler commits to a response.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	w           http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	dst := tw.w.Header()
+	for key, values := range tw.header {
+		dst[key] = values
+	}
+	tw.w.WriteHeader(code)
+
+	// Informational responses are followed by the real one
+	if code >= 200 {
+		tw.wroteHeader = true
+	}
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.FlushError()
+}
+
+// FlushError lets http.ResponseController flush through the guard
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	return http.NewResponseController(tw.w).Flush()
+}
+
+func (tw *timeoutWriter) SetReadDeadline(deadline time.Time) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	return http.NewResponseController(tw.w).SetReadDeadline(deadline)
+}
+
+func (tw *timeoutWriter) SetWriteDeadline(deadline time.Time) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	return http.NewResponseController(tw.w).SetWriteDeadline(deadline)
+}
+
+// finish runs when the handler returns. It sends headers the handler set
+// without writing, unless ctx is done and the timeout response should be
+// sent instead, and trailers set after the header was written.
+func (tw *timeoutWriter) finish(ctx context.Context) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || (!tw.wroteHeader && ctx.Err() != nil) {
+		return
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+
+	dst := tw.w.Header()
+	for _, names := range tw.header.Values("Trailer") {
+		for _, name := range strings.Split(names, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if values, ok := tw.header[name]; ok {
+				dst[name] = values
+			}
+		}
+	}
+	for key, values := range tw.header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			dst[key] = values
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func TestTimeout_Completes(t *testing.T) {
+	handler := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("Expected context deadline")
+		}
+		w.Header().Set("X-Handler", "done")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusCreated).
+		AssertHeader("X-Handler", "done")
+}
+
+func TestTimeout_Expires(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	handler := Timeout(10*time.Millisecond, WithTimeoutStatus(http.StatusGatewayTimeout))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			time.Sleep(10 * time.Millisecond)
+			w.Header().Set("X-Late", "true")
+			_, err := w.Write([]byte("late"))
+			lateWrite <- err
+		}),
+	)
+
+	routertest.Get("/slow").Do(t, handler).
+		AssertStatus(http.StatusGatewayTimeout).
+		AssertHeader("Content-Type", "application/problem+json").
+		AssertHeaderAbsent("X-Late").
+		AssertJSONPath("status", http.StatusGatewayTimeout).
+		AssertJSONPath("title", "Gateway Timeout")
+
+	if err := <-lateWrite; err != http.ErrHandlerTimeout {
+		t.Fatalf("Expected late write to fail with ErrHandlerTimeout, got %v", err)
+	}
+}
+
+func TestTimeout_Streaming(t *testing.T) {
+	handler := Logger(Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+
+		// The deadline passes while streaming; the handler finishes up
+		<-r.Context().Done()
+		w.Write([]byte("last"))
+	})))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || string(body) != "first last" {
+		t.Fatalf("Expected streamed response to complete, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestTimeout_RouteOverride(t *testing.T) {
+	handler := Timeout(10*time.Millisecond,
+		WithRouteTimeout("GET /exports/{id}", time.Second),
+		WithRouteTimeout("/events", 0),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		if r.URL.Path == "/events" {
+			if ok {
+				t.Error("Expected no deadline for /events")
+			}
+			return
+		}
+		if !ok || time.Until(deadline) < 500*time.Millisecond {
+			t.Errorf("Expected extended deadline, got %v", time.Until(deadline))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+
+	routertest.Get("/exports/1").Do(t, handler).AssertStatus(http.StatusOK)
+	routertest.Get("/events").Do(t, handler).AssertStatus(http.StatusOK)
+}
+
+func TestTimeout_Panic(t *testing.T) {
+	handler := Recoverer(Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	routertest.CaptureLog(t)
+	routertest.Get("/").Do(t, handler).AssertStatus(http.StatusInternalServerError)
+}
+
+func TestTimeout_ErrorRenderer(t *testing.T) {
+	renderer := func(w http.ResponseWriter, r *http.Request, p Problem) {
+		http.Error(w, "custom: "+p.Detail, p.Status)
+	}
+	handler := RenderErrors(renderer)(Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})))
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertBody("custom: The request did not complete within 10ms\n")
+}
+
+func TestTimeout_ResponseControllerAfterTimeout(t *testing.T) {
+	errs := make(chan error, 2)
+	handler := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		rc := http.NewResponseController(w)
+		errs <- rc.Flush()
+		errs <- rc.SetWriteDeadline(time.Now().Add(time.Second))
+	}))
+
+	routertest.Get("/").Do(t, handler).AssertStatus(http.StatusServiceUnavailable)
+
+	for range 2 {
+		if err := <-errs; err != http.ErrHandlerTimeout {
+			t.Fatalf("Expected ErrHandlerTimeout, got %v", err)
+		}
+	}
+}
+
+func TestTimeout_Trailers(t *testing.T) {
+	handler := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("body"))
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Errorf("Expected write deadline to be set, got %v", err)
+		}
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Extra", "def")
+	}))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.ReadAll(resp.Body)
+
+	if resp.Trailer.Get("X-Checksum") != "abc" || resp.Trailer.Get("X-Extra") != "def" {
+		t.Fatalf("Expected trailers, got %v", resp.Trailer)
+	}
+}
+
+func TestTimeout_HeadersWithoutBody(t *testing.T) {
+	handler := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "done")
+	}))
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertHeader("X-Handler", "done")
+}
@@ -0,0 +1,249 @@
+// pkg/middleware/webhook.go
+package middleware
+
+/**
+ex usage:
+router.Route("POST /webhooks/github", middleware.WebhookSignature(middleware.GitHub, githubSecret)(githubHandler))
+router.Route("POST /webhooks/stripe", middleware.WebhookSignature(middleware.Stripe, stripeSecret)(stripeHandler))
+
+// Any vendor signing hex HMAC-SHA256 over "timestamp.body"
+vendor := middleware.GenericHMAC("X-Signature", "X-Signature-Timestamp")
+router.Route("POST /webhooks/vendor", middleware.WebhookSignature(vendor, secret,
+	middleware.WithWebhookTolerance(time.Minute),
+)(vendorHandler))
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrWebhookSignature = errors.New("webhook signature does not match")
+	ErrWebhookTimestamp = errors.New("webhook timestamp outside tolerance")
+)
+
+// WebhookProvider describes how a vendor signs webhook requests
+type WebhookProvider struct {
+	Name string
+	// Verify checks the request signature over body
+	Verify func(header http.Header, body, secret []byte, now time.Time, tolerance time.Duration) error
+}
+
+// GitHub verifies X-Hub-Signature-256: sha256=<hex HMAC of the body>
+var GitHub = WebhookProvider{
+	Name: "github",
+	Verify: func(header http.Header, body, secret []byte, _ time.Time, _ time.Duration) error {
+		signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return errors.New("missing X-Hub-Signature-256")
+		}
+		return checkHexHMAC(secret, body, signature)
+	},
+}
+
+// Stripe verifies Stripe-Signature: t=<unix>,v1=<hex HMAC of "t.body">,
+// accepting any of several v1 signatures
+var Stripe = WebhookProvider{
+	Name: "stripe",
+	Verify: func(header http.Header, body, secret []byte, now time.Time, tolerance time.Duration) error {
+		var timestamp string
+		var signatures []string
+		for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case "v1":
+				signatures = append(signatures, value)
+			}
+		}
+		if timestamp == "" || len(signatures) == 0 {
+			return errors.New("malformed Stripe-Signature")
+		}
+		if err := checkTimestamp(timestamp, now, tolerance); err != nil {
+			return err
+		}
+
+		payload := append([]byte(timestamp+"."), body...)
+		for _, signature := range signatures {
+			if checkHexHMAC(secret, payload, signature) == nil {
+				return nil
+			}
+		}
+		return ErrWebhookSignature
+	},
+}
+
+// Slack verifies X-Slack-Signature: v0=<hex HMAC of "v0:timestamp:body">
+// with the timestamp from X-Slack-Request-Timestamp
+var Slack = WebhookProvider{
+	Name: "slack",
+	Verify: func(header http.Header, body, secret []byte, now time.Time, tolerance time.Duration) error {
+		timestamp := header.Get("X-Slack-Request-Timestamp")
+		signature, ok := strings.CutPrefix(header.Get("X-Slack-Signature"), "v0=")
+		if timestamp == "" || !ok {
+			return errors.New("missing Slack signature headers")
+		}
+		if err := checkTimestamp(timestamp, now, tolerance); err != nil {
+			return err
+		}
+		return checkHexHMAC(secret, append([]byte("v0:"+timestamp+":"), body...), signature)
+	},
+}
+
+// GenericHMAC verifies a hex HMAC-SHA256 in signatureHeader, optionally
+// prefixed with "sha256=". With a timestampHeader the signed payload is
+// "<unix timestamp>.<body>" and the timestamp must be within tolerance;
+// otherwise it is the body alone.
+func GenericHMAC(signatureHeader, timestampHeader string) WebhookProvider {
+	return WebhookProvider{
+		Name: "hmac",
+		Verify: func(header http.Header, body, secret []byte, now time.Time, tolerance time.Duration) error {
+			signature := strings.TrimPrefix(header.Get(signatureHeader), "sha256=")
+			if signature == "" {
+				return errors.New("missing " + signatureHeader)
+			}
+			if timestampHeader == "" {
+				return checkHexHMAC(secret, body, signature)
+			}
+
+			timestamp := header.Get(timestampHeader)
+			if err := checkTimestamp(timestamp, now, tolerance); err != nil {
+				return err
+			}
+			return checkHexHMAC(secret, append([]byte(timestamp+"."), body...), signature)
+		},
+	}
+}
+
+// checkHexHMAC compares a hex signature in constant time
+func checkHexHMAC(secret, payload []byte, signature string) error {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+// checkTimestamp checks a Unix timestamp is within tolerance of now
+func checkTimestamp(timestamp string, now time.Time, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed webhook timestamp")
+	}
+	diff := now.Sub(time.Unix(seconds, 0))
+	if diff > tolerance || diff < -tolerance {
+		return ErrWebhookTimestamp
+	}
+	return nil
+}
+
+type webhookOptions struct {
+	tolerance time.Duration
+	maxBody   int64
+	secrets   [][]byte
+	now       func() time.Time
+}
+
+type WebhookOption func(*webhookOptions)
+
+// WithWebhookTolerance sets how far timestamps may be from now, five
+// minutes by default
+func WithWebhookTolerance(d time.Duration) WebhookOption {
+	return func(o *webhookOptions) {
+		o.tolerance = d
+	}
+}
+
+// WithWebhookMaxBody sets the largest accepted body, 1 MiB by default
+func WithWebhookMaxBody(n int64) WebhookOption {
+	return func(o *webhookOptions) {
+		o.maxBody = n
+	}
+}
+
+// WithWebhookSecrets also accepts signatures made with older secrets
+// while a vendor rotates them. WebhookSignature panics on an empty one.
+func WithWebhookSecrets(secrets ...[]byte) WebhookOption {
+	return func(o *webhookOptions) {
+		o.secrets = append(o.secrets, secrets...)
+	}
+}
+
+// WebhookSignature creates a middleware that verifies webhook requests
+// signed by provider. The body is buffered for verification and restored
+// for the handler. Invalid signatures get 401 and an audit event, bodies
+// over the limit 413. It panics on an empty secret, since anyone can
+// compute an HMAC under an empty key.
+func WebhookSignature(provider WebhookProvider, secret []byte, opts ...WebhookOption) func(http.Handler) http.Handler {
+	options := &webhookOptions{
+		tolerance: 5 * time.Minute,
+		maxBody:   1 << 20,
+		now:       time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+	secrets := append([][]byte{secret}, options.secrets...)
+	for _, s := range secrets {
+		if len(s) == 0 {
+			panic("middleware: empty " + provider.Name + " webhook secret")
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(io.LimitReader(r.Body, options.maxBody+1))
+			r.Body.Close()
+			if err != nil {
+				RenderProblem(w, r, Problem{
+					Status: http.StatusBadRequest,
+					Detail: "Could not read request body",
+				})
+				return
+			}
+			if int64(len(body)) > options.maxBody {
+				RenderProblem(w, r, Problem{
+					Status: http.StatusRequestEntityTooLarge,
+					Detail: "Webhook body too large",
+				})
+				return
+			}
+
+			now := options.now()
+			for _, s := range secrets {
+				err = provider.Verify(r.Header, body, s, now, options.tolerance)
+				if err == nil || errors.Is(err, ErrWebhookTimestamp) {
+					break
+				}
+			}
+			if err != nil {
+				AuditEvent(r, "Webhook signature rejected", "provider", provider.Name, "reason", err.Error())
+				RenderProblem(w, r, Problem{
+					Status: http.StatusUnauthorized,
+					Detail: "Invalid webhook signature",
+				})
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func hexHMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// bodyEcho writes the request body back, proving it was restored
+var bodyEcho = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.Copy(w, r.Body)
+})
+
+func TestWebhookSignature_Providers(t *testing.T) {
+	body := `{"event":"push"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+	cases := []struct {
+		name     string
+		provider WebhookProvider
+		headers  map[string]string
+		status   int
+	}{
+		{"github", GitHub, map[string]string{"X-Hub-Signature-256": "sha256=" + hexHMAC("secret", body)}, http.StatusOK},
+		{"github wrong secret", GitHub, map[string]string{"X-Hub-Signature-256": "sha256=" + hexHMAC("other", body)}, http.StatusUnauthorized},
+		{"github missing", GitHub, nil, http.StatusUnauthorized},
+		{"stripe", Stripe, map[string]string{
+			"Stripe-Signature": "t=" + now + ",v1=" + hexHMAC("other", now+"."+body) + ",v1=" + hexHMAC("secret", now+"."+body),
+		}, http.StatusOK},
+		{"stripe stale", Stripe, map[string]string{
+			"Stripe-Signature": "t=" + stale + ",v1=" + hexHMAC("secret", stale+"."+body),
+		}, http.StatusUnauthorized},
+		{"slack", Slack, map[string]string{
+			"X-Slack-Request-Timestamp": now,
+			"X-Slack-Signature":         "v0=" + hexHMAC("secret", "v0:"+now+":"+body),
+		}, http.StatusOK},
+		{"slack tampered timestamp", Slack, map[string]string{
+			"X-Slack-Request-Timestamp": now,
+			"X-Slack-Signature":         "v0=" + hexHMAC("secret", "v0:"+stale+":"+body),
+		}, http.StatusUnauthorized},
+		{"generic", GenericHMAC("X-Signature", ""), map[string]string{"X-Signature": hexHMAC("secret", body)}, http.StatusOK},
+		{"generic timestamped", GenericHMAC("X-Signature", "X-Timestamp"), map[string]string{
+			"X-Timestamp": now,
+			"X-Signature": "sha256=" + hexHMAC("secret", now+"."+body),
+		}, http.StatusOK},
+		{"generic stale", GenericHMAC("X-Signature", "X-Timestamp"), map[string]string{
+			"X-Timestamp": stale,
+			"X-Signature": hexHMAC("secret", stale+"."+body),
+		}, http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		req := routertest.Post("/webhook").Body(body)
+		for k, v := range tc.headers {
+			req.Header(k, v)
+		}
+		resp := req.Do(t, WebhookSignature(tc.provider, []byte("secret"))(bodyEcho))
+		if resp.Result.StatusCode != tc.status {
+			t.Fatalf("%s: Expected status %d, got %d", tc.name, tc.status, resp.Result.StatusCode)
+		}
+		if tc.status == http.StatusOK && resp.Body() != body {
+			t.Fatalf("%s: Expected the body to be restored, got %q", tc.name, resp.Body())
+		}
+	}
+}
+
+func TestWebhookSignature_Options(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	handler := Audit(WithLogger(recorder.Logger()))(WebhookSignature(GitHub, []byte("new"),
+		WithWebhookSecrets([]byte("old")),
+		WithWebhookMaxBody(16),
+	)(bodyEcho))
+
+	routertest.Post("/").Body("{}").
+		Header("X-Hub-Signature-256", "sha256="+hexHMAC("old", "{}")).
+		Do(t, handler).
+		AssertStatus(http.StatusOK)
+
+	routertest.Post("/").Body(strings.Repeat("x", 17)).
+		Header("X-Hub-Signature-256", "sha256="+hexHMAC("new", strings.Repeat("x", 17))).
+		Do(t, handler).
+		AssertStatus(http.StatusRequestEntityTooLarge)
+
+	routertest.Post("/").Body("{}").
+		Header("X-Hub-Signature-256", "sha256=zz").
+		Do(t, handler).
+		AssertStatus(http.StatusUnauthorized)
+	record, ok := recorder.Find("Webhook signature rejected")
+	if !ok || record.Attrs["provider"] != "github" {
+		t.Fatalf("Expected an audit event naming the provider, got %v", record.Attrs)
+	}
+}
+
+// signWebhook returns the headers provider expects for body signed with
+// secret at timestamp
+func signWebhook(provider, secret, body, timestamp string) map[string]string {
+	switch provider {
+	case "github":
+		return map[string]string{"X-Hub-Signature-256": "sha256=" + hexHMAC(secret, body)}
+	case "stripe":
+		return map[string]string{"Stripe-Signature": "t=" + timestamp + ",v1=" + hexHMAC(secret, timestamp+"."+body)}
+	case "slack":
+		return map[string]string{
+			"X-Slack-Request-Timestamp": timestamp,
+			"X-Slack-Signature":         "v0=" + hexHMAC(secret, "v0:"+timestamp+":"+body),
+		}
+	}
+	return map[string]string{"X-Timestamp": timestamp, "X-Signature": hexHMAC(secret, timestamp+"."+body)}
+}
+
+var webhookProviders = []WebhookProvider{GitHub, Stripe, Slack, GenericHMAC("X-Signature", "X-Timestamp")}
+
+func sendWebhook(t *testing.T, handler http.Handler, body string, headers map[string]string) *routertest.Response {
+	t.Helper()
+	req := routertest.Post("/webhook").Body(body)
+	for k, v := range headers {
+		req.Header(k, v)
+	}
+	return req.Do(t, handler)
+}
+
+func TestWebhookSignature_TamperedBody(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, provider := range webhookProviders {
+		handler := WebhookSignature(provider, []byte("secret"))(bodyEcho)
+		headers := signWebhook(provider.Name, "secret", `{"amount":1}`, now)
+
+		sendWebhook(t, handler, `{"amount":1}`, headers).AssertStatus(http.StatusOK)
+		if resp := sendWebhook(t, handler, `{"amount":1000}`, headers); resp.Result.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%s: Expected a tampered body to be rejected, got %d", provider.Name, resp.Result.StatusCode)
+		}
+	}
+}
+
+func TestWebhookSignature_ExpiredTimestamp(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	for _, provider := range webhookProviders[1:] {
+		handler := WebhookSignature(provider, []byte("new"),
+			WithWebhookSecrets([]byte("old")),
+			WithWebhookTolerance(time.Minute),
+			func(o *webhookOptions) { o.now = func() time.Time { return clock } },
+		)(bodyEcho)
+
+		for offset, status := range map[time.Duration]int{
+			0:                 http.StatusOK,
+			59 * time.Second:  http.StatusOK,
+			-61 * time.Second: http.StatusUnauthorized,
+			61 * time.Second:  http.StatusUnauthorized,
+		} {
+			timestamp := strconv.FormatInt(clock.Add(offset).Unix(), 10)
+			// A valid signature with an old secret does not revive an expired timestamp
+			for _, secret := range []string{"new", "old"} {
+				resp := sendWebhook(t, handler, "{}", signWebhook(provider.Name, secret, "{}", timestamp))
+				if resp.Result.StatusCode != status {
+					t.Fatalf("%s %v %s: Expected status %d, got %d", provider.Name, offset, secret, status, resp.Result.StatusCode)
+				}
+			}
+		}
+	}
+}
+
+func TestWebhookSignature_RotatedSecrets(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, provider := range webhookProviders {
+		handler := WebhookSignature(provider, []byte("current"), WithWebhookSecrets([]byte("previous"), []byte("oldest")))(bodyEcho)
+
+		for secret, status := range map[string]int{
+			"current":  http.StatusOK,
+			"previous": http.StatusOK,
+			"oldest":   http.StatusOK,
+			"retired":  http.StatusUnauthorized,
+		} {
+			resp := sendWebhook(t, handler, "{}", signWebhook(provider.Name, secret, "{}", now))
+			if resp.Result.StatusCode != status {
+				t.Fatalf("%s %s: Expected status %d, got %d", provider.Name, secret, status, resp.Result.StatusCode)
+			}
+		}
+	}
+}
+
+func TestWebhookSignature_EmptySecretPanics(t *testing.T) {
+	for name, build := range map[string]func(){
+		"secret":     func() { WebhookSignature(GitHub, nil) },
+		"empty":      func() { WebhookSignature(Stripe, []byte{}) },
+		"old secret": func() { WebhookSignature(Slack, []byte("secret"), WithWebhookSecrets([]byte("old"), nil)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s: Expected an empty secret to panic", name)
+				}
+			}()
+			build()
+		}()
+	}
+}
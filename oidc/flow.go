@@ -0,0 +1,373 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vhellman/lw-router/middleware"
+	"github.com/vhellman/lw-router/session"
+)
+
+// Session keys. Pending login state is removed once the callback
+// completes. The identity is kept as the mapped principal, the subject
+// and the expiry, so it fits in a cookie; the refresh token is only kept
+// in confidential sessions.
+const (
+	keyState    = "oidc.state"
+	keyVerifier = "oidc.verifier"
+	keyNonce    = "oidc.nonce"
+	keyReturn   = "oidc.return"
+
+	keyPrincipal = "oidc.principal"
+	keySubject   = "oidc.subject"
+	keyRefresh   = "oidc.refresh_token"
+	keyExpiry    = "oidc.expiry"
+)
+
+// tokenResponse is the token endpoint response
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("oidc: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// requireSession returns the request session, rejecting the request if
+// the session middleware is missing
+func requireSession(w http.ResponseWriter, r *http.Request) (*session.Session, bool) {
+	s, ok := session.From(r.Context())
+	if !ok {
+		middleware.RenderProblem(w, r, middleware.Problem{
+			Status: http.StatusInternalServerError,
+			Detail: "oidc requires the session middleware",
+		})
+	}
+	return s, ok
+}
+
+// Middleware authenticates requests from the identity in the session,
+// refreshing expired tokens. Unauthenticated GET requests are redirected
+// to the provider; others get 401.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := requireSession(w, r)
+		if !ok {
+			return
+		}
+
+		principal, ok := storedPrincipal(s)
+		if ok && a.expired(s) {
+			if err := a.refresh(r.Context(), s); err != nil {
+				middleware.AuditEvent(r, "OIDC refresh failed", "error", err.Error())
+				clearIdentity(s)
+				ok = false
+			} else {
+				principal, ok = storedPrincipal(s)
+			}
+		}
+		if !ok {
+			a.login(w, r, s)
+			return
+		}
+
+		r = r.WithContext(middleware.WithPrincipal(r.Context(), principal))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Authenticator) expired(s *session.Session) bool {
+	expiry, _ := s.Get(keyExpiry).(float64)
+	return !a.now().Add(a.options.refreshLeeway).Before(time.Unix(int64(expiry), 0))
+}
+
+// storedPrincipal returns the principal saved at login
+func storedPrincipal(s *session.Session) (*middleware.Principal, bool) {
+	data := s.GetString(keyPrincipal)
+	if data == "" {
+		return nil, false
+	}
+	var p middleware.Principal
+	if err := json.Unmarshal([]byte(data), &p); err != nil || p.ID == "" {
+		return nil, false
+	}
+	return &p, true
+}
+
+func clearIdentity(s *session.Session) {
+	for _, key := range []string{keyPrincipal, keySubject, keyRefresh, keyExpiry} {
+		s.Delete(key)
+	}
+}
+
+// login starts the authorization code flow
+func (a *Authenticator) login(w http.ResponseWriter, r *http.Request, s *session.Session) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oidc"`)
+		middleware.RenderProblem(w, r, middleware.Problem{
+			Status: http.StatusUnauthorized,
+			Detail: "Login required",
+		})
+		return
+	}
+
+	state, verifier, nonce := randomString(), randomString(), randomString()
+	s.Set(keyState, state)
+	s.Set(keyVerifier, verifier)
+	s.Set(keyNonce, nonce)
+	s.Set(keyReturn, r.URL.RequestURI())
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.clientID},
+		"redirect_uri":          {a.options.redirectURL},
+		"scope":                 {a.scope()},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, withQuery(a.provider.AuthorizationEndpoint, query), http.StatusFound)
+}
+
+func (a *Authenticator) scope() string {
+	scopes := []string{"openid"}
+	for _, scope := range a.options.scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+func withQuery(endpoint string, query url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + query.Encode()
+	}
+	return endpoint + "?" + query.Encode()
+}
+
+// Callback returns the handler for the redirect URL. It checks the state,
+// exchanges the code, verifies the ID token and nonce, stores the
+// identity under a renewed session ID and returns to the original page.
+func (a *Authenticator) Callback() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := requireSession(w, r)
+		if !ok {
+			return
+		}
+
+		state, _ := s.Get(keyState).(string)
+		verifier, _ := s.Get(keyVerifier).(string)
+		nonce, _ := s.Get(keyNonce).(string)
+		returnTo, _ := s.Get(keyReturn).(string)
+		for _, key := range []string{keyState, keyVerifier, keyNonce, keyReturn} {
+			s.Delete(key)
+		}
+
+		query := r.URL.Query()
+		if state == "" || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+			a.reject(w, r, http.StatusBadRequest, "Invalid login state", "state mismatch")
+			return
+		}
+		if errCode := query.Get("error"); errCode != "" {
+			a.reject(w, r, http.StatusUnauthorized, "Login failed", errCode+": "+query.Get("error_description"))
+			return
+		}
+
+		tokens, err := a.token(r.Context(), url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {query.Get("code")},
+			"redirect_uri":  {a.options.redirectURL},
+			"code_verifier": {verifier},
+		})
+		if err != nil {
+			a.reject(w, r, http.StatusBadGateway, "Login failed", err.Error())
+			return
+		}
+
+		claims, err := a.verifyIDToken(r.Context(), tokens.IDToken)
+		if err != nil {
+			a.reject(w, r, http.StatusUnauthorized, "Login failed", err.Error())
+			return
+		}
+		if got := claims.String("nonce"); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+			a.reject(w, r, http.StatusUnauthorized, "Login failed", "nonce mismatch")
+			return
+		}
+		principal := a.options.principal(claims)
+		if principal == nil {
+			a.reject(w, r, http.StatusForbidden, "Login failed", "claims map to no principal")
+			return
+		}
+
+		s.RenewID()
+		expiry, _ := claims.Time("exp")
+		a.storeIdentity(s, tokens, claims.Subject(), principal, expiry)
+
+		if returnTo == "" || !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+			returnTo = "/"
+		}
+		http.Redirect(w, r, returnTo, http.StatusFound)
+	})
+}
+
+func (a *Authenticator) reject(w http.ResponseWriter, r *http.Request, status int, detail, reason string) {
+	middleware.AuditEvent(r, "OIDC login failed", "reason", reason)
+	middleware.RenderProblem(w, r, middleware.Problem{Status: status, Detail: detail})
+}
+
+func (a *Authenticator) verifyIDToken(ctx context.Context, idToken string) (middleware.JWTClaims, error) {
+	if idToken == "" {
+		return nil, errors.New("no id_token in token response")
+	}
+	claims, err := a.validator.Validate(ctx, idToken)
+	if err != nil {
+		return nil, fmt.Errorf("id_token: %w", err)
+	}
+	// With several audiences the token must be issued to this client
+	if aud := claims.Audience(); len(aud) > 1 && claims.String("azp") != a.clientID {
+		return nil, errors.New("id_token: azp does not match the client")
+	}
+	return claims, nil
+}
+
+// storeIdentity keeps the principal, the subject and the expiry, which
+// expires_in overrides. The refresh token is only kept when the session
+// hides it from the browser.
+func (a *Authenticator) storeIdentity(s *session.Session, tokens *tokenResponse, subject string, principal *middleware.Principal, expiry time.Time) {
+	if tokens.ExpiresIn > 0 {
+		expiry = a.now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	}
+
+	principal.Method = "oidc"
+	data, _ := json.Marshal(principal)
+	s.Set(keyPrincipal, string(data))
+	s.Set(keySubject, subject)
+	s.Set(keyExpiry, float64(expiry.Unix()))
+	if tokens.RefreshToken != "" && s.Confidential() {
+		s.Set(keyRefresh, tokens.RefreshToken)
+	}
+}
+
+// refresh renews the tokens with the refresh token. Providers may omit
+// a new ID token, in which case the previous principal is kept.
+func (a *Authenticator) refresh(ctx context.Context, s *session.Session) error {
+	refreshToken := s.GetString(keyRefresh)
+	if refreshToken == "" {
+		return errors.New("session expired and no refresh token")
+	}
+
+	tokens, err := a.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return err
+	}
+
+	subject := s.GetString(keySubject)
+	principal, ok := storedPrincipal(s)
+	if !ok {
+		return errors.New("no principal in the session")
+	}
+	expiry, _ := s.Get(keyExpiry).(float64)
+	expires := time.Unix(int64(expiry), 0)
+	if tokens.IDToken != "" {
+		fresh, err := a.verifyIDToken(ctx, tokens.IDToken)
+		if err != nil {
+			return err
+		}
+		if fresh.Subject() != subject {
+			return errors.New("refreshed id_token has a different subject")
+		}
+		if principal = a.options.principal(fresh); principal == nil {
+			return errors.New("refreshed claims map to no principal")
+		}
+		expires, _ = fresh.Time("exp")
+	}
+	a.storeIdentity(s, tokens, subject, principal, expires)
+	return nil
+}
+
+// token calls the token endpoint
+func (a *Authenticator) token(ctx context.Context, form url.Values) (*tokenResponse, error) {
+	if a.options.clientSecret == "" {
+		form.Set("client_id", a.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.options.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.options.clientSecret))
+	}
+
+	resp, err := a.options.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token request: %s %s: %s", resp.Status, tokens.Error, tokens.Description)
+	}
+	return &tokens, nil
+}
+
+// Logout returns a handler that ends the session and, if the provider
+// supports it, signs the user out there too
+func (a *Authenticator) Logout() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := requireSession(w, r)
+		if !ok {
+			return
+		}
+		s.Destroy()
+
+		target := a.options.postLogoutURL
+		if target == "" {
+			target = "/"
+		}
+		if a.provider.EndSessionEndpoint != "" {
+			query := url.Values{"client_id": {a.clientID}}
+			if a.options.postLogoutURL != "" {
+				query.Set("post_logout_redirect_uri", a.options.postLogoutURL)
+			}
+			target = withQuery(a.provider.EndSessionEndpoint, query)
+		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
+	})
+}
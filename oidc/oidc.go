@@ -0,0 +1,187 @@
+// Package oidc signs users in with OpenID Connect, using the
+// authorization code flow with PKCE. Identities are kept in the request
+// session, so the session middleware must run first.
+package oidc
+
+/**
+ex usage:
+auth, err := oidc.New(ctx, "https://sso.example.com", "dashboards",
+	oidc.WithClientSecret(secret),
+	oidc.WithRedirectURL("https://dash.example.com/auth/callback"),
+)
+
+router.Use(session.Middleware(session.WithStore(store)))
+router.Route("GET /auth/callback", auth.Callback())
+router.Route("POST /auth/logout", auth.Logout())
+
+app := router.Group(auth.Middleware)
+app.RouteFunc("GET /", dashboard)
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vhellman/lw-router/middleware"
+)
+
+// Discovery is the subset of the provider metadata used by this package
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+type options struct {
+	clientSecret   string
+	redirectURL    string
+	scopes         []string
+	postLogoutURL  string
+	client         *http.Client
+	principal      func(middleware.JWTClaims) *middleware.Principal
+	refreshLeeway  time.Duration
+	jwksRefreshGap time.Duration
+}
+
+type Option func(*options)
+
+// WithClientSecret authenticates to the token endpoint with HTTP Basic.
+// Without it the client is public and relies on PKCE alone.
+func WithClientSecret(secret string) Option {
+	return func(o *options) {
+		o.clientSecret = secret
+	}
+}
+
+// WithRedirectURL sets the absolute URL of the Callback handler. It is
+// required.
+func WithRedirectURL(url string) Option {
+	return func(o *options) {
+		o.redirectURL = url
+	}
+}
+
+// WithScopes sets the requested scopes, "openid profile email" by
+// default. openid is always added.
+func WithScopes(scopes ...string) Option {
+	return func(o *options) {
+		o.scopes = scopes
+	}
+}
+
+// WithPostLogoutRedirect sets where the provider sends users after logout
+func WithPostLogoutRedirect(url string) Option {
+	return func(o *options) {
+		o.postLogoutURL = url
+	}
+}
+
+// WithHTTPClient sets the client for discovery, keys and token requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithPrincipalClaims sets how ID token claims map to the request
+// principal, middleware.PrincipalFromClaims by default. Returning nil
+// denies the user. The principal is mapped at login and on refresh and
+// kept in the session instead of the claims.
+func WithPrincipalClaims(fn func(middleware.JWTClaims) *middleware.Principal) Option {
+	return func(o *options) {
+		o.principal = fn
+	}
+}
+
+// Authenticator runs the login flow and authenticates requests from the
+// identity stored in the session
+type Authenticator struct {
+	clientID  string
+	options   *options
+	provider  Discovery
+	validator *middleware.JWTValidator
+	now       func() time.Time
+}
+
+// New discovers the provider at issuer and creates an Authenticator for
+// clientID
+func New(ctx context.Context, issuer, clientID string, opts ...Option) (*Authenticator, error) {
+	o := &options{
+		scopes:         []string{"profile", "email"},
+		client:         &http.Client{Timeout: 10 * time.Second},
+		principal:      middleware.PrincipalFromClaims,
+		refreshLeeway:  30 * time.Second,
+		jwksRefreshGap: time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.redirectURL == "" {
+		return nil, errors.New("oidc: WithRedirectURL is required")
+	}
+
+	provider, err := discover(ctx, o.client, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := middleware.NewJWKS(provider.JWKSURI,
+		middleware.WithJWKSClient(o.client),
+		middleware.WithJWKSRefreshInterval(o.jwksRefreshGap),
+	)
+	return &Authenticator{
+		clientID: clientID,
+		options:  o,
+		provider: provider,
+		validator: middleware.NewJWTValidator(
+			middleware.WithJWTKeys(keys),
+			middleware.WithJWTAlgorithms(middleware.RS256, middleware.ES256, middleware.EdDSA),
+			middleware.WithJWTIssuer(provider.Issuer),
+			middleware.WithJWTAudience(clientID),
+		),
+		now: time.Now,
+	}, nil
+}
+
+// Provider returns the discovered provider metadata
+func (a *Authenticator) Provider() Discovery {
+	return a.provider
+}
+
+func discover(ctx context.Context, client *http.Client, issuer string) (Discovery, error) {
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Discovery{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Discovery{}, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Discovery{}, fmt.Errorf("oidc: discovery: %s", resp.Status)
+	}
+
+	var d Discovery
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return Discovery{}, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if d.Issuer != issuer {
+		return Discovery{}, fmt.Errorf("oidc: discovery: issuer %q does not match %q", d.Issuer, issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return Discovery{}, errors.New("oidc: discovery: missing endpoints")
+	}
+	return d, nil
+}
@@ -0,0 +1,255 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/middleware"
+	"github.com/vhellman/lw-router/oidc/oidctest"
+	"github.com/vhellman/lw-router/session"
+)
+
+type testApp struct {
+	server   *httptest.Server
+	auth     *Authenticator
+	provider *oidctest.Provider
+	client   *http.Client
+}
+
+// newTestApp runs an application behind the session and oidc middleware
+// against a stand-in provider
+func newTestApp(t *testing.T, secret string, opts ...Option) *testApp {
+	t.Helper()
+	return newSessionApp(t, secret, []session.Option{session.WithStore(session.NewMemoryStore())}, opts...)
+}
+
+// newSessionApp is like newTestApp with custom session options
+func newSessionApp(t *testing.T, secret string, sessionOpts []session.Option, opts ...Option) *testApp {
+	t.Helper()
+	app := &testApp{provider: oidctest.NewProvider("dashboards", secret)}
+	t.Cleanup(app.provider.Close)
+
+	mux := http.NewServeMux()
+	sessionOpts = append(sessionOpts, session.WithCookieSecure(false))
+	app.server = httptest.NewServer(session.Middleware(sessionOpts...)(mux))
+	t.Cleanup(app.server.Close)
+
+	if secret != "" {
+		opts = append(opts, WithClientSecret(secret))
+	}
+	opts = append(opts,
+		WithRedirectURL(app.server.URL+"/auth/callback"),
+		WithPostLogoutRedirect(app.server.URL+"/bye"),
+	)
+	auth, err := New(context.Background(), app.provider.Issuer(), "dashboards", opts...)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	app.auth = auth
+
+	mux.Handle("GET /auth/callback", auth.Callback())
+	mux.Handle("POST /auth/logout", auth.Logout())
+	mux.HandleFunc("GET /bye", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("bye"))
+	})
+	mux.Handle("/", auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := middleware.PrincipalFrom(r.Context())
+		io.WriteString(w, r.URL.RequestURI()+" "+p.ID+" "+p.Method+" "+p.Name+" "+
+			r.Context().Value(middleware.UserIDKey).(string))
+	})))
+
+	jar, _ := cookiejar.New(nil)
+	app.client = &http.Client{Jar: jar}
+	return app
+}
+
+func (app *testApp) get(t *testing.T, path string) (int, string) {
+	t.Helper()
+	resp, err := app.client.Get(app.server.URL + path)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestLoginFlow(t *testing.T) {
+	for name, secret := range map[string]string{"confidential": "s3cret", "public": ""} {
+		app := newTestApp(t, secret)
+
+		// The first request runs the whole flow through the redirects
+		status, body := app.get(t, "/reports?year=2024")
+		if status != http.StatusOK || body != "/reports?year=2024 user-1 oidc Test User user-1" {
+			t.Fatalf("%s: Expected to land on the original page signed in, got %d %q", name, status, body)
+		}
+
+		// Later requests use the session
+		if _, body := app.get(t, "/other"); !strings.HasPrefix(body, "/other user-1") {
+			t.Fatalf("%s: Expected the session to be reused, got %q", name, body)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	app := newTestApp(t, "s3cret")
+	app.get(t, "/")
+
+	resp, err := app.client.Post(app.server.URL+"/auth/logout", "", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "bye" || app.provider.Logouts() != 1 {
+		t.Fatalf("Expected logout at the provider and the post logout page, got %q", body)
+	}
+
+	// Signed out, so the next API call is rejected
+	resp, _ = app.client.Post(app.server.URL+"/api", "application/json", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 after logout, got %d", resp.StatusCode)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	app := newTestApp(t, "s3cret")
+	app.get(t, "/")
+
+	app.provider.SetClaims(map[string]any{"sub": "user-1", "name": "New Name"})
+	app.auth.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	if _, body := app.get(t, "/"); !strings.Contains(body, "New Name") {
+		t.Fatalf("Expected refreshed claims, got %q", body)
+	}
+
+	// A refresh for another subject ends the session and signs in again
+	app.provider.SetClaims(map[string]any{"sub": "user-2"})
+	app.auth.now = func() time.Time { return time.Now().Add(4 * time.Hour) }
+	if _, body := app.get(t, "/"); !strings.HasPrefix(body, "/ user-2") {
+		t.Fatalf("Expected a fresh login as user-2, got %q", body)
+	}
+}
+
+// bigClaims stands in for a directory user with many groups, whose ID
+// token alone would not fit in a cookie
+func bigClaims() map[string]any {
+	groups := make([]string, 100)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("cn=group-%03d,ou=teams,dc=example,dc=com", i)
+	}
+	return map[string]any{"sub": "user-1", "name": "Test User", "groups": groups}
+}
+
+func TestCookieSession_FitsLargeTokens(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+	app := newSessionApp(t, "s3cret", []session.Option{session.WithKeys(key), session.WithEncryption()})
+	app.provider.SetClaims(bigClaims())
+
+	if status, body := app.get(t, "/"); status != http.StatusOK || !strings.HasPrefix(body, "/ user-1") {
+		t.Fatalf("Expected to sign in with a cookie session, got %d %q", status, body)
+	}
+	u, _ := url.Parse(app.server.URL)
+	cookies := app.client.Jar.Cookies(u)
+	if len(cookies) != 1 || len(cookies[0].Value) > 4000 {
+		t.Fatalf("Expected one session cookie within the size limit, got %v", cookies)
+	}
+
+	// The encrypted cookie may keep the refresh token
+	app.provider.SetClaims(map[string]any{"sub": "user-1", "name": "New Name"})
+	app.auth.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, body := app.get(t, "/"); !strings.Contains(body, "New Name") {
+		t.Fatalf("Expected refreshed principal, got %q", body)
+	}
+}
+
+func TestCookieSession_NoRefreshTokenInPlainCookie(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+	app := newSessionApp(t, "s3cret", []session.Option{session.WithKeys(key)})
+	app.get(t, "/")
+
+	u, _ := url.Parse(app.server.URL)
+	cookies := app.client.Jar.Cookies(u)
+	if len(cookies) != 1 {
+		t.Fatalf("Expected a session cookie, got %v", cookies)
+	}
+	payload, _, _ := strings.Cut(cookies[0].Value, ".")
+	data, _ := base64.RawURLEncoding.DecodeString(payload)
+	if !strings.Contains(string(data), keyPrincipal) || strings.Contains(string(data), keyRefresh) {
+		t.Fatalf("Expected the principal without a refresh token, got %s", data)
+	}
+}
+
+func TestCallbackRejects(t *testing.T) {
+	app := newTestApp(t, "s3cret")
+
+	app.provider.DenyNext("access_denied")
+	if status, _ := app.get(t, "/"); status != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 when the provider denies, got %d", status)
+	}
+
+	// A callback without a pending login is a forged or replayed request
+	if status, _ := app.get(t, "/auth/callback?code=x&state=y"); status != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an unknown state, got %d", status)
+	}
+
+	// API clients are not redirected
+	resp, _ := app.client.Post(app.server.URL+"/api", "application/json", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for an unauthenticated POST, got %d", resp.StatusCode)
+	}
+}
+
+func TestCallback_NilPrincipal(t *testing.T) {
+	// Only users with an email may sign in
+	app := newTestApp(t, "s3cret", WithPrincipalClaims(func(claims middleware.JWTClaims) *middleware.Principal {
+		if claims.String("email") == "" {
+			return nil
+		}
+		return middleware.PrincipalFromClaims(claims)
+	}))
+
+	app.provider.SetClaims(map[string]any{"sub": "user-1"})
+	if status, _ := app.get(t, "/"); status != http.StatusForbidden {
+		t.Fatalf("Expected 403 for claims without a principal, got %d", status)
+	}
+	if status, _ := app.get(t, "/"); status == http.StatusOK {
+		t.Fatal("Expected the denied user not to be signed in")
+	}
+}
+
+func TestNew_Discovery(t *testing.T) {
+	provider := oidctest.NewProvider("dashboards", "")
+	defer provider.Close()
+
+	if _, err := New(context.Background(), provider.Issuer(), "dashboards"); err == nil {
+		t.Fatal("Expected an error without a redirect URL")
+	}
+	_, err := New(context.Background(), provider.Issuer()+"/", "dashboards", WithRedirectURL("http://app/cb"))
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("Expected an issuer mismatch error, got %v", err)
+	}
+
+	auth, err := New(context.Background(), provider.Issuer(), "dashboards",
+		WithRedirectURL("http://app/cb"), WithScopes("email", "openid", "offline_access"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if auth.scope() != "openid email offline_access" {
+		t.Fatalf("Expected openid first without duplicates, got %q", auth.scope())
+	}
+	if auth.Provider().TokenEndpoint != provider.Issuer()+"/token" {
+		t.Fatalf("Expected the discovered token endpoint, got %q", auth.Provider().TokenEndpoint)
+	}
+}
@@ -0,0 +1,282 @@
+// Package oidctest runs a stand-in OpenID Connect provider on an
+// httptest server, for testing applications that use package oidc.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Provider signs every authorization request in as the configured user
+// without showing a login page. ID tokens are signed with ES256.
+type Provider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+	// TokenTTL is the lifetime of issued tokens, one hour by default
+	TokenTTL time.Duration
+
+	key *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	claims    map[string]any
+	codes     map[string]authorization
+	refreshes map[string]bool
+	logouts   int
+	denyNext  string
+}
+
+type authorization struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      map[string]any
+}
+
+// NewProvider starts a provider for one client. An empty secret accepts
+// public clients.
+func NewProvider(clientID, clientSecret string) *Provider {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("oidctest: " + err.Error())
+	}
+
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenTTL:     time.Hour,
+		key:          key,
+		claims:       map[string]any{"sub": "user-1", "name": "Test User", "email": "user@example.com"},
+		codes:        make(map[string]authorization),
+		refreshes:    make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /logout", p.logout)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Issuer returns the issuer URL to pass to oidc.New
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// Close shuts the server down
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// SetClaims sets the claims of the user signed in from now on, and of
+// tokens refreshed from now on. They must include "sub".
+func (p *Provider) SetClaims(claims map[string]any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims = maps.Clone(claims)
+}
+
+// DenyNext makes the next authorization request fail with the OAuth
+// error code, such as "access_denied"
+func (p *Provider) DenyNext(code string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.denyNext = code
+}
+
+// Logouts returns how many times the end session endpoint was called
+func (p *Provider) Logouts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.logouts
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/jwks",
+		"end_session_endpoint":                  p.Issuer() + "/logout",
+		"response_types_supported":              []string{"code"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"id_token_signing_alg_values_supported": []string{"ES256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "kid": "oidctest", "use": "sig", "alg": "ES256",
+		"x": base64.RawURLEncoding.EncodeToString(p.key.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(p.key.Y.FillBytes(make([]byte, 32))),
+	}}})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("client_id") != p.ClientID || redirectURI == "" {
+		http.Error(w, "unknown client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	back := url.Values{"state": {q.Get("state")}}
+	p.mu.Lock()
+	deny := p.denyNext
+	p.denyNext = ""
+	p.mu.Unlock()
+
+	switch {
+	case deny != "":
+		back.Set("error", deny)
+	case q.Get("response_type") != "code":
+		back.Set("error", "unsupported_response_type")
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		back.Set("error", "invalid_request")
+		back.Set("error_description", "PKCE with S256 is required")
+	default:
+		code := randomString()
+		p.mu.Lock()
+		p.codes[code] = authorization{
+			redirectURI: redirectURI,
+			challenge:   q.Get("code_challenge"),
+			nonce:       q.Get("nonce"),
+			claims:      maps.Clone(p.claims),
+		}
+		p.mu.Unlock()
+		back.Set("code", code)
+	}
+	http.Redirect(w, r, redirectURI+"?"+back.Encode(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if !p.authenticateClient(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var claims map[string]any
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		auth, ok := p.codes[code]
+		delete(p.codes, code)
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || auth.redirectURI != r.PostForm.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims = maps.Clone(auth.claims)
+		if auth.nonce != "" {
+			claims["nonce"] = auth.nonce
+		}
+	case "refresh_token":
+		// Refreshed tokens carry the current claims of the user
+		token := r.PostForm.Get("refresh_token")
+		ok := p.refreshes[token]
+		delete(p.refreshes, token)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims = maps.Clone(p.claims)
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	refresh := randomString()
+	p.refreshes[refresh] = true
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  randomString(),
+		"token_type":    "Bearer",
+		"id_token":      p.signIDToken(claims),
+		"refresh_token": refresh,
+		"expires_in":    int64(p.TokenTTL / time.Second),
+	})
+}
+
+// authenticateClient accepts client_secret_basic, or client_id alone
+// for public clients
+func (p *Provider) authenticateClient(r *http.Request) bool {
+	if err := r.ParseForm(); err != nil {
+		return false
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return p.ClientSecret == "" && r.PostForm.Get("client_id") == p.ClientID
+	}
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	return id == p.ClientID && subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) == 1
+}
+
+func (p *Provider) logout(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.logouts++
+	p.mu.Unlock()
+
+	if target := r.URL.Query().Get("post_logout_redirect_uri"); target != "" {
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	w.Write([]byte("signed out"))
+}
+
+// signIDToken issues an ES256 ID token for claims
+func (p *Provider) signIDToken(claims map[string]any) string {
+	now := time.Now()
+	payload := maps.Clone(claims)
+	payload["iss"] = p.Issuer()
+	payload["aud"] = p.ClientID
+	payload["iat"] = now.Unix()
+	payload["exp"] = now.Add(p.TokenTTL).Unix()
+
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": "oidctest"})
+	body, _ := json.Marshal(payload)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest[:])
+	if err != nil {
+		panic("oidctest: " + err.Error())
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("oidctest: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
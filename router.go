@@ -1,15 +1,39 @@
 package router
 
-import "net/http"
+import (
+	"log/slog"
+	"net/http"
+)
 
 type Router struct {
 	middlewares []func(http.Handler) http.Handler
 	handler     http.Handler
+	mux         *http.ServeMux
+	routes      []string
+	debugLogger *slog.Logger
+}
+
+// Option configures a Router
+type Option func(*Router)
+
+// WithDebug enables debug mode. Every middleware is timed individually and
+// the results are sent in a Server-Timing header and logged at debug level.
+func WithDebug(logger *slog.Logger) Option {
+	return func(r *Router) {
+		if logger == nil {
+			logger = slog.Default()
+		}
+		r.debugLogger = logger
+	}
 }
 
 // New creates a new middleware router
-func New() *Router {
-	return &Router{}
+func New(opts ...Option) *Router {
+	r := &Router{}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // Use adds middleware to the chain
@@ -20,12 +44,20 @@ func (r *Router) Use(middleware func(http.Handler) http.Handler) {
 // ServeHTTP implements the http.Handler interface
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var handler http.Handler
-	if r.handler != nil {
+	switch {
+	case r.handler != nil:
 		handler = r.handler
-	} else {
+	case r.mux != nil:
+		handler = r.mux
+	default:
 		handler = http.DefaultServeMux
 	}
 
+	if r.debugLogger != nil {
+		r.serveDebug(w, req, handler)
+		return
+	}
+
 	// Chain middleware in reverse order
 	for i := len(r.middlewares) - 1; i >= 0; i-- {
 		handler = r.middlewares[i](handler)
@@ -43,3 +75,33 @@ func (r *Router) Handle(handler http.Handler) {
 func (r *Router) HandleFunc(fn http.HandlerFunc) {
 	r.handler = fn
 }
+
+// Route registers a handler for a http.ServeMux pattern such as
+// "GET /users/{id}". Routes are used when no final handler is set.
+func (r *Router) Route(pattern string, handler http.Handler) {
+	if r.mux == nil {
+		r.mux = http.NewServeMux()
+	}
+	r.mux.Handle(pattern, handler)
+	r.routes = append(r.routes, pattern)
+}
+
+// RouteFunc registers a handler function for a pattern
+func (r *Router) RouteFunc(pattern string, fn http.HandlerFunc) {
+	r.Route(pattern, fn)
+}
+
+// Routes returns the registered route patterns in registration order
+func (r *Router) Routes() []string {
+	return append([]string(nil), r.routes...)
+}
+
+// Middlewares returns the names of the middleware in the chain in the
+// order they run
+func (r *Router) Middlewares() []string {
+	names := make([]string, len(r.middlewares))
+	for i, middleware := range r.middlewares {
+		names[i] = middlewareName(middleware)
+	}
+	return names
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/vhellman/lw-router/middleware"
 )
 
 // TestNew tests the New function
@@ -65,3 +67,38 @@ func TestHandleFunc(t *testing.T) {
 		t.Fatal("Expected handler to be set")
 	}
 }
+
+// TestRoute tests that registered routes are served and listed
+func TestRoute(t *testing.T) {
+	router := New()
+	router.RouteFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("id")))
+	})
+	router.Route("POST /users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Body.String() != "42" {
+		t.Fatalf("Expected path value 42, got %q", w.Body.String())
+	}
+
+	routes := router.Routes()
+	if len(routes) != 2 || routes[0] != "GET /users/{id}" || routes[1] != "POST /users" {
+		t.Fatalf("Unexpected routes: %v", routes)
+	}
+}
+
+// TestMiddlewares tests that middleware names are listed in order
+func TestMiddlewares(t *testing.T) {
+	router := New()
+	router.Use(middleware.RequestID())
+	router.Use(middleware.Logger)
+
+	names := router.Middlewares()
+	if len(names) != 2 || names[0] != "RequestID" || names[1] != "Logger" {
+		t.Fatalf("Unexpected middleware names: %v", names)
+	}
+}
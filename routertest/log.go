@@ -0,0 +1,154 @@
+package routertest
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// LogCapture captures output written through the standard log package,
+// as used by middleware.Logger and middleware.Recoverer
+type LogCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// CaptureLog redirects the standard logger until the test finishes
+func CaptureLog(t testing.TB) *LogCapture {
+	t.Helper()
+	c := &LogCapture{}
+	prev := log.Writer()
+	log.SetOutput(c)
+	t.Cleanup(func() {
+		log.SetOutput(prev)
+	})
+	return c
+}
+
+// Write implements io.Writer
+func (c *LogCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+// String returns everything captured so far
+func (c *LogCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String()
+}
+
+// Contains reports whether the captured output contains substr
+func (c *LogCapture) Contains(substr string) bool {
+	return strings.Contains(c.String(), substr)
+}
+
+// Record is a captured slog record with its attributes flattened
+type Record struct {
+	Level   slog.Level
+	Message string
+	Attrs   map[string]any
+}
+
+// SlogRecorder is a slog.Handler that keeps every record in memory
+type SlogRecorder struct {
+	mu      *sync.Mutex
+	records *[]Record
+	attrs   []groupedAttr
+	groups  []string
+}
+
+// groupedAttr is an attribute with the group path at the time it was added
+type groupedAttr struct {
+	prefix string
+	attr   slog.Attr
+}
+
+// NewSlogRecorder creates an empty recorder
+func NewSlogRecorder() *SlogRecorder {
+	return &SlogRecorder{mu: &sync.Mutex{}, records: &[]Record{}}
+}
+
+// Logger returns a slog.Logger writing to the recorder
+func (h *SlogRecorder) Logger() *slog.Logger {
+	return slog.New(h)
+}
+
+// Enabled implements slog.Handler
+func (h *SlogRecorder) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle implements slog.Handler
+func (h *SlogRecorder) Handle(_ context.Context, r slog.Record) error {
+	rec := Record{Level: r.Level, Message: r.Message, Attrs: map[string]any{}}
+	prefix := strings.Join(h.groups, ".")
+	for _, a := range h.attrs {
+		addAttr(rec.Attrs, a.prefix, a.attr)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(rec.Attrs, prefix, a)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, rec)
+	return nil
+}
+
+// WithAttrs implements slog.Handler
+func (h *SlogRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]groupedAttr{}, h.attrs...)
+	prefix := strings.Join(h.groups, ".")
+	for _, a := range attrs {
+		clone.attrs = append(clone.attrs, groupedAttr{prefix, a})
+	}
+	return &clone
+}
+
+// WithGroup implements slog.Handler
+func (h *SlogRecorder) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.groups = append(append([]string{}, h.groups...), name)
+	return &clone
+}
+
+// Records returns a copy of all captured records
+func (h *SlogRecorder) Records() []Record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Record{}, *h.records...)
+}
+
+// Find returns the first record with the given message
+func (h *SlogRecorder) Find(message string) (Record, bool) {
+	for _, r := range h.Records() {
+		if r.Message == message {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// addAttr flattens groups into dot separated keys
+func addAttr(dst map[string]any, prefix string, a slog.Attr) {
+	key := a.Key
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			addAttr(dst, key, ga)
+		}
+		return
+	}
+	dst[key] = v.Any()
+}
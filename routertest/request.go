@@ -0,0 +1,145 @@
+// Package routertest provides helpers for testing routers, handlers and
+// middleware chains without the httptest boilerplate.
+package routertest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// RequestBuilder builds an *http.Request with a fluent API
+type RequestBuilder struct {
+	method string
+	target string
+	header http.Header
+	query  url.Values
+	body   io.Reader
+	values []contextValue
+	remote string
+	tls    *tls.ConnectionState
+	err    error
+}
+
+type contextValue struct {
+	key   any
+	value any
+}
+
+// NewRequest starts building a request for the given method and target
+func NewRequest(method, target string) *RequestBuilder {
+	return &RequestBuilder{
+		method: method,
+		target: target,
+		header: make(http.Header),
+		query:  make(url.Values),
+	}
+}
+
+// Get starts building a GET request
+func Get(target string) *RequestBuilder {
+	return NewRequest(http.MethodGet, target)
+}
+
+// Post starts building a POST request
+func Post(target string) *RequestBuilder {
+	return NewRequest(http.MethodPost, target)
+}
+
+// Header sets a request header
+func (b *RequestBuilder) Header(key, value string) *RequestBuilder {
+	b.header.Set(key, value)
+	return b
+}
+
+// Query adds a query parameter to the target URL
+func (b *RequestBuilder) Query(key, value string) *RequestBuilder {
+	b.query.Add(key, value)
+	return b
+}
+
+// Body sets the raw request body
+func (b *RequestBuilder) Body(body string) *RequestBuilder {
+	b.body = bytes.NewBufferString(body)
+	return b
+}
+
+// JSON encodes v as the request body and sets the Content-Type header
+func (b *RequestBuilder) JSON(v any) *RequestBuilder {
+	data, err := json.Marshal(v)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.body = bytes.NewReader(data)
+	b.header.Set("Content-Type", "application/json")
+	return b
+}
+
+// RemoteAddr sets the client address, 192.0.2.1:1234 by default
+func (b *RequestBuilder) RemoteAddr(addr string) *RequestBuilder {
+	b.remote = addr
+	return b
+}
+
+// TLS marks the request as received over TLS with state, e.g. to
+// present client certificates
+func (b *RequestBuilder) TLS(state *tls.ConnectionState) *RequestBuilder {
+	b.tls = state
+	return b
+}
+
+// ContextValue stores a value in the request context
+func (b *RequestBuilder) ContextValue(key, value any) *RequestBuilder {
+	b.values = append(b.values, contextValue{key, value})
+	return b
+}
+
+// Build returns the request, failing the test if it cannot be built
+func (b *RequestBuilder) Build(t testing.TB) *http.Request {
+	t.Helper()
+	if b.err != nil {
+		t.Fatalf("Failed to build request: %v", b.err)
+	}
+
+	req := httptest.NewRequest(b.method, b.target, b.body)
+	if len(b.query) > 0 {
+		q := req.URL.Query()
+		for key, values := range b.query {
+			for _, value := range values {
+				q.Add(key, value)
+			}
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+	for key, values := range b.header {
+		req.Header[key] = values
+	}
+	if b.remote != "" {
+		req.RemoteAddr = b.remote
+	}
+	if b.tls != nil {
+		req.TLS = b.tls
+	}
+
+	ctx := req.Context()
+	for _, v := range b.values {
+		ctx = context.WithValue(ctx, v.key, v.value)
+	}
+	return req.WithContext(ctx)
+}
+
+// Do serves the request with handler and returns the recorded response
+func (b *RequestBuilder) Do(t testing.TB, handler http.Handler) *Response {
+	t.Helper()
+	req := b.Build(t)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return newResponse(t, rec)
+}
@@ -0,0 +1,26 @@
+package routertest
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SequentialIDs returns a generator producing prefix-1, prefix-2, ...
+// It can be passed to middleware.WithIDGenerator for deterministic request IDs.
+func SequentialIDs(prefix string) func() (string, error) {
+	var mu sync.Mutex
+	n := 0
+	return func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return fmt.Sprintf("%s-%d", prefix, n), nil
+	}
+}
+
+// FixedID returns a generator that always produces id
+func FixedID(id string) func() (string, error) {
+	return func() (string, error) {
+		return id, nil
+	}
+}
@@ -0,0 +1,147 @@
+package routertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Response wraps a recorded response with assertion helpers
+type Response struct {
+	t        testing.TB
+	Recorder *httptest.ResponseRecorder
+	Result   *http.Response
+}
+
+func newResponse(t testing.TB, rec *httptest.ResponseRecorder) *Response {
+	return &Response{t: t, Recorder: rec, Result: rec.Result()}
+}
+
+// Body returns the response body as a string
+func (r *Response) Body() string {
+	return r.Recorder.Body.String()
+}
+
+// AssertStatus checks the response status code
+func (r *Response) AssertStatus(code int) *Response {
+	r.t.Helper()
+	if r.Recorder.Code != code {
+		r.t.Fatalf("Expected status code %d, got %d", code, r.Recorder.Code)
+	}
+	return r
+}
+
+// AssertHeader checks that a response header has the expected value
+func (r *Response) AssertHeader(key, value string) *Response {
+	r.t.Helper()
+	if got := r.Result.Header.Get(key); got != value {
+		r.t.Fatalf("Expected header %s to be %q, got %q", key, value, got)
+	}
+	return r
+}
+
+// AssertHeaderPresent checks that a response header is set
+func (r *Response) AssertHeaderPresent(key string) *Response {
+	r.t.Helper()
+	if r.Result.Header.Get(key) == "" {
+		r.t.Fatalf("Expected header %s to be set", key)
+	}
+	return r
+}
+
+// AssertHeaderAbsent checks that a response header is not set
+func (r *Response) AssertHeaderAbsent(key string) *Response {
+	r.t.Helper()
+	if got := r.Result.Header.Get(key); got != "" {
+		r.t.Fatalf("Expected header %s to be absent, got %q", key, got)
+	}
+	return r
+}
+
+// AssertBody checks that the response body equals body
+func (r *Response) AssertBody(body string) *Response {
+	r.t.Helper()
+	if got := r.Body(); got != body {
+		r.t.Fatalf("Expected body %q, got %q", body, got)
+	}
+	return r
+}
+
+// AssertBodyContains checks that the response body contains substr
+func (r *Response) AssertBodyContains(substr string) *Response {
+	r.t.Helper()
+	if !strings.Contains(r.Body(), substr) {
+		r.t.Fatalf("Expected body to contain %q, got %q", substr, r.Body())
+	}
+	return r
+}
+
+// AssertJSONPath checks the value at a dot separated path in a JSON body.
+// Array elements are addressed by index, e.g. "items.0.name".
+func (r *Response) AssertJSONPath(path string, want any) *Response {
+	r.t.Helper()
+	got, err := JSONPath([]byte(r.Body()), path)
+	if err != nil {
+		r.t.Fatalf("JSON path %q: %v", path, err)
+	}
+
+	// Round trip the expected value so numbers and nested values compare
+	// the same way they were decoded
+	data, err := json.Marshal(want)
+	if err != nil {
+		r.t.Fatalf("Failed to encode expected value: %v", err)
+	}
+	var expected any
+	if err := json.Unmarshal(data, &expected); err != nil {
+		r.t.Fatalf("Failed to decode expected value: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		r.t.Fatalf("Expected JSON path %q to be %v, got %v", path, expected, got)
+	}
+	return r
+}
+
+// JSONPath decodes data and returns the value at a dot separated path
+func JSONPath(data []byte, path string) (any, error) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return value, nil
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			next, ok := v[part]
+			if !ok {
+				return nil, &pathError{path, part}
+			}
+			value = next
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, &pathError{path, part}
+			}
+			value = v[i]
+		default:
+			return nil, &pathError{path, part}
+		}
+	}
+	return value, nil
+}
+
+type pathError struct {
+	path    string
+	segment string
+}
+
+func (e *pathError) Error() string {
+	return "segment " + strconv.Quote(e.segment) + " not found in " + strconv.Quote(e.path)
+}
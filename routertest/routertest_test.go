@@ -0,0 +1,109 @@
+package routertest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/vhellman/lw-router/middleware"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"query":  r.URL.Query().Get("q"),
+			"name":   body["name"],
+			"ctx":    r.Context().Value(middleware.UserIDKey),
+			"remote": r.RemoteAddr,
+			"items":  []int{1, 2, 3},
+		})
+	})
+
+	Post("/echo?a=1").
+		Header("X-Test", "value").
+		Query("q", "search").
+		JSON(map[string]string{"name": "gopher"}).
+		ContextValue(middleware.UserIDKey, "user-1").
+		RemoteAddr("10.0.0.1:1234").
+		Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertHeader("X-Echo", "value").
+		AssertHeaderAbsent("X-Missing").
+		AssertJSONPath("query", "search").
+		AssertJSONPath("name", "gopher").
+		AssertJSONPath("ctx", "user-1").
+		AssertJSONPath("remote", "10.0.0.1:1234").
+		AssertJSONPath("items.2", 3)
+}
+
+func TestJSONPath_Missing(t *testing.T) {
+	if _, err := JSONPath([]byte(`{"a":{"b":[1]}}`), "a.b.4"); err == nil {
+		t.Fatal("Expected error for out of range index")
+	}
+	if _, err := JSONPath([]byte(`{"a":1}`), "b"); err == nil {
+		t.Fatal("Expected error for missing key")
+	}
+}
+
+func TestSlogRecorder_Audit(t *testing.T) {
+	recorder := NewSlogRecorder()
+	handler := middleware.Audit(
+		middleware.WithHeaders([]string{"Consumer"}),
+		middleware.WithLogger(recorder.Logger().With("service", "test")),
+		middleware.WithMessage("audit"),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	Get("/").Header("Consumer", "billing").Do(t, handler).AssertStatus(http.StatusOK)
+
+	record, ok := recorder.Find("audit")
+	if !ok {
+		t.Fatalf("Expected audit record, got %v", recorder.Records())
+	}
+	if record.Attrs["Consumer"] != "billing" || record.Attrs["service"] != "test" {
+		t.Fatalf("Expected attributes in record, got %v", record.Attrs)
+	}
+}
+
+func TestSlogRecorder_Groups(t *testing.T) {
+	recorder := NewSlogRecorder()
+	recorder.Logger().With("a", 1).WithGroup("g").With("b", 2).Info("grouped", "c", 3)
+
+	record, _ := recorder.Find("grouped")
+	for _, key := range []string{"a", "g.b", "g.c"} {
+		if _, ok := record.Attrs[key]; !ok {
+			t.Fatalf("Expected attribute %q, got %v", key, record.Attrs)
+		}
+	}
+	if len(record.Attrs) != 3 {
+		t.Fatalf("Expected 3 attributes, got %v", record.Attrs)
+	}
+}
+
+func TestCaptureLog_Restores(t *testing.T) {
+	prev := log.Writer()
+	t.Run("capture", func(t *testing.T) {
+		logs := CaptureLog(t)
+		log.Print("captured line")
+		if !logs.Contains("captured line") {
+			t.Fatalf("Expected captured output, got %q", logs.String())
+		}
+	})
+	if log.Writer() != prev {
+		t.Fatal("Expected log output to be restored")
+	}
+}
+
+func TestSequentialIDs(t *testing.T) {
+	handler := middleware.RequestID(middleware.WithIDGenerator(SequentialIDs("id")))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+
+	Get("/").Do(t, handler).AssertHeader(middleware.DefaultRequestIDHeader, "id-1")
+	Get("/").Do(t, handler).AssertHeader(middleware.DefaultRequestIDHeader, "id-2")
+}
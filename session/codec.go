@@ -0,0 +1,125 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxCookieSize is the largest cookie value browsers reliably accept
+const maxCookieSize = 4000
+
+// minKeySize is the shortest secret accepted by WithKeys
+const minKeySize = 32
+
+var errInvalidCookie = errors.New("invalid session cookie")
+
+// cookieKey is derived from one configured secret
+type cookieKey struct {
+	sign    []byte
+	encrypt cipher.AEAD
+}
+
+// deriveKey derives separate signing and encryption keys from secret. It
+// panics if secret is shorter than minKeySize.
+func deriveKey(secret []byte) cookieKey {
+	if len(secret) < minKeySize {
+		panic(fmt.Sprintf("session: keys must be at least %d bytes, got %d", minKeySize, len(secret)))
+	}
+
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+
+	block, err := aes.NewCipher(derive("session encryption"))
+	if err != nil {
+		panic("session: " + err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("session: " + err.Error())
+	}
+	return cookieKey{sign: derive("session signing"), encrypt: aead}
+}
+
+// codec encodes records into cookie values. The first key encodes; all
+// keys decode, so secrets can be rotated without logging users out.
+type codec struct {
+	keys    []cookieKey
+	encrypt bool
+}
+
+func (c *codec) encode(record *Record) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	key := c.keys[0]
+	if c.encrypt {
+		nonce := make([]byte, key.encrypt.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		data = key.encrypt.Seal(nonce, nonce, data, nil)
+	}
+
+	mac := hmac.New(sha256.New, key.sign)
+	mac.Write(data)
+	value := base64.RawURLEncoding.EncodeToString(data) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if len(value) > maxCookieSize {
+		return "", errors.New("session too large for a cookie, use a Store")
+	}
+	return value, nil
+}
+
+func (c *codec) decode(value string) (*Record, error) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errInvalidCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+
+	for _, key := range c.keys {
+		mac := hmac.New(sha256.New, key.sign)
+		mac.Write(data)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			continue
+		}
+
+		if c.encrypt {
+			size := key.encrypt.NonceSize()
+			if len(data) < size {
+				return nil, errInvalidCookie
+			}
+			data, err = key.encrypt.Open(nil, data[:size], data[size:], nil)
+			if err != nil {
+				return nil, errInvalidCookie
+			}
+		}
+
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, errInvalidCookie
+		}
+		return &record, nil
+	}
+	return nil, errInvalidCookie
+}
@@ -0,0 +1,328 @@
+package session
+
+/**
+ex usage:
+// Sessions in signed and encrypted cookies; list old secrets after the
+// current one to rotate keys
+router.Use(session.Middleware(
+	session.WithKeys(currentSecret, previousSecret),
+	session.WithEncryption(),
+))
+
+// Sessions on the server, with only the ID in the cookie
+store, err := session.NewFileStore("/var/lib/app/sessions")
+router.Use(session.Middleware(session.WithStore(store)))
+
+// In handlers
+s, _ := session.From(r.Context())
+s.RenewID() // on login
+s.Set("user", userID)
+*/
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vhellman/lw-router/middleware"
+)
+
+const DefaultCookieName = "session"
+
+type options struct {
+	cookieName string
+	secure     bool
+	sameSite   http.SameSite
+	domain     string
+	keys       [][]byte
+	encrypt    bool
+	store      Store
+	idle       time.Duration
+	absolute   time.Duration
+	logger     *slog.Logger
+	now        func() time.Time
+}
+
+type Option func(*options)
+
+// WithCookieName sets the session cookie name, "session" by default
+func WithCookieName(name string) Option {
+	return func(o *options) {
+		o.cookieName = name
+	}
+}
+
+// WithCookieSecure marks the cookie Secure, which it is by default
+func WithCookieSecure(secure bool) Option {
+	return func(o *options) {
+		o.secure = secure
+	}
+}
+
+// WithCookieSameSite sets the SameSite attribute, Lax by default
+func WithCookieSameSite(mode http.SameSite) Option {
+	return func(o *options) {
+		o.sameSite = mode
+	}
+}
+
+// WithCookieDomain sets the cookie Domain attribute
+func WithCookieDomain(domain string) Option {
+	return func(o *options) {
+		o.domain = domain
+	}
+}
+
+// WithKeys sets the secrets that sign cookie sessions. The first signs
+// new cookies, the others are still accepted so secrets can be rotated.
+// Each secret must be at least 32 random bytes; Middleware panics
+// otherwise.
+func WithKeys(keys ...[]byte) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// WithEncryption encrypts cookie sessions with AES-GCM under keys
+// derived from the secrets, so clients cannot read their values
+func WithEncryption() Option {
+	return func(o *options) {
+		o.encrypt = true
+	}
+}
+
+// WithStore keeps sessions in store, with only the ID in the cookie
+func WithStore(store Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithIdleTimeout ends sessions unused for d, 30 minutes by default
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.idle = d
+	}
+}
+
+// WithAbsoluteTimeout ends sessions d after they started, regardless of
+// activity, 24 hours by default
+func WithAbsoluteTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.absolute = d
+	}
+}
+
+// WithLogger sets the logger for load and save errors
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Middleware loads the session of each request and saves it before the
+// response headers are written. Sessions are only created once a value
+// is set. Cookie sessions must not change after the handler starts
+// writing; server-side sessions are saved again when it returns.
+//
+// The session fingerprint is added to audit events of the request.
+// Middleware panics without keys or a store.
+func Middleware(opts ...Option) func(http.Handler) http.Handler {
+	o := &options{
+		cookieName: DefaultCookieName,
+		secure:     true,
+		sameSite:   http.SameSiteLaxMode,
+		idle:       30 * time.Minute,
+		absolute:   24 * time.Hour,
+		logger:     slog.Default(),
+		now:        time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var c *codec
+	if o.store == nil {
+		if len(o.keys) == 0 {
+			panic("session: WithKeys or WithStore is required")
+		}
+		c = &codec{encrypt: o.encrypt}
+		for _, key := range o.keys {
+			c.keys = append(c.keys, deriveKey(key))
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := o.load(r, c)
+			s.confidential = c == nil || c.encrypt
+			ctx := context.WithValue(r.Context(), contextKey{}, s)
+			ctx = middleware.AddAuditAttrs(ctx, "session", s)
+			r = r.WithContext(ctx)
+
+			sw := &sessionWriter{ResponseWriter: w, commit: func() { o.save(w, r, c, s) }}
+			next.ServeHTTP(sw, r)
+
+			sw.once.Do(sw.commit)
+			if o.store != nil && s.pending() {
+				// Changes made after the headers were sent
+				o.save(nil, r, c, s)
+			}
+		})
+	}
+}
+
+// load returns the session of r, or a new one if it has none or it expired
+func (o *options) load(r *http.Request, c *codec) *Session {
+	now := o.now()
+	cookie, err := r.Cookie(o.cookieName)
+	if err != nil {
+		return newSession(now)
+	}
+
+	var record *Record
+	if c != nil {
+		record, err = c.decode(cookie.Value)
+	} else {
+		record, err = o.store.Load(r.Context(), cookie.Value)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			o.logger.ErrorContext(r.Context(), "Loading session failed", "error", err)
+		}
+	}
+	if err != nil {
+		return newSession(now)
+	}
+
+	if now.Sub(record.LastSeen) >= o.idle || now.Sub(record.Created) >= o.absolute {
+		if o.store != nil {
+			o.store.Delete(r.Context(), record.ID)
+		}
+		s := newSession(now)
+		s.expired = true
+		return s
+	}
+	return &Session{record: *record}
+}
+
+// touchInterval limits how often LastSeen is written for sessions that
+// are only read
+func (o *options) touchInterval() time.Duration {
+	return min(time.Minute, o.idle/2)
+}
+
+// save persists s and sets the cookie on w, if w is not nil
+func (o *options) save(w http.ResponseWriter, r *http.Request, c *codec, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := o.now()
+	ctx := r.Context()
+	if s.previousID != "" && o.store != nil {
+		o.store.Delete(ctx, s.previousID)
+		s.previousID = ""
+	}
+
+	if s.destroyed {
+		if o.store != nil && !s.isNew {
+			o.store.Delete(ctx, s.record.ID)
+		}
+		if w != nil {
+			http.SetCookie(w, o.cookie("", time.Unix(0, 0)))
+		}
+		s.modified = false
+		return
+	}
+
+	touch := !s.isNew && now.Sub(s.record.LastSeen) >= o.touchInterval()
+	if !s.modified && !touch {
+		if s.expired && w != nil {
+			// Clear the stale cookie even if the new session stays empty
+			http.SetCookie(w, o.cookie("", time.Unix(0, 0)))
+		}
+		return
+	}
+	s.record.LastSeen = now
+	expires := s.record.Created.Add(o.absolute)
+
+	value := s.record.ID
+	if c != nil {
+		var err error
+		value, err = c.encode(&s.record)
+		if err != nil {
+			o.logger.ErrorContext(ctx, "Saving session failed", "error", err)
+			return
+		}
+	} else if err := o.store.Save(ctx, &s.record, earliest(expires, now.Add(o.idle))); err != nil {
+		o.logger.ErrorContext(ctx, "Saving session failed", "error", err)
+		return
+	}
+
+	if w != nil {
+		http.SetCookie(w, o.cookie(value, expires))
+	}
+	s.isNew = false
+	s.modified = false
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func (o *options) cookie(value string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     o.cookieName,
+		Value:    value,
+		Path:     "/",
+		Domain:   o.domain,
+		Expires:  expires,
+		Secure:   o.secure,
+		HttpOnly: true,
+		SameSite: o.sameSite,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// pending reports unsaved changes
+func (s *Session) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modified && !s.destroyed
+}
+
+// sessionWriter saves the session before the first byte of the response
+type sessionWriter struct {
+	http.ResponseWriter
+	once   sync.Once
+	commit func()
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	w.once.Do(w.commit)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.once.Do(w.commit)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.once.Do(w.commit)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
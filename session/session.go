@@ -0,0 +1,161 @@
+// Package session provides HTTP sessions stored in signed, optionally
+// encrypted cookies or in a server-side Store.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type contextKey struct{}
+
+// Record is the persisted state of a session. Values are encoded as
+// JSON, so numbers read back as float64.
+type Record struct {
+	ID       string         `json:"id"`
+	Values   map[string]any `json:"values,omitempty"`
+	Created  time.Time      `json:"created"`
+	LastSeen time.Time      `json:"last_seen"`
+}
+
+// Session is the session of the current request. It is safe for
+// concurrent use.
+type Session struct {
+	mu        sync.Mutex
+	record    Record
+	isNew     bool
+	modified  bool
+	destroyed bool
+	// expired marks a new session replacing an expired one
+	expired bool
+	// previousID is the ID replaced by RenewID, deleted on save
+	previousID string
+	// confidential is set on load and never changes
+	confidential bool
+}
+
+func newSession(now time.Time) *Session {
+	return &Session{
+		record: Record{ID: newID(), Created: now, LastSeen: now},
+		isNew:  true,
+	}
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("session: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// From returns the session of the request context
+func From(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(contextKey{}).(*Session)
+	return s, ok
+}
+
+// ID returns the session ID. It is a credential; log Fingerprint instead.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.ID
+}
+
+// Fingerprint returns a short hash of the session ID for logs
+func (s *Session) Fingerprint() string {
+	return fingerprint(s.ID())
+}
+
+// LogValue logs the session as its fingerprint. It is resolved when a
+// record is written, so records after RenewID carry the new fingerprint.
+func (s *Session) LogValue() slog.Value {
+	return slog.StringValue(s.Fingerprint())
+}
+
+func fingerprint(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// IsNew reports whether the session was created by this request
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Confidential reports whether values are hidden from the client,
+// because they are kept in a Store or in an encrypted cookie
+func (s *Session) Confidential() bool {
+	return s.confidential
+}
+
+// Created returns when the session started
+func (s *Session) Created() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.Created
+}
+
+// Get returns a value
+func (s *Session) Get(key string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.Values[key]
+}
+
+// GetString returns a string value
+func (s *Session) GetString(key string) string {
+	v, _ := s.Get(key).(string)
+	return v
+}
+
+// Set stores a value, which must be encodable as JSON
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.Values == nil {
+		s.record.Values = make(map[string]any)
+	}
+	s.record.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes a value
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.modified = true
+	}
+}
+
+// RenewID gives the session a new ID, keeping its values. Call it when
+// the privilege level changes, such as on login, to prevent session
+// fixation.
+func (s *Session) RenewID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previousID == "" && !s.isNew {
+		s.previousID = s.record.ID
+	}
+	s.record.ID = newID()
+	s.modified = true
+}
+
+// Destroy ends the session, e.g. on logout. The cookie is cleared and
+// the stored record deleted when the response is written.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.record.Values = nil
+}
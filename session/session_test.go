@@ -0,0 +1,274 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/middleware"
+	"github.com/vhellman/lw-router/routertest"
+)
+
+// Secrets of the minimum length
+var (
+	testSecret = []byte(strings.Repeat("s", 32))
+	oldSecret  = []byte(strings.Repeat("o", 32))
+	newSecret  = []byte(strings.Repeat("n", 32))
+)
+
+// clock is a controllable time source for timeout tests
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func withClock(c *clock) Option {
+	return func(o *options) {
+		o.now = c.Now
+	}
+}
+
+// counterHandler counts visits in the session and writes the count
+var counterHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	s, _ := From(r.Context())
+	switch r.URL.Path {
+	case "/login":
+		s.RenewID()
+		s.Set("user", "ada")
+	case "/logout":
+		s.Destroy()
+	case "/visit":
+		count, _ := s.Get("count").(float64)
+		s.Set("count", count+1)
+	}
+	w.Write([]byte(s.GetString("user")))
+})
+
+// visit sends a request with cookie and returns the response and the
+// session cookie it set, if any
+func visit(t *testing.T, handler http.Handler, path string, cookie *http.Cookie) (*routertest.Response, *http.Cookie) {
+	t.Helper()
+	req := routertest.Get(path)
+	if cookie != nil {
+		req.Header("Cookie", cookie.Name+"="+cookie.Value)
+	}
+	resp := req.Do(t, handler)
+	for _, c := range resp.Result.Cookies() {
+		if c.Name == DefaultCookieName {
+			return resp, c
+		}
+	}
+	return resp, nil
+}
+
+func TestCookieSession(t *testing.T) {
+	handler := Middleware(WithKeys(testSecret))(counterHandler)
+
+	// Reading an empty session creates nothing
+	if _, cookie := visit(t, handler, "/", nil); cookie != nil {
+		t.Fatalf("Expected no cookie for an unused session, got %v", cookie)
+	}
+
+	_, cookie := visit(t, handler, "/login", nil)
+	if cookie == nil || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Expected a secure session cookie, got %v", cookie)
+	}
+	resp, _ := visit(t, handler, "/", cookie)
+	resp.AssertBody("ada")
+
+	// A tampered cookie starts over
+	tampered := *cookie
+	tampered.Value = "x" + cookie.Value[1:]
+	resp, _ = visit(t, handler, "/", &tampered)
+	resp.AssertBody("")
+
+	_, cleared := visit(t, handler, "/logout", cookie)
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("Expected logout to clear the cookie, got %v", cleared)
+	}
+}
+
+func TestSession_Confidential(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts []Option
+		want bool
+	}{
+		"signed cookie":    {[]Option{WithKeys(testSecret)}, false},
+		"encrypted cookie": {[]Option{WithKeys(testSecret), WithEncryption()}, true},
+		"store":            {[]Option{WithStore(NewMemoryStore())}, true},
+	} {
+		var got bool
+		handler := Middleware(tc.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, _ := From(r.Context())
+			got = s.Confidential()
+		}))
+		routertest.Get("/").Do(t, handler)
+		if got != tc.want {
+			t.Fatalf("%s: Expected Confidential %v, got %v", name, tc.want, got)
+		}
+	}
+}
+
+func TestCookieSession_ShortKeyPanics(t *testing.T) {
+	for _, key := range [][]byte{nil, []byte("secret"), testSecret[:31]} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Expected a %d byte key to panic", len(key))
+				}
+			}()
+			Middleware(WithKeys(testSecret, key))
+		}()
+	}
+}
+
+func TestCookieSession_KeyRotation(t *testing.T) {
+	old := Middleware(WithKeys(oldSecret), WithEncryption())(counterHandler)
+	rotated := Middleware(WithKeys(newSecret, oldSecret), WithEncryption())(counterHandler)
+	retired := Middleware(WithKeys(newSecret), WithEncryption())(counterHandler)
+
+	_, cookie := visit(t, old, "/login", nil)
+	if strings.Contains(cookie.Value, "ada") {
+		t.Fatal("Expected the session values to be encrypted")
+	}
+
+	resp, _ := visit(t, rotated, "/", cookie)
+	resp.AssertBody("ada")
+
+	// Once written with the new key it survives retiring the old one
+	_, renewed := visit(t, rotated, "/visit", cookie)
+	resp, _ = visit(t, retired, "/", renewed)
+	resp.AssertBody("ada")
+	resp, _ = visit(t, retired, "/", cookie)
+	resp.AssertBody("")
+}
+
+func TestSession_Timeouts(t *testing.T) {
+	c := &clock{now: time.Now()}
+	handler := Middleware(
+		WithKeys(testSecret),
+		WithIdleTimeout(10*time.Minute),
+		WithAbsoluteTimeout(time.Hour),
+		withClock(c),
+	)(counterHandler)
+
+	_, cookie := visit(t, handler, "/login", nil)
+
+	// Regular activity keeps the session alive up to the absolute timeout
+	for range 5 {
+		c.Advance(9 * time.Minute)
+		resp, next := visit(t, handler, "/", cookie)
+		resp.AssertBody("ada")
+		if next == nil {
+			t.Fatal("Expected the idle deadline to be extended")
+		}
+		cookie = next
+	}
+	c.Advance(16 * time.Minute)
+	resp, cleared := visit(t, handler, "/", cookie)
+	resp.AssertBody("")
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("Expected the expired cookie to be cleared, got %v", cleared)
+	}
+
+	// Idle sessions expire
+	_, cookie = visit(t, handler, "/login", nil)
+	c.Advance(11 * time.Minute)
+	resp, _ = visit(t, handler, "/", cookie)
+	resp.AssertBody("")
+}
+
+func TestStoreSession(t *testing.T) {
+	store := NewMemoryStore()
+	handler := Middleware(WithStore(store))(counterHandler)
+
+	_, anonymous := visit(t, handler, "/visit", nil)
+	if store.Len() != 1 || len(anonymous.Value) != 43 {
+		t.Fatalf("Expected the cookie to hold only the ID, got %q", anonymous.Value)
+	}
+
+	// Login renews the ID and deletes the old record
+	_, cookie := visit(t, handler, "/login", anonymous)
+	if cookie.Value == anonymous.Value {
+		t.Fatal("Expected login to renew the session ID")
+	}
+	if _, err := store.Load(context.Background(), anonymous.Value); err != ErrNotFound {
+		t.Fatalf("Expected the old session to be deleted, got %v", err)
+	}
+	record, _ := store.Load(context.Background(), cookie.Value)
+	if record.Values["count"] != float64(1) || record.Values["user"] != "ada" {
+		t.Fatalf("Expected values to survive renewal, got %v", record.Values)
+	}
+
+	visit(t, handler, "/logout", cookie)
+	if store.Len() != 0 {
+		t.Fatalf("Expected logout to delete the session, got %d", store.Len())
+	}
+}
+
+func TestStoreSession_ChangesAfterWrite(t *testing.T) {
+	store := NewMemoryStore()
+	handler := Middleware(WithStore(store))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, _ := From(r.Context())
+		s.Set("step", "one")
+		w.Write([]byte("streaming"))
+		s.Set("step", "two")
+	}))
+
+	_, cookie := visit(t, handler, "/", nil)
+	record, _ := store.Load(context.Background(), cookie.Value)
+	if record.Values["step"] != "two" {
+		t.Fatalf("Expected changes after the first write to be saved, got %v", record.Values)
+	}
+}
+
+func TestSession_AuditFingerprint(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	var fingerprint string
+	handler := middleware.Audit(middleware.WithLogger(recorder.Logger()))(
+		Middleware(WithKeys(testSecret))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, _ := From(r.Context())
+			fingerprint = s.Fingerprint()
+			middleware.AuditEvent(r, "Access denied")
+		})),
+	)
+
+	routertest.Get("/").Do(t, handler)
+
+	record, _ := recorder.Find("Access denied")
+	if record.Attrs["session"] != fingerprint || len(fingerprint) != 16 {
+		t.Fatalf("Expected session fingerprint %s, got %v", fingerprint, record.Attrs)
+	}
+}
+
+func TestSession_AuditFingerprintAfterRenewID(t *testing.T) {
+	recorder := routertest.NewSlogRecorder()
+	var before, after string
+	handler := middleware.Audit(middleware.WithLogger(recorder.Logger()))(
+		Middleware(WithKeys(testSecret))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, _ := From(r.Context())
+			before = s.Fingerprint()
+			s.RenewID()
+			after = s.Fingerprint()
+			middleware.AuditEvent(r, "Signed in")
+		})),
+	)
+
+	routertest.Get("/").Do(t, handler)
+
+	record, _ := recorder.Find("Signed in")
+	if before == after || record.Attrs["session"] != after {
+		t.Fatalf("Expected the renewed fingerprint %s, got %v", after, record.Attrs)
+	}
+}
+
+func TestMiddleware_RequiresKeys(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic without keys or store")
+		}
+	}()
+	Middleware()
+}
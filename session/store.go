@@ -0,0 +1,193 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vhellman/lw-router/internal/fsutil"
+)
+
+// ErrNotFound is returned by a Store for unknown or expired sessions
+var ErrNotFound = errors.New("session not found")
+
+// Store keeps sessions on the server, with only the ID in the cookie
+type Store interface {
+	Load(ctx context.Context, id string) (*Record, error)
+	// Save stores the record until expires
+	Save(ctx context.Context, record *Record, expires time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+type storedRecord struct {
+	Record  Record    `json:"record"`
+	Expires time.Time `json:"expires"`
+}
+
+// MemoryStore keeps sessions in memory, for single instance deployments
+// and tests
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]storedRecord
+	saves   int
+	now     func() time.Time
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]storedRecord), now: time.Now}
+}
+
+// Load implements Store
+func (s *MemoryStore) Load(_ context.Context, id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.records[id]
+	if !ok || !s.now().Before(stored.Expires) {
+		return nil, ErrNotFound
+	}
+	record := stored.Record
+	record.Values = cloneValues(record.Values)
+	return &record, nil
+}
+
+// Save implements Store
+func (s *MemoryStore) Save(_ context.Context, record *Record, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := storedRecord{Record: *record, Expires: expires}
+	stored.Record.Values = cloneValues(record.Values)
+	s.records[record.ID] = stored
+
+	// Sweep expired sessions now and then
+	s.saves++
+	if s.saves%1000 == 0 {
+		now := s.now()
+		for id, r := range s.records {
+			if !now.Before(r.Expires) {
+				delete(s.records, id)
+			}
+		}
+	}
+	return nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+// Len returns the number of stored sessions, including expired ones not
+// yet swept
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func cloneValues(values map[string]any) map[string]any {
+	if values == nil {
+		return nil
+	}
+	clone := make(map[string]any, len(values))
+	for k, v := range values {
+		clone[k] = v
+	}
+	return clone
+}
+
+// FileStore keeps each session in a JSON file in a directory. File names
+// are hashes of the session ID, so IDs never reach the file system.
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFileStore creates a store in dir, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load implements Store
+func (s *FileStore) Load(_ context.Context, id string) (*Record, error) {
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored storedRecord
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Record.ID != id || !s.now().Before(stored.Expires) {
+		return nil, ErrNotFound
+	}
+	return &stored.Record, nil
+}
+
+// Save implements Store
+func (s *FileStore) Save(_ context.Context, record *Record, expires time.Time) error {
+	data, err := json.Marshal(storedRecord{Record: *record, Expires: expires})
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(s.path(record.ID), data)
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Sweep removes expired session files. Run it periodically.
+func (s *FileStore) Sweep() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var stored storedRecord
+		if json.Unmarshal(data, &stored) != nil || !now.Before(stored.Expires) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
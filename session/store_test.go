@@ -0,0 +1,64 @@
+package session
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx := context.Background()
+
+	record := &Record{ID: "../../etc/passwd", Values: map[string]any{"user": "ada"}, Created: time.Now()}
+	if err := store.Save(ctx, record, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expired := &Record{ID: "expired"}
+	store.Save(ctx, expired, time.Now().Add(-time.Second))
+
+	got, err := store.Load(ctx, record.ID)
+	if err != nil || got.Values["user"] != "ada" {
+		t.Fatalf("Expected the saved record, got %v, %v", got, err)
+	}
+	if _, err := store.Load(ctx, "expired"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for an expired session, got %v", err)
+	}
+
+	if err := store.Sweep(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("Expected one session file after sweeping, got %d", len(entries))
+	}
+
+	store.Delete(ctx, record.ID)
+	if _, err := store.Load(ctx, record.ID); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.Delete(ctx, record.ID); err != nil {
+		t.Fatalf("Expected deleting twice to succeed, got %v", err)
+	}
+}
+
+func TestMemoryStore_Expiry(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	store.Save(ctx, &Record{ID: "a", Values: map[string]any{"k": "v"}}, time.Now().Add(time.Hour))
+	store.Save(ctx, &Record{ID: "b"}, time.Now().Add(-time.Second))
+
+	got, _ := store.Load(ctx, "a")
+	got.Values["k"] = "changed"
+	if again, _ := store.Load(ctx, "a"); again.Values["k"] != "v" {
+		t.Fatal("Expected loaded records not to alias the store")
+	}
+	if _, err := store.Load(ctx, "b"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for an expired session, got %v", err)
+	}
+}
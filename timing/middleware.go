@@ -0,0 +1,68 @@
+package timing
+
+import (
+	"net/http"
+)
+
+// Middleware collects metrics recorded during the request and sends them in
+// a Server-Timing header before the first write. Metrics recorded after the
+// response has started are sent as a trailer, which clients only receive
+// for streamed (chunked) responses.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r.Context())
+		c := ctx.Value(contextKey{}).(*collector)
+		tw := &timingWriter{ResponseWriter: w, collector: c}
+
+		next.ServeHTTP(tw, r.WithContext(ctx))
+
+		metrics, _ := c.since(tw.sent)
+		if len(metrics) == 0 {
+			return
+		}
+		if !tw.wroteHeader {
+			w.Header().Add(HeaderName, Format(metrics))
+			return
+		}
+		w.Header().Set(http.TrailerPrefix+HeaderName, Format(metrics))
+	})
+}
+
+type timingWriter struct {
+	http.ResponseWriter
+	collector   *collector
+	sent        int
+	wroteHeader bool
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		var metrics []Metric
+		metrics, w.sent = w.collector.since(0)
+		if len(metrics) > 0 {
+			w.Header().Add(HeaderName, Format(metrics))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,131 @@
+// Package timing collects named server timing metrics during a request and
+// sends them to the client in a Server-Timing header.
+//
+//	router.Use(timing.Middleware)
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		stop := timing.Start(r.Context(), "db")
+//		rows := query(r.Context())
+//		stop()
+//		...
+//	}
+package timing
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderName is the response header (and trailer) metrics are sent in
+const HeaderName = "Server-Timing"
+
+// Metric is a single Server-Timing entry
+type Metric struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// String formats the metric as a Server-Timing entry,
+// e.g. db;dur=12.3;desc="primary"
+func (m Metric) String() string {
+	var b strings.Builder
+	b.WriteString(invalidToken.ReplaceAllString(m.Name, "_"))
+	fmt.Fprintf(&b, ";dur=%s", formatDuration(m.Duration))
+	if m.Description != "" {
+		b.WriteString(`;desc="`)
+		b.WriteString(quoteEscaper.Replace(m.Description))
+		b.WriteString(`"`)
+	}
+	return b.String()
+}
+
+var (
+	invalidToken = regexp.MustCompile(`[^A-Za-z0-9!#$%&'*+\-.^_|~]`)
+	quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// formatDuration formats d in milliseconds without trailing zeros
+func formatDuration(d time.Duration) string {
+	ms := fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+	ms = strings.TrimRight(ms, "0")
+	return strings.TrimSuffix(ms, ".")
+}
+
+type contextKey struct{}
+
+// collector holds the metrics recorded for one request
+type collector struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+func (c *collector) add(m Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = append(c.metrics, m)
+}
+
+// since returns the metrics recorded from index i onwards and the new length
+func (c *collector) since(i int) ([]Metric, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Metric(nil), c.metrics[i:]...), len(c.metrics)
+}
+
+// NewContext returns a context that collects metrics. Middleware does this
+// for every request; it is only needed when recording outside of it.
+func NewContext(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(contextKey{}).(*collector); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, &collector{})
+}
+
+// Add records a metric. It does nothing if ctx does not collect metrics.
+func Add(ctx context.Context, name string, dur time.Duration) {
+	AddMetric(ctx, Metric{Name: name, Duration: dur})
+}
+
+// AddWithDesc records a metric with a description
+func AddWithDesc(ctx context.Context, name string, dur time.Duration, desc string) {
+	AddMetric(ctx, Metric{Name: name, Duration: dur, Description: desc})
+}
+
+// AddMetric records a metric
+func AddMetric(ctx context.Context, m Metric) {
+	if c, ok := ctx.Value(contextKey{}).(*collector); ok {
+		c.add(m)
+	}
+}
+
+// Start starts timing name and returns a function that records the metric
+// when called
+func Start(ctx context.Context, name string) func() {
+	start := time.Now()
+	return func() {
+		Add(ctx, name, time.Since(start))
+	}
+}
+
+// Metrics returns the metrics recorded so far
+func Metrics(ctx context.Context) []Metric {
+	if c, ok := ctx.Value(contextKey{}).(*collector); ok {
+		metrics, _ := c.since(0)
+		return metrics
+	}
+	return nil
+}
+
+// Format joins metrics into a Server-Timing header value
+func Format(metrics []Metric) string {
+	entries := make([]string, len(metrics))
+	for i, m := range metrics {
+		entries[i] = m.String()
+	}
+	return strings.Join(entries, ", ")
+}
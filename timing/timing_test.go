@@ -0,0 +1,77 @@
+package timing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vhellman/lw-router/routertest"
+)
+
+func TestMetric_String(t *testing.T) {
+	m := Metric{Name: "db", Duration: 12300 * time.Microsecond, Description: `primary "rw"`}
+	if got := m.String(); got != `db;dur=12.3;desc="primary \"rw\""` {
+		t.Fatalf("Unexpected metric format: %s", got)
+	}
+	if got := (Metric{Name: "cache hit", Duration: 2 * time.Millisecond}).String(); got != "cache_hit;dur=2" {
+		t.Fatalf("Unexpected metric format: %s", got)
+	}
+}
+
+func TestAdd_WithoutCollector(t *testing.T) {
+	Add(context.Background(), "db", time.Millisecond)
+	if metrics := Metrics(context.Background()); metrics != nil {
+		t.Fatalf("Expected no metrics, got %v", metrics)
+	}
+}
+
+func TestMiddleware_Header(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddWithDesc(r.Context(), "db", 12300*time.Microsecond, "primary")
+		Add(r.Context(), "render", time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+
+	routertest.Get("/").Do(t, handler).
+		AssertStatus(http.StatusOK).
+		AssertHeader(HeaderName, `db;dur=12.3;desc="primary", render;dur=1`)
+}
+
+func TestMiddleware_NoWrite(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Add(r.Context(), "noop", 0)
+	}))
+
+	routertest.Get("/").Do(t, handler).AssertHeader(HeaderName, "noop;dur=0")
+}
+
+func TestMiddleware_StreamedTrailer(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Add(r.Context(), "auth", time.Millisecond)
+		w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+
+		Add(r.Context(), "stream", 5*time.Millisecond)
+		w.Write([]byte("second chunk"))
+	}))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.ReadAll(resp.Body)
+
+	if got := resp.Header.Get(HeaderName); got != "auth;dur=1" {
+		t.Fatalf("Expected header metrics, got %q", got)
+	}
+	if got := resp.Trailer.Get(HeaderName); got != "stream;dur=5" {
+		t.Fatalf("Expected trailer metrics, got %q", got)
+	}
+}